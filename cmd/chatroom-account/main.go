@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/edobrowo/gochatroom/pkg/account"
+	"github.com/edobrowo/gochatroom/pkg/config"
+)
+
+// Creates an account ahead of time, which is how operator accounts are provisioned since /register refuses their names
+// The password is read from the first line of standard input so it never appears in the process list
+func main() {
+	defaults := config.DefaultServerConfig()
+	path := flag.String("accounts", defaults.DataPath(defaults.AccountsFile), "account store path")
+	username := flag.String("username", "", "username of the account to create")
+	flag.Parse()
+
+	if *username == "" {
+		fmt.Fprintln(os.Stderr, "A -username is required")
+		os.Exit(2)
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "Expected a password on standard input")
+		os.Exit(1)
+	}
+
+	err = os.MkdirAll(filepath.Dir(*path), 0700)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	store, err := account.OpenStore(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	err = store.Register(*username, password)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Registered %v in %v\n", *username, *path)
+}
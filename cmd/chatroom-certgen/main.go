@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/tlsutil"
+)
+
+// Generates a self-signed certificate for running the server with TLS in development
+func main() {
+	hosts := flag.String("hosts", "127.0.0.1,localhost", "comma-separated IP addresses and DNS names the certificate is valid for")
+	certFile := flag.String("cert", "cert.pem", "certificate output path")
+	keyFile := flag.String("key", "key.pem", "private key output path")
+	days := flag.Int("days", 365, "validity period in days")
+	flag.Parse()
+
+	fingerprint, err := tlsutil.GenerateSelfSigned(strings.Split(*hosts, ","), time.Duration(*days)*24*time.Hour, *certFile, *keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Wrote %v and %v\n", *certFile, *keyFile)
+	fmt.Printf("SHA-256 fingerprint: %v\n", fingerprint)
+}
@@ -2,58 +2,101 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
 	"net"
 	"os"
+	"strings"
 
 	"github.com/edobrowo/gochatroom/pkg/client"
+	"github.com/edobrowo/gochatroom/pkg/config"
+	"github.com/edobrowo/gochatroom/pkg/tlsutil"
 )
 
-const (
-	ServerHost = "127.0.0.1"
-	ServerPort = 9988
-)
+func main() {
 
-func ValidateUsername(s string) (bool, string) {
-	if s == "" {
-		return false, "username cannot be empty"
+	// Defaults, then the -config file, then flags
+	cfg, err := config.LoadClient(os.Args[0], os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
 	}
-	if len(s) > 8 {
-		return false, "username must be 8 characters or less :)"
+	if err != nil {
+		// The flag package has already reported bad flags along with the usage
+		if _, ok := err.(*config.ConfigError); ok {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(2)
 	}
-	return true, ""
-}
-
-func main() {
 
 	scanner := bufio.NewScanner(os.Stdin)
-	var username string
+	username := cfg.Username
+	password := cfg.Password
 
-	fmt.Println("Enter username: ")
+	// The server checks usernames against its own policy and explains any refusal
+	// Usernames given up front are for scripted clients, so they are never prompted for
+	prompted := username == ""
+	if prompted {
+		username, password = promptLogin(scanner)
+	}
 
-	for {
-		scanner.Scan()
-		username = scanner.Text()
+	reconnect := client.DefaultBackoff
+	reconnect.MaxAttempts = cfg.ReconnectAttempts
 
-		if valid, desc := ValidateUsername(username); valid {
-			break
-		} else {
-			fmt.Println("Username invalid: ", desc)
+	var tlsConfig *tls.Config
+	if cfg.TLS || cfg.TLSCA != "" || cfg.TLSFingerprint != "" {
+		tlsConfig, err = tlsutil.LoadClientConfig(cfg.TLSCA, cfg.TLSFingerprint, cfg.TLSServerName)
+		if err != nil {
+			fmt.Println(err)
+			return
 		}
 	}
 
-	// Must specify username and CLIChat interface before starting the client
-	client := client.Client{Username: username, IO: &client.CLIChat{Username: username}}
-
-	ip := net.ParseIP(ServerHost)
-	addr := net.TCPAddr{IP: ip, Port: ServerPort, Zone: ""}
-
-	// Client code controls request/response loop
-	err := client.Connect(addr)
+	addr, err := net.ResolveTCPAddr("tcp", cfg.Address)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	return
+	for {
+		// Must specify username and CLIChat interface before starting the client
+		chat := client.Client{Username: username, Password: password, Reconnect: reconnect, CodecName: cfg.Codec, TLSConfig: tlsConfig, IO: &client.CLIChat{Username: username, TimeFormat: cfg.TimeFormat}}
+
+		// Client code controls request/response loop
+		err = chat.Connect(*addr)
+
+		// Only the server knows its username policy, so a name it refuses is asked for again
+		if prompted && errors.Is(err, client.ErrInvalidUsername) {
+			fmt.Println(err)
+			username, password = promptLogin(scanner)
+			continue
+		}
+		if errors.Is(err, client.ErrInvalidUsername) {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+}
+
+// Asks for a username until one is given without spaces, then for a password
+func promptLogin(scanner *bufio.Scanner) (string, string) {
+	fmt.Println("Enter username: ")
+
+	var username string
+	for scanner.Scan() {
+		username = strings.TrimSpace(scanner.Text())
+		if username != "" && !strings.ContainsAny(username, " \t") {
+			break
+		}
+		fmt.Println("Usernames cannot be empty or contain spaces")
+	}
+
+	fmt.Println("Enter password (leave blank to join as a guest): ")
+	scanner.Scan()
+	return username, scanner.Text()
 }
@@ -1,27 +1,173 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/edobrowo/gochatroom/pkg/account"
+	"github.com/edobrowo/gochatroom/pkg/ban"
+	"github.com/edobrowo/gochatroom/pkg/config"
+	"github.com/edobrowo/gochatroom/pkg/mailbox"
 	"github.com/edobrowo/gochatroom/pkg/server"
-)
-
-const (
-	ServerHost = "127.0.0.1"
-	ServerPort = 9988
+	"github.com/edobrowo/gochatroom/pkg/tlsutil"
+	"github.com/edobrowo/gochatroom/pkg/validation"
 )
 
 func main() {
 
-	server := server.Server{}
+	// Defaults, then the -config file, then flags
+	cfg, err := config.LoadServer(os.Args[0], os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		// The flag package has already reported bad flags along with the usage
+		if _, ok := err.(*config.ConfigError); ok {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(2)
+	}
+
+	logOutput := os.Stdout
+	if cfg.LogFile != "" && cfg.LogFile != "-" {
+		logOutput, err = os.OpenFile(cfg.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalln("Could not open log file: ", err)
+		}
+	}
+	logger := log.New(logOutput, "gochatroom-server:", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
+
+	if cfg.DataDir != "" {
+		err = os.MkdirAll(cfg.DataDir, 0700)
+		if err != nil {
+			logger.Fatalln("Could not create data directory: ", err)
+		}
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", cfg.Address)
+	if err != nil {
+		logger.Fatalln("Invalid listen address: ", err)
+	}
+
+	var accounts *account.Store
+	if cfg.AccountsFile != "" {
+		accounts, err = account.OpenStore(cfg.AccountsFile)
+		if err != nil {
+			logger.Fatalln("Could not open account store: ", err)
+		}
+	}
+
+	var history server.HistoryStore = server.NewMemoryHistory(cfg.HistorySize)
+	if cfg.HistoryFile != "" {
+		history, err = server.OpenFileHistory(cfg.HistoryFile, cfg.HistorySize)
+		if err != nil {
+			logger.Fatalln("Could not open history log: ", err)
+		}
+	}
+
+	ids := server.NewMessageIDs()
+	if cfg.MessageIDsFile != "" {
+		ids, err = server.OpenMessageIDs(cfg.MessageIDsFile)
+		if err != nil {
+			logger.Fatalln("Could not open message ID file: ", err)
+		}
+	}
+
+	bans := ban.NewList()
+	if cfg.BansFile != "" {
+		bans, err = ban.OpenList(cfg.BansFile)
+		if err != nil {
+			logger.Fatalln("Could not open ban list: ", err)
+		}
+	}
+
+	mail := mailbox.NewStore()
+	if cfg.MailboxFile != "" {
+		mail, err = mailbox.OpenStore(cfg.MailboxFile)
+		if err != nil {
+			logger.Fatalln("Could not open mailbox: ", err)
+		}
+	}
+	mail.Capacity = cfg.MailboxCapacity
+
+	overflow, err := server.ParseOverflowPolicy(cfg.OverflowPolicy)
+	if err != nil {
+		logger.Fatalln("Invalid configuration: ", err)
+	}
+
+	operators := make(map[string]bool)
+	for _, name := range cfg.Operators {
+		operators[name] = true
+	}
+
+	server := server.Server{
+		Log:              logger,
+		Accounts:         accounts,
+		History:          history,
+		HistoryReplay:    cfg.HistoryReplay,
+		MessageIDs:       ids,
+		MaxClients:       cfg.MaxClients,
+		MaxMessageLength: cfg.MaxMessageLength,
+		MOTD:             cfg.MOTD,
+		SessionGrace:     time.Duration(cfg.SessionGrace) * time.Second,
+		Operators:        operators,
+		Bans:             bans,
+		Mailbox:          mail,
+
+		UsernamePolicy: validation.Policy{
+			MinLength:          cfg.UsernameMinLength,
+			MaxLength:          cfg.UsernameMaxLength,
+			AllowedPunctuation: cfg.UsernamePunctuation,
+			ASCIIOnly:          cfg.UsernameASCIIOnly,
+			Reserved:           cfg.ReservedNames,
+		},
+
+		MaxConnectionsPerIP: cfg.MaxConnectionsPerIP,
+		Flood: server.FloodPolicy{
+			Rate:            cfg.RateLimit,
+			Burst:           cfg.RateBurst,
+			MuteAfter:       cfg.FloodMuteAfter,
+			MuteDuration:    time.Duration(cfg.FloodMute) * time.Second,
+			DisconnectAfter: cfg.FloodDisconnectAfter,
+			Forgive:         time.Duration(cfg.FloodForgive) * time.Second,
+		},
+
+		QueueSize:    cfg.QueueSize,
+		Overflow:     overflow,
+		WriteTimeout: time.Duration(cfg.WriteTimeout) * time.Second,
+
+		GatewayAddr:    cfg.WebSocketAddress,
+		GatewayOrigins: cfg.WebSocketOrigins,
+
+		AdminAddr:  cfg.AdminAddress,
+		AdminToken: cfg.AdminToken,
+
+		MetricsAddr: cfg.MetricsAddress,
+	}
 
-	ip := net.ParseIP(ServerHost)
-	addr := net.TCPAddr{IP: ip, Port: ServerPort, Zone: ""}
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		server.TLSConfig, err = tlsutil.LoadServerConfig(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			logger.Fatalln("Could not load TLS certificate: ", err)
+		}
+	}
 
-	server.Log = log.New(os.Stdout, "gochatroom-server:", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
+	// The first SIGINT/SIGTERM shuts down gracefully; a second one falls back to the default behaviour and exits
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		server.Log.Println("Received signal: ", sig)
+		server.Shutdown("")
+	}()
 
 	// Server code controls request/response loop
-	server.Listen(addr)
+	server.Listen(*addr)
 }
@@ -0,0 +1,226 @@
+package account
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	SaltSize = 16
+
+	// PBKDF2 iteration count for new accounts; stored per account so it can be raised later
+	DefaultIterations = 100000
+
+	MinPasswordLength = 6
+)
+
+type AccountError struct {
+	Message string
+}
+
+func (err *AccountError) Error() string {
+	return err.Message
+}
+
+type Account struct {
+	Username   string
+	Salt       []byte
+	Iterations int
+	Hash       []byte
+}
+
+// File-backed account store; each line holds "username salt iterations hash" with hex-encoded salt and hash
+type Store struct {
+	Path     string
+	accounts map[string]Account
+	mutex    sync.Mutex
+}
+
+func OpenStore(path string) (*Store, error) {
+	store := &Store{Path: path, accounts: make(map[string]Account)}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		acc, err := parseAccount(scanner.Text())
+		if err != nil {
+			return nil, &AccountError{Message: fmt.Sprintf("%v:%v: %v", path, line, err)}
+		}
+
+		// Later lines win, so re-registering an account only ever appends
+		store.accounts[acc.Username] = acc
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func parseAccount(line string) (Account, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 4 {
+		return Account{}, &AccountError{Message: "expected 4 fields"}
+	}
+
+	salt, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return Account{}, err
+	}
+
+	iterations, err := strconv.Atoi(fields[2])
+	if err != nil || iterations <= 0 {
+		return Account{}, &AccountError{Message: "invalid iteration count"}
+	}
+
+	hash, err := hex.DecodeString(fields[3])
+	if err != nil {
+		return Account{}, err
+	}
+
+	return Account{Username: fields[0], Salt: salt, Iterations: iterations, Hash: hash}, nil
+}
+
+func (store *Store) Exists(username string) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	_, ok := store.accounts[username]
+	return ok
+}
+
+func (store *Store) Usernames() []string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	names := make([]string, 0, len(store.accounts))
+	for name := range store.accounts {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Creates an account and appends it to the store file
+func (store *Store) Register(username string, password string) error {
+	if username == "" || strings.ContainsAny(username, " \t\r\n") {
+		return &AccountError{Message: "Username cannot be registered"}
+	}
+	if len(password) < MinPasswordLength {
+		return &AccountError{Message: fmt.Sprintf("Password must be at least %v characters", MinPasswordLength)}
+	}
+
+	salt := make([]byte, SaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return err
+	}
+
+	acc := Account{Username: username, Salt: salt, Iterations: DefaultIterations}
+	acc.Hash = HashPassword(password, acc.Salt, acc.Iterations)
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.accounts[username]; ok {
+		return &AccountError{Message: fmt.Sprintf("Username %v is already registered", username)}
+	}
+
+	file, err := os.OpenFile(store.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%v %x %v %x\n", acc.Username, acc.Salt, acc.Iterations, acc.Hash)
+	if err != nil {
+		return err
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+
+	store.accounts[username] = acc
+
+	return nil
+}
+
+// Reports whether the password matches the stored hash; unknown users never authenticate
+func (store *Store) Authenticate(username string, password string) bool {
+	store.mutex.Lock()
+	acc, ok := store.accounts[username]
+	store.mutex.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	hash := HashPassword(password, acc.Salt, acc.Iterations)
+	return subtle.ConstantTimeCompare(hash, acc.Hash) == 1
+}
+
+// Derives a 32-byte key with PBKDF2-HMAC-SHA256
+func HashPassword(password string, salt []byte, iterations int) []byte {
+	return PBKDF2(sha256.New, []byte(password), salt, iterations, sha256.Size)
+}
+
+// Derives a key of keyLength bytes with PBKDF2 (RFC 8018), using HMAC with the given hash as the pseudorandom function
+func PBKDF2(h func() hash.Hash, password []byte, salt []byte, iterations int, keyLength int) []byte {
+	prf := hmac.New(h, password)
+	key := make([]byte, 0, keyLength)
+
+	var blockIndex [4]byte
+	for block := uint32(1); len(key) < keyLength; block++ {
+		binary.BigEndian.PutUint32(blockIndex[:], block)
+
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(blockIndex[:])
+		u := prf.Sum(nil)
+
+		t := make([]byte, len(u))
+		copy(t, u)
+
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+
+		key = append(key, t...)
+	}
+
+	return key[:keyLength]
+}
@@ -0,0 +1,92 @@
+package account
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test vectors from RFC 6070, which are defined for HMAC-SHA1
+// The vector with 16,777,216 iterations takes far longer than the rest, so it only runs with GOCHATROOM_SLOW_TESTS set
+func TestPBKDF2RFC6070(t *testing.T) {
+	tests := []struct {
+		password   string
+		salt       string
+		iterations int
+		keyLength  int
+		expected   string
+		slow       bool
+	}{
+		{"password", "salt", 1, 20, "0c60c80f961f0e71f3a9b524af6012062fe037a6", false},
+		{"password", "salt", 2, 20, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957", false},
+		{"password", "salt", 4096, 20, "4b007901b765489abead49d926f721d065a429c1", false},
+		{"password", "salt", 16777216, 20, "eefe3d61cd4da4e4e9945b3d6ba2158c2634e984", true},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 25, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038", false},
+		{"pass\x00word", "sa\x00lt", 4096, 16, "56fa6aa75548099dcc37d7f03425e0c3", false},
+	}
+
+	for _, test := range tests {
+		if test.slow && os.Getenv("GOCHATROOM_SLOW_TESTS") == "" {
+			continue
+		}
+
+		key := PBKDF2(sha1.New, []byte(test.password), []byte(test.salt), test.iterations, test.keyLength)
+		if hex.EncodeToString(key) != test.expected {
+			t.Errorf("PBKDF2(%q, %q, %v, %v) = %x, expected %v", test.password, test.salt, test.iterations, test.keyLength, key, test.expected)
+		}
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	tests := []struct {
+		iterations int
+		expected   string
+	}{
+		{1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+	}
+
+	for _, test := range tests {
+		key := HashPassword("password", []byte("salt"), test.iterations)
+		if hex.EncodeToString(key) != test.expected {
+			t.Errorf("HashPassword with %v iterations = %x, expected %v", test.iterations, key, test.expected)
+		}
+	}
+}
+
+func TestStoreRegisterAndAuthenticate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Register("alice", "hunter22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Register("alice", "another1") == nil {
+		t.Error("Registering a taken username succeeded")
+	}
+	if store.Register("bob", "short") == nil {
+		t.Error("Registering with a short password succeeded")
+	}
+
+	// Accounts are read back from the file
+	reopened, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.Authenticate("alice", "hunter22") {
+		t.Error("Correct password was refused")
+	}
+	if reopened.Authenticate("alice", "hunter23") {
+		t.Error("Incorrect password was accepted")
+	}
+	if reopened.Authenticate("bob", "hunter22") {
+		t.Error("Unknown user was authenticated")
+	}
+}
@@ -0,0 +1,248 @@
+package ban
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Kind string
+
+const (
+	// Bans a username, enforced when the user registers
+	Kind_User Kind = "user"
+
+	// Bans an address, enforced when the connection is accepted
+	Kind_IP Kind = "ip"
+)
+
+type BanError struct {
+	Message string
+}
+
+func (err *BanError) Error() string {
+	return err.Message
+}
+
+type Ban struct {
+	Kind   Kind
+	Target string
+
+	// Zero for a permanent ban
+	Expires time.Time
+
+	By     string
+	Reason string
+}
+
+func (ban Ban) Permanent() bool {
+	return ban.Expires.IsZero()
+}
+
+func (ban Ban) Expired(now time.Time) bool {
+	return !ban.Permanent() && now.After(ban.Expires)
+}
+
+// Describes the ban to the banned user
+func (ban Ban) Describe() string {
+	msg := "You are banned from this server"
+	if !ban.Permanent() {
+		msg += fmt.Sprintf(" until %v", ban.Expires.UTC().Format(time.RFC1123))
+	}
+	if ban.Reason != "" {
+		msg += ": " + ban.Reason
+	}
+	return msg
+}
+
+// File-backed ban list; each line holds "kind target expires by reason", with expires as a unix time or 0 for permanent
+// An empty path keeps the list in memory only
+type List struct {
+	Path  string
+	bans  map[string]Ban
+	mutex sync.Mutex
+}
+
+func key(kind Kind, target string) string {
+	return string(kind) + " " + target
+}
+
+func NewList() *List {
+	return &List{bans: make(map[string]Ban)}
+}
+
+func OpenList(path string) (*List, error) {
+	list := NewList()
+	list.Path = path
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		ban, err := parseBan(scanner.Text())
+		if err != nil {
+			return nil, &BanError{Message: fmt.Sprintf("%v:%v: %v", path, line, err)}
+		}
+
+		list.bans[key(ban.Kind, ban.Target)] = ban
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func parseBan(line string) (Ban, error) {
+	fields := strings.SplitN(line, " ", 5)
+	if len(fields) < 4 {
+		return Ban{}, &BanError{Message: "expected at least 4 fields"}
+	}
+
+	ban := Ban{Kind: Kind(fields[0]), Target: fields[1], By: fields[3]}
+	if ban.Kind != Kind_User && ban.Kind != Kind_IP {
+		return Ban{}, &BanError{Message: fmt.Sprintf("unknown ban kind %v", fields[0])}
+	}
+
+	expires, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return Ban{}, &BanError{Message: "invalid expiry"}
+	}
+	if expires != 0 {
+		ban.Expires = time.Unix(expires, 0)
+	}
+
+	if len(fields) == 5 {
+		ban.Reason = fields[4]
+	}
+
+	return ban, nil
+}
+
+func formatBan(ban Ban) string {
+	var expires int64
+	if !ban.Permanent() {
+		expires = ban.Expires.Unix()
+	}
+	return strings.TrimSpace(fmt.Sprintf("%v %v %v %v %v", ban.Kind, ban.Target, expires, ban.By, ban.Reason))
+}
+
+// Finds an active ban, discarding it if it has expired
+func (list *List) Find(kind Kind, target string) (Ban, bool) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+
+	ban, ok := list.bans[key(kind, target)]
+	if !ok {
+		return Ban{}, false
+	}
+
+	if ban.Expired(time.Now()) {
+		delete(list.bans, key(kind, target))
+		return Ban{}, false
+	}
+
+	return ban, true
+}
+
+// Adds or replaces a ban and saves the list
+func (list *List) Add(ban Ban) error {
+	if ban.Target == "" || strings.ContainsAny(ban.Target, " \t\r\n") {
+		return &BanError{Message: "Invalid ban target"}
+	}
+	if ban.By == "" {
+		ban.By = "-"
+	}
+	ban.Reason = strings.Join(strings.Fields(ban.Reason), " ")
+
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+
+	list.bans[key(ban.Kind, ban.Target)] = ban
+	return list.save()
+}
+
+// Lifts a ban and saves the list; reports whether the ban existed
+func (list *List) Remove(kind Kind, target string) (bool, error) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+
+	_, ok := list.bans[key(kind, target)]
+	if !ok {
+		return false, nil
+	}
+
+	delete(list.bans, key(kind, target))
+	return true, list.save()
+}
+
+// Returns the active bans
+func (list *List) All() []Ban {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+
+	now := time.Now()
+	bans := make([]Ban, 0, len(list.bans))
+	for _, ban := range list.bans {
+		if !ban.Expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	return bans
+}
+
+// Rewrites the whole file, since bans are removed as well as added; the rename keeps the old list intact if writing fails
+func (list *List) save() error {
+	if list.Path == "" {
+		return nil
+	}
+
+	tmp := list.Path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	now := time.Now()
+	for _, ban := range list.bans {
+		if ban.Expired(now) {
+			continue
+		}
+		fmt.Fprintln(writer, formatBan(ban))
+	}
+
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, list.Path)
+}
@@ -0,0 +1,139 @@
+package ban
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.txt")
+
+	list, err := OpenList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	err = list.Add(Ban{Kind: Kind_User, Target: "mallory", By: "alice", Reason: "  spamming \n the room "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = list.Add(Ban{Kind: Kind_IP, Target: "10.0.0.1", Expires: expires})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, ok := reopened.Find(Kind_User, "mallory")
+	if !ok || !user.Permanent() || user.By != "alice" || user.Reason != "spamming the room" {
+		t.Errorf("User ban read back as %+v", user)
+	}
+
+	// Bans without an operator are recorded as made by -
+	ip, ok := reopened.Find(Kind_IP, "10.0.0.1")
+	if !ok || !ip.Expires.Equal(expires) || ip.By != "-" {
+		t.Errorf("Address ban read back as %+v", ip)
+	}
+
+	// Kinds are kept apart
+	_, ok = reopened.Find(Kind_IP, "mallory")
+	if ok {
+		t.Error("A user ban matched an address")
+	}
+
+	removed, err := reopened.Remove(Kind_User, "mallory")
+	if err != nil || !removed {
+		t.Fatalf("Remove returned %v, %v", removed, err)
+	}
+	removed, err = reopened.Remove(Kind_User, "mallory")
+	if err != nil || removed {
+		t.Errorf("Removing a missing ban returned %v, %v", removed, err)
+	}
+
+	reopened, err = OpenList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.All()) != 1 {
+		t.Errorf("Expected only the address ban after removing the user ban, got %+v", reopened.All())
+	}
+}
+
+func TestExpiredBans(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.txt")
+
+	list, err := OpenList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = list.Add(Ban{Kind: Kind_User, Target: "mallory", Expires: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok := list.Find(Kind_User, "mallory")
+	if ok {
+		t.Error("Found an expired ban")
+	}
+	if len(list.All()) != 0 {
+		t.Error("Listed an expired ban")
+	}
+
+	// Expired bans are not written out
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Errorf("Saved an expired ban: %q", data)
+	}
+}
+
+func TestInvalidBans(t *testing.T) {
+	list := NewList()
+	for _, target := range []string{"", "two words", "tab\there"} {
+		err := list.Add(Ban{Kind: Kind_User, Target: target})
+		if err == nil {
+			t.Errorf("Banned invalid target %q", target)
+		}
+	}
+
+	for _, line := range []string{"user mallory", "group mallory 0 alice", "user mallory soon alice"} {
+		path := filepath.Join(t.TempDir(), "bans.txt")
+		err := os.WriteFile(path, []byte(line+"\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = OpenList(path)
+		if err == nil {
+			t.Errorf("Read invalid line %q", line)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		ban      Ban
+		expected string
+	}{
+		{Ban{}, "You are banned from this server"},
+		{Ban{Reason: "spam"}, "You are banned from this server: spam"},
+		{Ban{Expires: expires, Reason: "spam"}, "You are banned from this server until Wed, 02 Jan 2030 03:04:05 UTC: spam"},
+	}
+
+	for _, test := range tests {
+		if test.ban.Describe() != test.expected {
+			t.Errorf("Described %+v as %q, expected %q", test.ban, test.ban.Describe(), test.expected)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Exponential backoff between reconnection attempts
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+
+	// Attempts before giving up; 0 disables reconnecting
+	MaxAttempts int
+}
+
+var DefaultBackoff = Backoff{Initial: 500 * time.Millisecond, Max: 30 * time.Second, MaxAttempts: 10}
+
+func (backoff Backoff) Enabled() bool {
+	return backoff.MaxAttempts > 0
+}
+
+// Uses full jitter: a uniformly random delay up to a cap that doubles with each attempt.
+// Spreading retries out keeps clients from reconnecting in lockstep after a server restart.
+func (backoff Backoff) Delay(attempt int) time.Duration {
+	limit := backoff.Initial
+	for i := 0; i < attempt && limit < backoff.Max; i++ {
+		limit *= 2
+	}
+	if limit > backoff.Max {
+		limit = backoff.Max
+	}
+	if limit <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(limit)) + 1)
+}
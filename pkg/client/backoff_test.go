@@ -0,0 +1,45 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+// Delays are drawn from (0, limit], where the limit doubles with each attempt up to Max
+func TestBackoffDelay(t *testing.T) {
+	backoff := Backoff{Initial: 100 * time.Millisecond, Max: time.Second, MaxAttempts: 10}
+
+	limits := []time.Duration{100, 200, 400, 800, 1000, 1000, 1000}
+	for attempt, limit := range limits {
+		limit *= time.Millisecond
+
+		var longest time.Duration
+		for i := 0; i < 1000; i++ {
+			delay := backoff.Delay(attempt)
+			if delay <= 0 || delay > limit {
+				t.Fatalf("Attempt %v waited %v, expected up to %v", attempt, delay, limit)
+			}
+			if delay > longest {
+				longest = delay
+			}
+		}
+
+		// Full jitter spreads delays across the whole range, so the longest of many comes close to the limit
+		if longest < limit/2 {
+			t.Errorf("Attempt %v waited at most %v of a possible %v", attempt, longest, limit)
+		}
+	}
+
+	// Attempts far beyond the cap neither overflow nor exceed it
+	delay := backoff.Delay(1000)
+	if delay <= 0 || delay > backoff.Max {
+		t.Errorf("Attempt 1000 waited %v", delay)
+	}
+
+	if (Backoff{}).Enabled() || (Backoff{}).Delay(3) != 0 {
+		t.Error("The zero Backoff reconnects")
+	}
+	if !DefaultBackoff.Enabled() {
+		t.Error("DefaultBackoff does not reconnect")
+	}
+}
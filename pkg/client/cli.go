@@ -4,19 +4,96 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/edobrowo/gochatroom/pkg/response"
 )
 
+const (
+	DefaultTimeFormat = "15:04"
+
+	// Recent messages remembered so replies to them can be shown with a quote
+	MaxQuotedMessages = 1000
+
+	// Longest quote shown with a reply, in characters
+	MaxQuoteLength = 40
+
+	// Terminal escapes wrapped around lines that mention the user, who is also alerted with the bell
+	HighlightStart = "\x1b[1;7m"
+	HighlightEnd   = "\x1b[0m"
+	Bell           = "\a"
+)
+
 type CLIChat struct {
 	Username string
+
+	// Layout for the local time shown before stamped messages, as in package time; empty hides timestamps
+	TimeFormat string
+
+	// Recent messages by ID, oldest first in quoteOrder
+	quotes     map[uint64]response.Response
+	quoteOrder []uint64
+}
+
+func (cli *CLIChat) remember(res response.Response) {
+	if cli.quotes == nil {
+		cli.quotes = make(map[uint64]response.Response)
+	}
+	if _, ok := cli.quotes[res.ID]; !ok {
+		cli.quoteOrder = append(cli.quoteOrder, res.ID)
+	}
+	cli.quotes[res.ID] = res
+
+	if len(cli.quoteOrder) > MaxQuotedMessages {
+		delete(cli.quotes, cli.quoteOrder[0])
+		cli.quoteOrder = cli.quoteOrder[1:]
+	}
+}
+
+// Describes the message a reply answers, quoting the start of it when it was seen
+func (cli *CLIChat) quote(parentID uint64) string {
+	parent, ok := cli.quotes[parentID]
+	if !ok {
+		return fmt.Sprintf("message %v", parentID)
+	}
+
+	text := []rune(parent.Content)
+	if len(text) > MaxQuoteLength {
+		text = append(text[:MaxQuoteLength], []rune("...")...)
+	}
+	return fmt.Sprintf("%v \"%v\"", parent.SenderName, string(text))
+}
+
+// Reports whether a message or edit from someone else mentions the local user
+func (cli *CLIChat) Mentioned(res response.Response) bool {
+	if res.ResType != response.ResponseType_Message && res.ResType != response.ResponseType_Edit {
+		return false
+	}
+	return res.SenderName != cli.Username && res.MentionsUser(cli.Username)
+}
+
+// Counts are shown as "👍 2  🎉 1", the current totals after every change
+func describeReactions(content string) string {
+	reactions, err := response.ParseReactions(content)
+	if err != nil {
+		return content
+	}
+	if len(reactions) == 0 {
+		return "none"
+	}
+
+	counts := make([]string, len(reactions))
+	for i, reaction := range reactions {
+		counts[i] = fmt.Sprintf("%v %v", reaction.Emoji, reaction.Count)
+	}
+	return strings.Join(counts, "  ")
 }
 
 func (cli *CLIChat) GetInput(sender chan<- string) {
 	scanner := bufio.NewScanner(os.Stdin)
 
-	for {
-		scanner.Scan()
+	// Stop reading once stdin is exhausted, e.g. when input is piped in by a script
+	for scanner.Scan() {
 		userInput := scanner.Text()
 		sender <- userInput
 	}
@@ -30,6 +107,10 @@ func (cli *CLIChat) DisplayOutput(receiver <-chan response.Response) {
 		switch res.ResType {
 		case response.ResponseType_Message:
 			str = fmt.Sprintf("%v: %v", res.SenderName, res.Content)
+			if res.ParentID != 0 {
+				str = fmt.Sprintf("%v, replying to %v: %v", res.SenderName, cli.quote(res.ParentID), res.Content)
+			}
+			cli.remember(res)
 			break
 		case response.ResponseType_Whisper:
 			if res.ReceiverName == cli.Username {
@@ -41,13 +122,47 @@ func (cli *CLIChat) DisplayOutput(receiver <-chan response.Response) {
 		case response.ResponseType_ServerPriv:
 			str = fmt.Sprintf("from SERVER: %v", res.Content)
 			break
-		case response.ResponseType_ServerAll:
+		case response.ResponseType_ServerAll, response.ResponseType_ServerRoom:
 			str = fmt.Sprintf("SERVER: %v", res.Content)
 			break
+		case response.ResponseType_Error:
+			str = fmt.Sprintf("error from SERVER: %v", res.Content)
+			break
+		case response.ResponseType_Edit:
+			str = fmt.Sprintf("%v edited: %v", res.SenderName, res.Content)
+			parent, ok := cli.quotes[res.ID]
+			if ok {
+				parent.Content = res.Content
+				cli.quotes[res.ID] = parent
+			}
+			break
+		case response.ResponseType_Delete:
+			str = fmt.Sprintf("%v deleted a message", res.SenderName)
+			if res.ReceiverName != res.SenderName {
+				str = fmt.Sprintf("%v's message was deleted by %v", res.SenderName, res.ReceiverName)
+			}
+			delete(cli.quotes, res.ID)
+			break
+		case response.ResponseType_Reactions:
+			str = fmt.Sprintf("reactions to %v: %v", cli.quote(res.ID), describeReactions(res.Content))
+			break
 		default:
 			str = "Unknown response"
 		}
 
+		// Messages are shown with their ID, which /edit and /delete refer to
+		if res.ID != 0 && str != "" && res.ResType != response.ResponseType_ServerAll && res.ResType != response.ResponseType_ServerRoom {
+			str = fmt.Sprintf("(%v) %v", res.ID, str)
+		}
+
+		if cli.TimeFormat != "" && !res.Time.IsZero() && str != "" {
+			str = fmt.Sprintf("[%v] %v", res.Time.Local().Format(cli.TimeFormat), str)
+		}
+
+		if cli.Mentioned(res) && str != "" {
+			str = HighlightStart + str + HighlightEnd + Bell
+		}
+
 		fmt.Println(str)
 	}
 }
@@ -1,9 +1,15 @@
 package client
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
+	"time"
 
+	"github.com/edobrowo/gochatroom/pkg/codec"
+	"github.com/edobrowo/gochatroom/pkg/protocol"
 	"github.com/edobrowo/gochatroom/pkg/request"
 	"github.com/edobrowo/gochatroom/pkg/response"
 )
@@ -37,6 +43,12 @@ const (
 	Disconnected
 	ErrorState
 	Unknown
+	ConnectionLost
+)
+
+const (
+	// Input typed while disconnected is held up to this many requests
+	MaxPendingRequests = 100
 )
 
 type ClientStatus struct {
@@ -47,8 +59,31 @@ type ClientStatus struct {
 type Client struct {
 	ServerAddr net.TCPAddr
 	Connection net.Conn
+	Codec      codec.Codec
 	Username   string
+	Password   string
+	TLSConfig  *tls.Config
 	IO         MessageIO
+
+	// Wire format spoken to the server: codec.Name_Binary (the default) or codec.Name_JSON
+	CodecName string
+
+	// Issued by the server on registration and used to resume the session after a dropped connection
+	SessionToken string
+
+	// Version and features agreed with the server in the hello exchange
+	Protocol protocol.Hello
+
+	// Reconnection policy; the zero value disables reconnecting
+	Reconnect Backoff
+
+	// Guards the connection fields, which are replaced on reconnect
+	mutex     sync.Mutex
+	connected bool
+	pending   []request.Request
+
+	// Read during the handshake but meant for display; delivered before anything else on the connection
+	unread []response.Response
 }
 
 func (client *Client) Connect(addr net.TCPAddr) error {
@@ -64,13 +99,7 @@ func (client *Client) Connect(addr net.TCPAddr) error {
 		return &net.AddrError{Err: "Address cannot be empty", Addr: TCPJoinHostPort(client.ServerAddr)}
 	}
 
-	connection, err := net.Dial("tcp", TCPJoinHostPort(addr))
-	if err != nil {
-		return err
-	}
-
 	client.ServerAddr = addr
-	client.Connection = connection
 
 	// Unprocessed chat inputs
 	sender := make(chan string)
@@ -84,42 +113,185 @@ func (client *Client) Connect(addr net.TCPAddr) error {
 	// Indicates that the client should terminate
 	done := make(chan ClientStatus)
 
-	// Monitor goroutine controls the client state machine
-	go client.Monitor(status, done)
-
-	// Must send an initial request to register the user's username, which serves as their ID
-	req := request.Request{ReqType: request.RequestType_Status, StType: request.Status_Register, SenderName: client.Username}
-	client.Send(req, status)
-	res, err := client.Receive()
-	if err != nil || res.ResType == response.ResponseType_TerminateConnection {
+	// Failing to connect the first time is reported straight away rather than retried
+	err := client.Dial()
+	if err != nil {
 		return err
 	}
 
+	// Monitor goroutine controls the client state machine
+	go client.Monitor(status, done, receiver)
+
 	// Receives unprocessed input, parses it, and sends to server
 	go client.HandleInput(sender, status)
 
 	// Receives and deserializes responses from the server
-	go client.HandleResponses(receiver, status)
+	go client.HandleResponses(client.Codec, receiver, status)
 
 	go client.IO.GetInput(sender)
 	go client.IO.DisplayOutput(receiver)
 
 	status <- ClientStatus{Code: Connected}
 
-	// Once a status is received from done, the client terminates
+	// Once a status is received from done, the client terminates.
+	// The channels are left open since input and reconnect goroutines may still hold them.
 	result := <-done
 
-	close(sender)
-	close(receiver)
-	close(status)
-	close(done)
+	client.mutex.Lock()
+	client.connected = false
 	client.Connection.Close()
+	client.mutex.Unlock()
 
 	return result.Error
 }
 
+// Opens a connection and registers on it, resuming the previous session if there is one.
+// Requests queued while disconnected are sent before the connection is handed to Send.
+func (client *Client) Dial() error {
+	var connection net.Conn
+	var err error
+	if client.TLSConfig != nil {
+		connection, err = tls.Dial("tcp", TCPJoinHostPort(client.ServerAddr), client.TLSConfig)
+	} else {
+		connection, err = net.Dial("tcp", TCPJoinHostPort(client.ServerAddr))
+	}
+	if err != nil {
+		return err
+	}
+
+	name := client.CodecName
+	if name == "" {
+		name = codec.Name_Binary
+	}
+	conn, err := codec.New(name, connection, connection)
+	if err != nil {
+		connection.Close()
+		return err
+	}
+
+	err = client.Handshake(conn)
+	if err != nil {
+		connection.Close()
+		return err
+	}
+
+	client.mutex.Lock()
+	client.Connection = connection
+	client.Codec = conn
+	client.mutex.Unlock()
+
+	// Input can keep arriving while the queue is flushed, so flush until it stays empty
+	for {
+		client.mutex.Lock()
+		pending := client.pending
+		client.pending = nil
+		if len(pending) == 0 {
+			client.connected = true
+			client.mutex.Unlock()
+			return nil
+		}
+		client.mutex.Unlock()
+
+		for _, req := range pending {
+			err = conn.WriteRequest(req)
+			if err != nil {
+				connection.Close()
+				return err
+			}
+		}
+	}
+}
+
+// Agrees on a protocol version and features with the server, which answers with a hello of its own
+func (client *Client) Negotiate(conn codec.Codec) error {
+	local := protocol.NewHello(protocol.Supported)
+	err := conn.WriteHello(local)
+	if err != nil {
+		return err
+	}
+
+	// Servers refuse incompatible clients with a termination notice in place of a hello
+	remote, err := conn.ReadHello()
+	if refused, ok := err.(*codec.RefusedError); ok {
+		return &RejectedError{Err: ErrorFromResponse(refused.Response)}
+	}
+	if _, ok := err.(*protocol.ProtocolError); ok {
+		return &RejectedError{Err: err}
+	}
+	if err != nil {
+		return &ClientError{Message: "Could not receive hello from server"}
+	}
+
+	agreed, err := protocol.Negotiate(local, remote)
+	if err != nil {
+		return &RejectedError{Err: err}
+	}
+
+	client.Protocol = agreed
+	return nil
+}
+
+// Must send an initial request to register the user's username, which serves as their ID
+func (client *Client) Handshake(conn codec.Codec) error {
+	client.mutex.Lock()
+	client.unread = nil
+	client.mutex.Unlock()
+
+	err := client.Negotiate(conn)
+	if err != nil {
+		return err
+	}
+
+	if client.SessionToken != "" && client.Protocol.Features.Has(protocol.Feature_Sessions) {
+		req := request.Request{ReqType: request.RequestType_Status, StType: request.Status_Resume, SenderName: client.Username, Content: client.SessionToken}
+		err := conn.WriteRequest(req)
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Receive(conn)
+		if err != nil {
+			return err
+		}
+		if res.ResType == response.ResponseType_Session {
+			client.SessionToken = res.Content
+			return nil
+		}
+
+		// The session expired, so register from scratch on the same connection
+		client.SessionToken = ""
+	}
+
+	// Registered usernames also require the account password, which guests leave empty
+	req := request.Request{ReqType: request.RequestType_Status, StType: request.Status_Register, SenderName: client.Username, Content: client.Password}
+	err = conn.WriteRequest(req)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Receive(conn)
+	if err != nil {
+		return err
+	}
+	if res.ResType == response.ResponseType_TerminateConnection {
+		return &RejectedError{Err: ErrorFromResponse(res)}
+	}
+
+	// Servers without sessions answer with the usual notices, which still need to be shown
+	if res.ResType == response.ResponseType_Session && client.Protocol.Features.Has(protocol.Feature_Sessions) {
+		client.SessionToken = res.Content
+		return nil
+	}
+
+	client.mutex.Lock()
+	client.unread = append(client.unread, res)
+	client.mutex.Unlock()
+
+	return nil
+}
+
 // Controls the client state machine
-func (client Client) Monitor(status <-chan ClientStatus, done chan<- ClientStatus) {
+func (client *Client) Monitor(status chan ClientStatus, done chan<- ClientStatus, receiver chan<- response.Response) {
 	for {
 		switch statusVal := <-status; statusVal.Code {
 		case Connected:
@@ -128,8 +300,16 @@ func (client Client) Monitor(status <-chan ClientStatus, done chan<- ClientStatu
 			continue
 		case Receiving:
 			continue
+		case ConnectionLost:
+			if !client.Reconnect.Enabled() {
+				done <- ClientStatus{Code: ErrorState, Error: statusVal.Error}
+				return
+			}
+
+			fmt.Println("Connection lost, reconnecting...")
+			go client.Redial(receiver, status)
 		case Disconnected:
-			done <- ClientStatus{Code: Disconnected}
+			done <- ClientStatus{Code: Disconnected, Error: statusVal.Error}
 			return
 		case ErrorState:
 			done <- ClientStatus{Code: ErrorState, Error: statusVal.Error}
@@ -143,9 +323,51 @@ func (client Client) Monitor(status <-chan ClientStatus, done chan<- ClientStatu
 	}
 }
 
-func (client Client) HandleInput(sender <-chan string, status chan<- ClientStatus) {
+// Closes the connection that failed, if it is still the current one. Both the sending and receiving
+// sides may notice the same dropped connection, so only the first report is acted on.
+func (client *Client) dropConnection(conn codec.Codec) bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if !client.connected || conn != client.Codec {
+		return false
+	}
+
+	client.connected = false
+	client.Connection.Close()
+	return true
+}
+
+// Retries Dial with backoff until it succeeds, the server rejects the client, or attempts run out
+func (client *Client) Redial(receiver chan<- response.Response, status chan<- ClientStatus) {
+	for attempt := 0; attempt < client.Reconnect.MaxAttempts; attempt++ {
+		time.Sleep(client.Reconnect.Delay(attempt))
+
+		err := client.Dial()
+		if err == nil {
+			go client.HandleResponses(client.Codec, receiver, status)
+			status <- ClientStatus{Code: Connected}
+			return
+		}
+
+		var rejected *RejectedError
+		if errors.As(err, &rejected) {
+			status <- ClientStatus{Code: ErrorState, Error: err}
+			return
+		}
+
+		fmt.Printf("Reconnect attempt %v of %v failed: %v\n", attempt+1, client.Reconnect.MaxAttempts, err)
+	}
+
+	status <- ClientStatus{Code: ErrorState, Error: &ClientError{Message: "Could not reconnect to server"}}
+}
+
+func (client *Client) HandleInput(sender <-chan string, status chan<- ClientStatus) {
 	for {
-		input := <-sender
+		input, ok := <-sender
+		if !ok {
+			return
+		}
 
 		req := request.Parse(input)
 		req.SenderName = client.Username
@@ -154,51 +376,83 @@ func (client Client) HandleInput(sender <-chan string, status chan<- ClientStatu
 	}
 }
 
-func (client Client) Send(req request.Request, status chan<- ClientStatus) {
-	buf, err := request.Serialize(req)
-	if err != nil {
-		errMsg := "Could not seralize message"
-		status <- ClientStatus{Code: ErrorState, Error: &ClientError{Message: errMsg}}
+// Holds a request until the connection is back; returns false if the queue is full
+func (client *Client) queue(req request.Request) bool {
+	if len(client.pending) >= MaxPendingRequests {
+		return false
+	}
+
+	client.pending = append(client.pending, req)
+	return true
+}
+
+func (client *Client) Send(req request.Request, status chan<- ClientStatus) {
+	client.mutex.Lock()
+	if !client.connected {
+		queued := client.queue(req)
+		client.mutex.Unlock()
+
+		if queued {
+			fmt.Println("Not connected, message will be sent once reconnected")
+		} else {
+			fmt.Println("Not connected, message dropped")
+		}
 		return
 	}
+	conn := client.Codec
+	client.mutex.Unlock()
 
 	status <- ClientStatus{Code: Sending}
 
-	_, err = client.Connection.Write(buf)
+	err := conn.WriteRequest(req)
 	if err != nil {
-		errMsg := "Could not send message"
-		status <- ClientStatus{Code: ErrorState, Error: &ClientError{Message: errMsg}}
+		client.mutex.Lock()
+		client.queue(req)
+		client.mutex.Unlock()
+
+		if client.dropConnection(conn) {
+			errMsg := "Could not send message"
+			status <- ClientStatus{Code: ConnectionLost, Error: &ClientError{Message: errMsg}}
+		}
 		return
 	}
 }
 
-func (client Client) Receive() (response.Response, error) {
-	buffer := make([]byte, 1024)
-
-	_, err := client.Connection.Read(buffer)
-	if err != nil {
-		return response.Response{}, &ClientError{Message: "Could not receive message from server"}
+func (client *Client) Receive(conn codec.Codec) (response.Response, error) {
+	res, err := conn.ReadResponse()
+	if _, malformed := err.(*codec.CodecError); malformed {
+		return response.Response{}, &ClientError{Message: "Could not parse message from server"}
 	}
-
-	res, err := response.Deserialize(buffer)
 	if err != nil {
-		return response.Response{}, &ClientError{Message: "Could not parse message from server"}
+		return response.Response{}, &ClientError{Message: "Could not receive message from server"}
 	}
 
 	return res, nil
 }
 
-func (client Client) HandleResponses(receiver chan<- response.Response, status chan<- ClientStatus) {
+// Reads responses from one connection until it fails; a new goroutine is started for each reconnect
+func (client *Client) HandleResponses(conn codec.Codec, receiver chan<- response.Response, status chan<- ClientStatus) {
+	client.mutex.Lock()
+	unread := client.unread
+	client.unread = nil
+	client.mutex.Unlock()
+
+	for _, res := range unread {
+		receiver <- res
+	}
 
 	for {
-		res, err := client.Receive()
+		res, err := client.Receive(conn)
 		if err != nil {
-			status <- ClientStatus{Code: ErrorState, Error: err}
+			if client.dropConnection(conn) {
+				status <- ClientStatus{Code: ConnectionLost, Error: err}
+			}
 			return
 		}
 
+		// The server explains why it is closing the connection, e.g. because it is shutting down
 		if res.ResType == response.ResponseType_TerminateConnection {
-			status <- ClientStatus{Code: Disconnected}
+			status <- ClientStatus{Code: Disconnected, Error: &DisconnectedError{Err: ErrorFromResponse(res)}}
 			return
 		}
 
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/server"
+)
+
+const testTimeout = 10 * time.Second
+
+// Starts a server on a free loopback port and stops it when the test ends
+func startTestServer(t *testing.T, srv *server.Server) net.TCPAddr {
+	t.Helper()
+
+	if srv.Log == nil {
+		srv.Log = log.New(io.Discard, "", 0)
+	}
+	go srv.Listen(net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+
+	deadline := time.Now().Add(testTimeout)
+	for srv.CurrentStatus().Code != server.Listening {
+		if time.Now().After(deadline) {
+			t.Fatal("Server did not start listening")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Cleanup(func() { srv.Shutdown("") })
+
+	return *srv.Listener.Addr().(*net.TCPAddr)
+}
+
+// Input typed while disconnected is held, up to MaxPendingRequests, and sent in order once connected
+func TestPendingRequests(t *testing.T) {
+	history := server.NewMemoryHistory(MaxPendingRequests * 2)
+	srv := &server.Server{History: history}
+	addr := startTestServer(t, srv)
+
+	client := &Client{Username: "alice", ServerAddr: addr}
+	for i := 0; i < MaxPendingRequests+5; i++ {
+		client.Send(request.Request{ReqType: request.RequestType_Message, SenderName: "alice", Content: fmt.Sprintf("message %v", i)}, nil)
+	}
+	if len(client.pending) != MaxPendingRequests {
+		t.Fatalf("Holding %v requests, expected %v", len(client.pending), MaxPendingRequests)
+	}
+
+	err := client.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Connection.Close()
+	if len(client.pending) != 0 || !client.connected {
+		t.Errorf("Dial left %v requests pending, connected = %v", len(client.pending), client.connected)
+	}
+
+	var recent []server.HistoryEntry
+	deadline := time.Now().Add(testTimeout)
+	for len(recent) < MaxPendingRequests && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		recent, _ = history.Recent(server.DefaultRoom, MaxPendingRequests*2)
+	}
+	if len(recent) != MaxPendingRequests {
+		t.Fatalf("Server received %v messages, expected %v", len(recent), MaxPendingRequests)
+	}
+	for i, entry := range recent {
+		if entry.Response.Content != fmt.Sprintf("message %v", i) {
+			t.Errorf("Message %v was %q", i, entry.Response.Content)
+			break
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+// An error reported by the server, either in reply to a request or as the reason for closing the connection
+// Compare against the Err values below with errors.Is, or use errors.As to read the server's message
+type ServerError struct {
+	Code    response.ErrorCode
+	Message string
+}
+
+func (err *ServerError) Error() string {
+	if err.Message == "" {
+		return err.Code.String()
+	}
+	return err.Message
+}
+
+// Server errors match by code alone, since the message is meant for the user and may vary
+func (err *ServerError) Is(target error) bool {
+	other, ok := target.(*ServerError)
+	return ok && other.Code == err.Code
+}
+
+var (
+	ErrMalformedRequest     = &ServerError{Code: response.ErrorCode_MalformedRequest}
+	ErrUnknownCommand       = &ServerError{Code: response.ErrorCode_UnknownCommand}
+	ErrInvalidArgument      = &ServerError{Code: response.ErrorCode_InvalidArgument}
+	ErrUsernameTaken        = &ServerError{Code: response.ErrorCode_UsernameTaken}
+	ErrInvalidUsername      = &ServerError{Code: response.ErrorCode_InvalidUsername}
+	ErrAuthenticationFailed = &ServerError{Code: response.ErrorCode_AuthenticationFailed}
+	ErrSessionExpired       = &ServerError{Code: response.ErrorCode_SessionExpired}
+	ErrNoSuchUser           = &ServerError{Code: response.ErrorCode_NoSuchUser}
+	ErrUnauthorized         = &ServerError{Code: response.ErrorCode_Unauthorized}
+	ErrRateLimited          = &ServerError{Code: response.ErrorCode_RateLimited}
+	ErrMuted                = &ServerError{Code: response.ErrorCode_Muted}
+	ErrMessageTooLong       = &ServerError{Code: response.ErrorCode_MessageTooLong}
+	ErrBanned               = &ServerError{Code: response.ErrorCode_Banned}
+	ErrKicked               = &ServerError{Code: response.ErrorCode_Kicked}
+	ErrServerFull           = &ServerError{Code: response.ErrorCode_ServerFull}
+	ErrTooManyConnections   = &ServerError{Code: response.ErrorCode_TooManyConnections}
+	ErrShuttingDown         = &ServerError{Code: response.ErrorCode_ShuttingDown}
+	ErrUnavailable          = &ServerError{Code: response.ErrorCode_Unavailable}
+	ErrInternal             = &ServerError{Code: response.ErrorCode_Internal}
+	ErrIncompatibleProtocol = &ServerError{Code: response.ErrorCode_IncompatibleProtocol}
+	ErrNoSuchMessage        = &ServerError{Code: response.ErrorCode_NoSuchMessage}
+)
+
+// Converts an error or termination response to a *ServerError; returns nil for any other response
+func ErrorFromResponse(res response.Response) error {
+	if res.ResType != response.ResponseType_Error && res.ResType != response.ResponseType_TerminateConnection {
+		return nil
+	}
+	return &ServerError{Code: res.Code, Message: res.Content}
+}
+
+// The server refused to register the client, so reconnecting would not help
+type RejectedError struct {
+	Err error
+}
+
+func (err *RejectedError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *RejectedError) Unwrap() error {
+	return err.Err
+}
+
+// The server closed an established connection
+type DisconnectedError struct {
+	Err error
+}
+
+func (err *DisconnectedError) Error() string {
+	return "Disconnected by server: " + err.Err.Error()
+}
+
+func (err *DisconnectedError) Unwrap() error {
+	return err.Err
+}
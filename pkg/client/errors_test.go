@@ -0,0 +1,132 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/edobrowo/gochatroom/pkg/account"
+	"github.com/edobrowo/gochatroom/pkg/codec"
+	"github.com/edobrowo/gochatroom/pkg/response"
+	"github.com/edobrowo/gochatroom/pkg/server"
+)
+
+type silentIO struct{}
+
+func (silentIO) GetInput(chan<- string) {}
+
+func (silentIO) DisplayOutput(<-chan response.Response) {}
+
+// Refusals during registration come back from Connect as a RejectedError wrapping the server's error
+func TestConnectRejected(t *testing.T) {
+	accounts, err := account.OpenStore(filepath.Join(t.TempDir(), "accounts.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = accounts.Register("carol", "hunter22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startTestServer(t, &server.Server{Accounts: accounts})
+
+	first := &Client{Username: "alice", ServerAddr: addr}
+	err = first.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Connection.Close()
+
+	tests := []struct {
+		username string
+		password string
+		expected error
+	}{
+		{"alice", "", ErrUsernameTaken},
+		{"bob smith", "", ErrInvalidUsername},
+		{"carol", "wrong password", ErrAuthenticationFailed},
+	}
+
+	for _, test := range tests {
+		client := &Client{Username: test.username, Password: test.password, IO: silentIO{}}
+		err := client.Connect(addr)
+
+		if !errors.Is(err, test.expected) {
+			t.Errorf("Connecting as %q returned %v, expected %v", test.username, err, test.expected)
+		}
+		if errors.Is(err, ErrBanned) {
+			t.Errorf("Connecting as %q matched an unrelated error code", test.username)
+		}
+
+		var rejected *RejectedError
+		if !errors.As(err, &rejected) {
+			t.Errorf("Connecting as %q returned %T, expected a RejectedError", test.username, err)
+		}
+
+		// The server's explanation is kept for the user
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) || serverErr.Message == "" {
+			t.Errorf("Connecting as %q returned %#v without the server's message", test.username, err)
+		}
+	}
+}
+
+// A server that refuses the hello is reported the same way as one that refuses the registration
+func TestHandshakeRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		peer := codec.NewBinary(conn, conn)
+		peer.ReadHello()
+		peer.WriteResponse(response.Response{ResType: response.ResponseType_TerminateConnection, Code: response.ErrorCode_IncompatibleProtocol, Content: "Upgrade your client"})
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := &Client{Username: "alice"}
+	err = client.Handshake(codec.NewBinary(conn, conn))
+
+	var rejected *RejectedError
+	if !errors.Is(err, ErrIncompatibleProtocol) || !errors.As(err, &rejected) {
+		t.Fatalf("Handshake returned %v, expected a rejection with ErrIncompatibleProtocol", err)
+	}
+	if err.Error() != "Upgrade your client" {
+		t.Errorf("Refusal reads %q", err.Error())
+	}
+}
+
+// Failing to reach the server at all is not a rejection, so it can be retried
+func TestConnectUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := *listener.Addr().(*net.TCPAddr)
+	listener.Close()
+
+	client := &Client{Username: "alice", IO: silentIO{}}
+	err = client.Connect(addr)
+
+	var rejected *RejectedError
+	if err == nil || errors.As(err, &rejected) {
+		t.Errorf("Connecting to a closed port returned %v", err)
+	}
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		t.Errorf("Connecting to a closed port returned a server error %v", serverErr)
+	}
+}
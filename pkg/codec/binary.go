@@ -0,0 +1,60 @@
+package codec
+
+import (
+	"io"
+
+	"github.com/edobrowo/gochatroom/pkg/frame"
+	"github.com/edobrowo/gochatroom/pkg/protocol"
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+// The original format: length-prefixed frames holding the little-endian layouts from pkg/request and pkg/response
+type Binary struct {
+	Reader *frame.Reader
+	Writer *frame.Writer
+}
+
+func NewBinary(r io.Reader, w io.Writer) *Binary {
+	return &Binary{Reader: frame.NewReader(r), Writer: frame.NewWriter(w)}
+}
+
+func (codec *Binary) Name() string {
+	return Name_Binary
+}
+
+func (codec *Binary) ReadHello() (protocol.Hello, error) {
+	buf, err := codec.Reader.ReadFrame()
+	if err != nil {
+		return protocol.Hello{}, err
+	}
+
+	if !protocol.IsHello(buf) {
+		res, err := response.Deserialize(buf)
+		if err == nil && res.ResType == response.ResponseType_TerminateConnection {
+			return protocol.Hello{}, &RefusedError{Response: res}
+		}
+	}
+
+	return protocol.Deserialize(buf)
+}
+
+func (codec *Binary) WriteHello(hello protocol.Hello) error {
+	return protocol.Write(codec.Writer, hello)
+}
+
+func (codec *Binary) ReadRequest() (request.Request, error) {
+	return request.Read(codec.Reader)
+}
+
+func (codec *Binary) WriteRequest(req request.Request) error {
+	return request.Write(codec.Writer, req)
+}
+
+func (codec *Binary) ReadResponse() (response.Response, error) {
+	return response.Read(codec.Reader)
+}
+
+func (codec *Binary) WriteResponse(res response.Response) error {
+	return response.Write(codec.Writer, res)
+}
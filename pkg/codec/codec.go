@@ -0,0 +1,72 @@
+package codec
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/edobrowo/gochatroom/pkg/protocol"
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+const (
+	Name_Binary = "binary"
+	Name_JSON   = "json"
+)
+
+type CodecError struct {
+	Message string
+}
+
+func (err *CodecError) Error() string {
+	return err.Message
+}
+
+// Returned by ReadHello when the peer sent a termination notice instead of a hello, e.g. because it refused our version
+type RefusedError struct {
+	Response response.Response
+}
+
+func (err *RefusedError) Error() string {
+	return err.Response.Content
+}
+
+// Reads and writes the messages of one connection in a particular wire format
+// Reads must come from a single goroutine; writes are safe from several
+type Codec interface {
+	Name() string
+
+	ReadHello() (protocol.Hello, error)
+	WriteHello(hello protocol.Hello) error
+
+	ReadRequest() (request.Request, error)
+	WriteRequest(req request.Request) error
+
+	ReadResponse() (response.Response, error)
+	WriteResponse(res response.Response) error
+}
+
+func New(name string, r io.Reader, w io.Writer) (Codec, error) {
+	switch name {
+	case Name_Binary:
+		return NewBinary(r, w), nil
+	case Name_JSON:
+		return NewJSON(r, w), nil
+	}
+	return nil, &CodecError{Message: "Unknown codec " + name + "; expected binary or json"}
+}
+
+// Picks the codec a peer is speaking from the first byte it sent, without consuming it
+// A JSON hello opens with '{', while a binary hello opens with the low byte of its length
+func Detect(r io.Reader, w io.Writer) (Codec, error) {
+	reader := bufio.NewReader(r)
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] == '{' {
+		return NewJSON(reader, w), nil
+	}
+	return NewBinary(reader, w), nil
+}
@@ -0,0 +1,159 @@
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/protocol"
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+func TestDetect(t *testing.T) {
+	for _, name := range []string{Name_Binary, Name_JSON} {
+		var stream bytes.Buffer
+		writer, err := New(name, nil, &stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hello := protocol.NewHello(protocol.Supported)
+		writer.WriteHello(hello)
+
+		// The detected codec must still see the byte that was peeked at
+		detected, err := Detect(&stream, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if detected.Name() != name {
+			t.Errorf("Detected %v, expected %v", detected.Name(), name)
+		}
+
+		read, err := detected.ReadHello()
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if read != hello {
+			t.Errorf("%v: read hello %+v, expected %+v", name, read, hello)
+		}
+	}
+
+	_, err := Detect(&bytes.Buffer{}, nil)
+	if err == nil {
+		t.Error("Detecting an empty stream succeeded")
+	}
+}
+
+func TestUnknownCodec(t *testing.T) {
+	_, err := New("xml", nil, nil)
+	if _, ok := err.(*CodecError); !ok {
+		t.Errorf("Expected a CodecError, got %v", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	requests := []request.Request{
+		{ReqType: request.RequestType_Message, SenderName: "alice", Content: "hello <world> & all"},
+		{ReqType: request.RequestType_Message, SenderName: "alice", Content: "a reply", ParentID: 42},
+		{ReqType: request.RequestType_Command, CmdType: request.Command_Edit, SenderName: "alice", Content: "fixed", MessageID: 42},
+		{ReqType: request.RequestType_Command, CmdType: request.Command_Whisper, SenderName: "alice", ReceiverName: "bob", Content: "hi"},
+		{ReqType: request.RequestType_Status, StType: request.Status_Register, SenderName: "alice", Content: "hunter22"},
+	}
+	responses := []response.Response{
+		{ResType: response.ResponseType_Message, SenderName: "alice", Content: "hello @bob", ID: 7, Time: time.Date(2024, 5, 1, 12, 30, 0, 500, time.UTC), ParentID: 3, Mentions: []string{"bob"}},
+		{ResType: response.ResponseType_Whisper, SenderName: "alice", ReceiverName: "bob", Content: "hi"},
+		{ResType: response.ResponseType_Error, Code: response.ErrorCode_NoSuchMessage, Content: "No such message"},
+		{ResType: response.ResponseType_ServerRoom, Content: "bob has connected"},
+	}
+
+	for _, name := range []string{Name_Binary, Name_JSON} {
+		var stream bytes.Buffer
+		codec, err := New(name, &stream, &stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, req := range requests {
+			err = codec.WriteRequest(req)
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			read, err := codec.ReadRequest()
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			if !reflect.DeepEqual(read, req) {
+				t.Errorf("%v: read request %+v, expected %+v", name, read, req)
+			}
+		}
+
+		for _, res := range responses {
+			err = codec.WriteResponse(res)
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			read, err := codec.ReadResponse()
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			if !reflect.DeepEqual(read, res) {
+				t.Errorf("%v: read response %+v, expected %+v", name, read, res)
+			}
+		}
+	}
+}
+
+// A server that refuses the hello answers with a termination notice, which ReadHello reports as a RefusedError
+func TestRefusedHello(t *testing.T) {
+	for _, name := range []string{Name_Binary, Name_JSON} {
+		var stream bytes.Buffer
+		codec, _ := New(name, &stream, &stream)
+		codec.WriteResponse(response.Response{ResType: response.ResponseType_TerminateConnection, Code: response.ErrorCode_IncompatibleProtocol, Content: "Upgrade"})
+
+		_, err := codec.ReadHello()
+		refused, ok := err.(*RefusedError)
+		if !ok {
+			t.Fatalf("%v: expected a RefusedError, got %v", name, err)
+		}
+		if refused.Response.Code != response.ErrorCode_IncompatibleProtocol || refused.Response.Content != "Upgrade" {
+			t.Errorf("%v: refusal read as %+v", name, refused.Response)
+		}
+	}
+}
+
+func TestJSONLine(t *testing.T) {
+	codec := NewJSON(bytes.NewBufferString("\n{\"sender\":\"alice\",\"line\":\"/join #dev\"}\n"), nil)
+
+	req, err := codec.ReadRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := request.Parse("/join #dev")
+	expected.SenderName = "alice"
+	if !reflect.DeepEqual(req, expected) {
+		t.Errorf("Read %+v, expected %+v", req, expected)
+	}
+}
+
+// Every field of the layout is required, so a frame cut short is malformed rather than read with zero values
+func TestBinaryTruncated(t *testing.T) {
+	req, err := request.Serialize(request.Request{ReqType: request.RequestType_Message, Content: "a reply", ParentID: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = request.Deserialize(req[:len(req)-1])
+	if err == nil {
+		t.Error("Read a truncated request")
+	}
+
+	res, err := response.Serialize(response.Response{ResType: response.ResponseType_Message, Content: "hi @bob", Mentions: []string{"bob"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = response.Deserialize(res[:len(res)-1])
+	if err == nil {
+		t.Error("Read a truncated response")
+	}
+}
@@ -0,0 +1,241 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/frame"
+	"github.com/edobrowo/gochatroom/pkg/protocol"
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+// Opens a JSON hello in place of the binary magic number
+const JSONMagic = "GCHT"
+
+// One JSON object per line; blank lines are ignored, and lines are held to the same limit as binary frames
+type JSON struct {
+	reader *bufio.Reader
+	writer io.Writer
+	mutex  sync.Mutex
+}
+
+func NewJSON(r io.Reader, w io.Writer) *JSON {
+	return &JSON{reader: bufio.NewReader(r), writer: w}
+}
+
+type jsonHello struct {
+	Magic      string            `json:"magic"`
+	Version    uint16            `json:"version"`
+	MinVersion uint16            `json:"min_version"`
+	Features   protocol.Features `json:"features"`
+}
+
+// Field names are spelled out so scripts need not know the Go names; the server-side fields of Request are left out
+type jsonRequest struct {
+	Type      request.RequestType `json:"type"`
+	Command   request.CommandType `json:"command,omitempty"`
+	Status    request.StatusType  `json:"status,omitempty"`
+	Sender    string              `json:"sender,omitempty"`
+	Receiver  string              `json:"receiver,omitempty"`
+	Content   string              `json:"content,omitempty"`
+	MessageID uint64              `json:"message_id,omitempty"`
+	ParentID  uint64              `json:"parent_id,omitempty"`
+
+	// Input as a user would type it, such as "/join #dev"; parsed like the client's input in place of the fields above
+	Line string `json:"line,omitempty"`
+}
+
+type jsonResponse struct {
+	Type     response.ResponseType `json:"type"`
+	Sender   string                `json:"sender,omitempty"`
+	Receiver string                `json:"receiver,omitempty"`
+	Content  string                `json:"content,omitempty"`
+	Code     response.ErrorCode    `json:"code,omitempty"`
+
+	// The time is written in RFC 3339 format
+	ID       uint64   `json:"id,omitempty"`
+	Time     string   `json:"time,omitempty"`
+	ParentID uint64   `json:"parent_id,omitempty"`
+	Mentions []string `json:"mentions,omitempty"`
+}
+
+func (codec *JSON) Name() string {
+	return Name_JSON
+}
+
+func (codec *JSON) readLine() ([]byte, error) {
+	for {
+		var line []byte
+		for {
+			chunk, more, err := codec.reader.ReadLine()
+			if err != nil {
+				return nil, err
+			}
+
+			line = append(line, chunk...)
+			if len(line) > frame.MaxFrameSize {
+				return nil, &CodecError{Message: fmt.Sprintf("Line exceeds maximum of %v bytes", frame.MaxFrameSize)}
+			}
+			if !more {
+				break
+			}
+		}
+
+		if len(line) > 0 {
+			return line, nil
+		}
+	}
+}
+
+func (codec *JSON) writeLine(v any) error {
+	// Chat messages are full of <, > and &, which are easier to read unescaped; Encode ends the line itself
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(v)
+	if err != nil {
+		return err
+	}
+	if buffer.Len() > frame.MaxFrameSize {
+		return &CodecError{Message: fmt.Sprintf("Line exceeds maximum of %v bytes", frame.MaxFrameSize)}
+	}
+
+	// Written with a single call so concurrent lines never interleave
+	codec.mutex.Lock()
+	defer codec.mutex.Unlock()
+
+	_, err = codec.writer.Write(buffer.Bytes())
+	return err
+}
+
+func (codec *JSON) ReadHello() (protocol.Hello, error) {
+	line, err := codec.readLine()
+	if err != nil {
+		return protocol.Hello{}, err
+	}
+
+	var hello jsonHello
+	err = json.Unmarshal(line, &hello)
+	if err != nil || hello.Magic != JSONMagic {
+		var res jsonResponse
+		if json.Unmarshal(line, &res) == nil && res.Type == response.ResponseType_TerminateConnection {
+			refusal, _ := res.toResponse()
+			return protocol.Hello{}, &RefusedError{Response: refusal}
+		}
+		return protocol.Hello{}, &protocol.ProtocolError{Message: "Peer did not send a protocol hello; it may need to be upgraded"}
+	}
+	if hello.MinVersion > hello.Version {
+		return protocol.Hello{}, &protocol.ProtocolError{Message: "Protocol hello has an invalid version range"}
+	}
+
+	return protocol.Hello{Version: hello.Version, MinVersion: hello.MinVersion, Features: hello.Features}, nil
+}
+
+func (codec *JSON) WriteHello(hello protocol.Hello) error {
+	return codec.writeLine(jsonHello{Magic: JSONMagic, Version: hello.Version, MinVersion: hello.MinVersion, Features: hello.Features})
+}
+
+func (codec *JSON) ReadRequest() (request.Request, error) {
+	line, err := codec.readLine()
+	if err != nil {
+		return request.Request{}, err
+	}
+
+	var req jsonRequest
+	err = json.Unmarshal(line, &req)
+	if err != nil {
+		return request.Request{}, &CodecError{Message: "Malformed JSON request: " + err.Error()}
+	}
+
+	if req.Line != "" {
+		parsed := request.Parse(req.Line)
+		parsed.SenderName = req.Sender
+		return parsed, nil
+	}
+
+	return request.Request{
+		ReqType:      req.Type,
+		CmdType:      req.Command,
+		StType:       req.Status,
+		SenderName:   req.Sender,
+		ReceiverName: req.Receiver,
+		Content:      req.Content,
+		MessageID:    req.MessageID,
+		ParentID:     req.ParentID,
+	}, nil
+}
+
+func (codec *JSON) WriteRequest(req request.Request) error {
+	return codec.writeLine(jsonRequest{
+		Type:      req.ReqType,
+		Command:   req.CmdType,
+		Status:    req.StType,
+		Sender:    req.SenderName,
+		Receiver:  req.ReceiverName,
+		Content:   req.Content,
+		MessageID: req.MessageID,
+		ParentID:  req.ParentID,
+	})
+}
+
+func (res jsonResponse) toResponse() (response.Response, error) {
+	converted := response.Response{
+		ResType:      res.Type,
+		SenderName:   res.Sender,
+		ReceiverName: res.Receiver,
+		Content:      res.Content,
+		Code:         res.Code,
+		ID:           res.ID,
+		ParentID:     res.ParentID,
+		Mentions:     res.Mentions,
+	}
+
+	if res.Time != "" {
+		sent, err := time.Parse(time.RFC3339Nano, res.Time)
+		if err != nil {
+			return response.Response{}, &CodecError{Message: "Malformed JSON response: invalid time " + res.Time}
+		}
+		converted.Time = sent.UTC()
+	}
+
+	return converted, nil
+}
+
+func (codec *JSON) ReadResponse() (response.Response, error) {
+	line, err := codec.readLine()
+	if err != nil {
+		return response.Response{}, err
+	}
+
+	var res jsonResponse
+	err = json.Unmarshal(line, &res)
+	if err != nil {
+		return response.Response{}, &CodecError{Message: "Malformed JSON response: " + err.Error()}
+	}
+
+	return res.toResponse()
+}
+
+func (codec *JSON) WriteResponse(res response.Response) error {
+	line := jsonResponse{
+		Type:     res.ResType,
+		Sender:   res.SenderName,
+		Receiver: res.ReceiverName,
+		Content:  res.Content,
+		Code:     res.Code,
+		ID:       res.ID,
+		ParentID: res.ParentID,
+		Mentions: res.Mentions,
+	}
+	if !res.Time.IsZero() {
+		line.Time = res.Time.UTC().Format(time.RFC3339Nano)
+	}
+
+	return codec.writeLine(line)
+}
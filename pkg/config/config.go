@@ -0,0 +1,384 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/client"
+	"github.com/edobrowo/gochatroom/pkg/codec"
+	"github.com/edobrowo/gochatroom/pkg/mailbox"
+	"github.com/edobrowo/gochatroom/pkg/server"
+	"github.com/edobrowo/gochatroom/pkg/validation"
+)
+
+const (
+	DefaultAddress = "127.0.0.1:9988"
+	DefaultDataDir = "data"
+)
+
+type ConfigError struct {
+	Message string
+}
+
+func (err *ConfigError) Error() string {
+	return err.Message
+}
+
+type ServerConfig struct {
+	// Address to listen on, as host:port
+	Address string `json:"address"`
+
+	// Log file path; empty or "-" logs to stdout
+	LogFile string `json:"log_file"`
+
+	// Directory holding the account store, history log, ban list, mailbox and message ID file when their paths are relative
+	DataDir string `json:"data_dir"`
+
+	// Account store path; empty disables accounts
+	AccountsFile string `json:"accounts_file"`
+
+	// History log path; empty keeps history in memory only
+	HistoryFile string `json:"history_file"`
+
+	// Path of the file saving how far message IDs have got, so they are not reused after a restart; empty keeps them in memory only
+	MessageIDsFile string `json:"message_ids_file"`
+
+	// Messages kept per room, and replayed on registration
+	HistorySize   int `json:"history_size"`
+	HistoryReplay int `json:"history_replay"`
+
+	// Certificate and key for TLS; both empty disables TLS
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+
+	// Maximum simultaneous connections; 0 is unlimited
+	MaxClients int `json:"max_clients"`
+
+	// Maximum length of a chat message in bytes; 0 is unlimited
+	MaxMessageLength int `json:"max_message_length"`
+
+	// Message of the day, sent to each user when they register
+	MOTD string `json:"motd"`
+
+	// Seconds a dropped user's username is held for them to reconnect
+	SessionGrace int `json:"session_grace"`
+
+	// Username length limits in characters, punctuation allowed besides letters and digits, whether names are restricted to ASCII, and names nobody may take
+	UsernameMinLength   int      `json:"username_min_length"`
+	UsernameMaxLength   int      `json:"username_max_length"`
+	UsernamePunctuation string   `json:"username_punctuation"`
+	UsernameASCIIOnly   bool     `json:"username_ascii_only"`
+	ReservedNames       []string `json:"reserved_names"`
+
+	// Usernames granted the operator role when they log in with a password
+	Operators []string `json:"operators"`
+
+	// Ban list path; empty keeps bans in memory only
+	BansFile string `json:"bans_file"`
+
+	// Path of the mailbox holding whispers to offline registered users, and how many each may hold; an empty path keeps them in memory only
+	MailboxFile     string `json:"mailbox_file"`
+	MailboxCapacity int    `json:"mailbox_capacity"`
+
+	// Maximum simultaneous connections from one address; 0 is unlimited
+	MaxConnectionsPerIP int `json:"max_connections_per_ip"`
+
+	// Requests per second allowed from each connection, and the burst allowed above it; a zero rate disables rate limiting
+	RateLimit float64 `json:"rate_limit"`
+	RateBurst int     `json:"rate_burst"`
+
+	// Rate limit violations before a temporary mute and before disconnecting, 0 to skip the step
+	// Mutes last flood_mute seconds, and violations are forgotten after flood_forgive seconds without one
+	FloodMuteAfter       int `json:"flood_mute_after"`
+	FloodMute            int `json:"flood_mute"`
+	FloodDisconnectAfter int `json:"flood_disconnect_after"`
+	FloodForgive         int `json:"flood_forgive"`
+
+	// Responses buffered per client, what to do when a client falls that far behind, and seconds a single write may take
+	// The overflow policy is one of drop-oldest, drop-newest or disconnect
+	QueueSize      int    `json:"queue_size"`
+	OverflowPolicy string `json:"overflow_policy"`
+	WriteTimeout   int    `json:"write_timeout"`
+
+	// Address serving the browser page and its WebSocket, empty to disable it, and other sites whose pages may connect
+	WebSocketAddress string   `json:"websocket_address"`
+	WebSocketOrigins []string `json:"websocket_origins"`
+
+	// Address of the admin HTTP API, empty to disable it, and the bearer token its requests must carry
+	// Like the client's password, the token is never taken from a flag; a config file holding it should only be readable by its owner
+	AdminAddress string `json:"admin_address"`
+	AdminToken   string `json:"admin_token"`
+
+	// File whose first line is the admin token, which takes precedence over the token in the config file
+	AdminTokenFile string `json:"admin_token_file"`
+
+	// Address serving Prometheus metrics at /metrics, empty to disable it; it is not authenticated
+	MetricsAddress string `json:"metrics_address"`
+}
+
+type ClientConfig struct {
+	// Server address to dial, as host:port
+	Address string `json:"address"`
+
+	// Username and password used to register; the username is prompted for if empty
+	// A config file holding a password should only be readable by its owner (mode 0600); the password is never taken from a flag, which would show it to other users
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// File whose first line is the password, which takes precedence over the password in the config file
+	PasswordFile string `json:"password_file"`
+
+	// Enables TLS; the server is verified against a CA bundle or a pinned fingerprint (not both), or the system roots
+	TLS            bool   `json:"tls"`
+	TLSCA          string `json:"tls_ca"`
+	TLSFingerprint string `json:"tls_fingerprint"`
+	TLSServerName  string `json:"tls_server_name"`
+
+	// Reconnection attempts after a dropped connection; 0 disables reconnecting
+	ReconnectAttempts int `json:"reconnect_attempts"`
+
+	// Wire format, binary or json
+	Codec string `json:"codec"`
+
+	// Layout of message timestamps, as in Go's time package; empty hides them
+	TimeFormat string `json:"time_format"`
+}
+
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Address:          DefaultAddress,
+		DataDir:          DefaultDataDir,
+		AccountsFile:     "accounts.txt",
+		BansFile:         "bans.txt",
+		MailboxFile:      "mailbox.dat",
+		MessageIDsFile:   "message_ids.txt",
+		MailboxCapacity:  mailbox.DefaultCapacity,
+		HistorySize:      server.DefaultHistoryCapacity,
+		HistoryReplay:    server.DefaultHistoryReplay,
+		MaxMessageLength: 4096,
+		SessionGrace:     int(server.DefaultSessionGrace / time.Second),
+
+		UsernameMinLength:   validation.DefaultPolicy.MinLength,
+		UsernameMaxLength:   validation.DefaultPolicy.MaxLength,
+		UsernamePunctuation: validation.DefaultPolicy.AllowedPunctuation,
+		ReservedNames:       validation.DefaultReserved,
+
+		MaxConnectionsPerIP:  server.DefaultMaxConnectionsPerIP,
+		RateLimit:            server.DefaultFloodPolicy.Rate,
+		RateBurst:            server.DefaultFloodPolicy.Burst,
+		FloodMuteAfter:       server.DefaultFloodPolicy.MuteAfter,
+		FloodMute:            int(server.DefaultFloodPolicy.MuteDuration / time.Second),
+		FloodDisconnectAfter: server.DefaultFloodPolicy.DisconnectAfter,
+		FloodForgive:         int(server.DefaultFloodPolicy.Forgive / time.Second),
+
+		QueueSize:      server.DefaultQueueSize,
+		OverflowPolicy: server.Overflow_DropOldest.String(),
+		WriteTimeout:   int(server.DefaultWriteTimeout / time.Second),
+	}
+}
+
+func DefaultClientConfig() ClientConfig {
+	return ClientConfig{
+		Address:           DefaultAddress,
+		ReconnectAttempts: client.DefaultBackoff.MaxAttempts,
+		Codec:             codec.Name_Binary,
+		TimeFormat:        client.DefaultTimeFormat,
+	}
+}
+
+func bindServerFlags(fs *flag.FlagSet, cfg *ServerConfig) {
+	fs.StringVar(&cfg.Address, "addr", cfg.Address, "address to listen on")
+	fs.StringVar(&cfg.LogFile, "log", cfg.LogFile, "log file path, or - for stdout")
+	fs.StringVar(&cfg.DataDir, "data-dir", cfg.DataDir, "directory relative data file paths are resolved against, empty for the working directory")
+	fs.StringVar(&cfg.AccountsFile, "accounts", cfg.AccountsFile, "account store path, empty to disable accounts")
+	fs.StringVar(&cfg.HistoryFile, "history", cfg.HistoryFile, "history log path, empty to keep history in memory")
+	fs.StringVar(&cfg.MessageIDsFile, "message-ids", cfg.MessageIDsFile, "path of the file saving how far message IDs have got, empty to keep them in memory")
+	fs.IntVar(&cfg.HistorySize, "history-size", cfg.HistorySize, "messages kept per room")
+	fs.IntVar(&cfg.HistoryReplay, "history-replay", cfg.HistoryReplay, "messages replayed to users when they register")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, "TLS certificate path")
+	fs.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "TLS private key path")
+	fs.IntVar(&cfg.MaxClients, "max-clients", cfg.MaxClients, "maximum simultaneous connections, 0 for unlimited")
+	fs.IntVar(&cfg.MaxMessageLength, "max-message-length", cfg.MaxMessageLength, "maximum chat message length in bytes, 0 for unlimited")
+	fs.StringVar(&cfg.MOTD, "motd", cfg.MOTD, "message of the day")
+	fs.IntVar(&cfg.SessionGrace, "session-grace", cfg.SessionGrace, "seconds a dropped user's username is held for them to reconnect")
+	fs.IntVar(&cfg.UsernameMinLength, "username-min-length", cfg.UsernameMinLength, "minimum username length in characters")
+	fs.IntVar(&cfg.UsernameMaxLength, "username-max-length", cfg.UsernameMaxLength, "maximum username length in characters; 0 for no limit")
+	fs.StringVar(&cfg.UsernamePunctuation, "username-punctuation", cfg.UsernamePunctuation, "punctuation allowed in usernames besides letters and digits; spaces are never allowed")
+	fs.BoolVar(&cfg.UsernameASCIIOnly, "username-ascii-only", cfg.UsernameASCIIOnly, "restrict usernames to ASCII letters and digits")
+	fs.Func("reserved-names", "comma-separated usernames nobody may register", func(value string) error {
+		cfg.ReservedNames = SplitList(value)
+		return nil
+	})
+	fs.Func("operators", "comma-separated usernames granted the operator role", func(value string) error {
+		cfg.Operators = SplitList(value)
+		return nil
+	})
+	fs.StringVar(&cfg.BansFile, "bans", cfg.BansFile, "ban list path, empty to keep bans in memory")
+	fs.StringVar(&cfg.MailboxFile, "mailbox", cfg.MailboxFile, "path of the mailbox holding whispers to offline users, empty to keep them in memory")
+	fs.IntVar(&cfg.MailboxCapacity, "mailbox-capacity", cfg.MailboxCapacity, "whispers held for each offline user, 0 for unlimited")
+	fs.IntVar(&cfg.MaxConnectionsPerIP, "max-connections-per-ip", cfg.MaxConnectionsPerIP, "maximum simultaneous connections from one address, 0 for unlimited")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "requests per second allowed from each connection, 0 to disable rate limiting")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", cfg.RateBurst, "requests allowed at once above the rate limit")
+	fs.IntVar(&cfg.FloodMuteAfter, "flood-mute-after", cfg.FloodMuteAfter, "rate limit violations before a temporary mute, 0 to never mute")
+	fs.IntVar(&cfg.FloodMute, "flood-mute", cfg.FloodMute, "seconds a flooding user is muted for, 0 for the default")
+	fs.IntVar(&cfg.FloodDisconnectAfter, "flood-disconnect-after", cfg.FloodDisconnectAfter, "rate limit violations before disconnecting, 0 to never disconnect")
+	fs.IntVar(&cfg.FloodForgive, "flood-forgive", cfg.FloodForgive, "seconds without a violation before earlier violations are forgotten")
+	fs.IntVar(&cfg.QueueSize, "queue-size", cfg.QueueSize, "responses buffered for each client")
+	fs.StringVar(&cfg.OverflowPolicy, "overflow-policy", cfg.OverflowPolicy, "what to do when a client's queue is full: drop-oldest, drop-newest or disconnect")
+	fs.IntVar(&cfg.WriteTimeout, "write-timeout", cfg.WriteTimeout, "seconds a single write to a client may take")
+	fs.StringVar(&cfg.WebSocketAddress, "ws-addr", cfg.WebSocketAddress, "address serving the browser page and WebSocket gateway, empty to disable it")
+	fs.Func("ws-origins", "comma-separated origins of other sites whose pages may connect to the gateway, or * for any", func(value string) error {
+		cfg.WebSocketOrigins = SplitList(value)
+		return nil
+	})
+	fs.StringVar(&cfg.AdminAddress, "admin-addr", cfg.AdminAddress, "address of the admin HTTP API, empty to disable it")
+	fs.StringVar(&cfg.AdminTokenFile, "admin-token-file", cfg.AdminTokenFile, "file holding the bearer token required by the admin API; "+AdminTokenEnv+" takes precedence")
+	fs.StringVar(&cfg.MetricsAddress, "metrics-addr", cfg.MetricsAddress, "address serving Prometheus metrics at /metrics, empty to disable it")
+}
+
+func bindClientFlags(fs *flag.FlagSet, cfg *ClientConfig) {
+	fs.StringVar(&cfg.Address, "addr", cfg.Address, "server address")
+	fs.StringVar(&cfg.Username, "username", cfg.Username, "username; prompted for if empty")
+	fs.StringVar(&cfg.PasswordFile, "password-file", cfg.PasswordFile, "file holding the account password; "+PasswordEnv+" takes precedence, and with neither the password is prompted for")
+	fs.BoolVar(&cfg.TLS, "tls", cfg.TLS, "connect with TLS")
+	fs.StringVar(&cfg.TLSCA, "tls-ca", cfg.TLSCA, "CA bundle used to verify the server")
+	fs.StringVar(&cfg.TLSFingerprint, "tls-fingerprint", cfg.TLSFingerprint, "pinned SHA-256 fingerprint of the server certificate")
+	fs.StringVar(&cfg.TLSServerName, "tls-server-name", cfg.TLSServerName, "name to verify the server certificate against")
+	fs.IntVar(&cfg.ReconnectAttempts, "reconnect-attempts", cfg.ReconnectAttempts, "reconnection attempts after a dropped connection, 0 to disable")
+	fs.StringVar(&cfg.Codec, "codec", cfg.Codec, "wire format: binary or json")
+	fs.StringVar(&cfg.TimeFormat, "time-format", cfg.TimeFormat, "layout of message timestamps in Go's reference time, such as 15:04:05; empty hides them")
+}
+
+// Splits a comma-separated flag value, dropping empty entries
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Environment variable holding the admin API's bearer token
+const AdminTokenEnv = "GOCHATROOM_ADMIN_TOKEN"
+
+// Builds the server configuration from defaults, then the file named by -config, then the remaining flags
+// The admin token is then taken from AdminTokenEnv if it is set, or else from the admin token file
+func LoadServer(name string, args []string) (ServerConfig, error) {
+	cfg := DefaultServerConfig()
+	err := load(name, args, &cfg, bindServerFlags)
+	if err != nil {
+		return cfg, err
+	}
+
+	err = loadSecret(AdminTokenEnv, cfg.AdminTokenFile, &cfg.AdminToken)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.AccountsFile = cfg.DataPath(cfg.AccountsFile)
+	cfg.HistoryFile = cfg.DataPath(cfg.HistoryFile)
+	cfg.BansFile = cfg.DataPath(cfg.BansFile)
+	cfg.MailboxFile = cfg.DataPath(cfg.MailboxFile)
+	cfg.MessageIDsFile = cfg.DataPath(cfg.MessageIDsFile)
+	return cfg, nil
+}
+
+// Resolves a relative data file path against the data directory; empty paths stay empty, since they disable the file
+func (cfg *ServerConfig) DataPath(path string) string {
+	if path == "" || cfg.DataDir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(cfg.DataDir, path)
+}
+
+// Environment variable holding the client's account password
+const PasswordEnv = "GOCHATROOM_PASSWORD"
+
+// Builds the client configuration from defaults, then the file named by -config, then the remaining flags
+// The password is then taken from PasswordEnv if it is set, or else from the password file
+func LoadClient(name string, args []string) (ClientConfig, error) {
+	cfg := DefaultClientConfig()
+	err := load(name, args, &cfg, bindClientFlags)
+	if err != nil {
+		return cfg, err
+	}
+
+	err = loadSecret(PasswordEnv, cfg.PasswordFile, &cfg.Password)
+	return cfg, err
+}
+
+// Replaces a secret with the environment variable if it is set, or else with the first line of the file if one is named
+func loadSecret(env string, path string, secret *string) error {
+	value, ok := os.LookupEnv(env)
+	if ok {
+		*secret = value
+		return nil
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return &ConfigError{Message: fmt.Sprintf("Could not read %v: %v", path, err)}
+		}
+		value, _, _ = strings.Cut(string(data), "\n")
+		*secret = strings.TrimSuffix(value, "\r")
+	}
+
+	return nil
+}
+
+// Flags are parsed twice: once to find the config file, and again over the file's values so flags take precedence
+func load[T any](name string, args []string, cfg *T, bind func(*flag.FlagSet, *T)) error {
+	var path string
+
+	scratch := *cfg
+	first := flag.NewFlagSet(name, flag.ContinueOnError)
+	first.StringVar(&path, "config", "", "JSON configuration file")
+	bind(first, &scratch)
+	err := first.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if path != "" {
+		err = ReadFile(path, cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	second := flag.NewFlagSet(name, flag.ContinueOnError)
+	second.SetOutput(io.Discard)
+	second.StringVar(&path, "config", path, "JSON configuration file")
+	bind(second, cfg)
+
+	return second.Parse(args)
+}
+
+// Decodes a JSON file over an existing configuration, leaving fields absent from the file unchanged
+func ReadFile(path string, cfg any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return &ConfigError{Message: fmt.Sprintf("Could not read %v: %v", path, err)}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(cfg)
+	if err != nil {
+		return &ConfigError{Message: fmt.Sprintf("Could not parse %v: %v", path, err)}
+	}
+
+	return nil
+}
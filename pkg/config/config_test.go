@@ -0,0 +1,163 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, name string, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Flags override the config file, which overrides the defaults, whichever order -config is given in
+func TestLoadServerPrecedence(t *testing.T) {
+	path := writeFile(t, "server.json", `{"address": "0.0.0.0:1111", "motd": "from file", "max_clients": 10}`)
+	defaults := DefaultServerConfig()
+
+	tests := []struct {
+		args       []string
+		address    string
+		motd       string
+		maxClients int
+	}{
+		{nil, defaults.Address, defaults.MOTD, defaults.MaxClients},
+		{[]string{"-motd", "from flag"}, defaults.Address, "from flag", defaults.MaxClients},
+		{[]string{"-config", path}, "0.0.0.0:1111", "from file", 10},
+		{[]string{"-config", path, "-motd", "from flag"}, "0.0.0.0:1111", "from flag", 10},
+		{[]string{"-motd", "from flag", "-config", path}, "0.0.0.0:1111", "from flag", 10},
+		{[]string{"-max-clients", "0", "-config", path, "-addr", ":2222"}, ":2222", "from file", 0},
+	}
+
+	for _, test := range tests {
+		cfg, err := LoadServer("server", test.args)
+		if err != nil {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+		if cfg.Address != test.address || cfg.MOTD != test.motd || cfg.MaxClients != test.maxClients {
+			t.Errorf("%v: loaded address %q, motd %q, max clients %v; expected %q, %q, %v", test.args, cfg.Address, cfg.MOTD, cfg.MaxClients, test.address, test.motd, test.maxClients)
+		}
+	}
+}
+
+func TestLoadServerErrors(t *testing.T) {
+	unknown := writeFile(t, "server.json", `{"adress": "0.0.0.0:1111"}`)
+	malformed := writeFile(t, "broken.json", `{"address": `)
+
+	for _, args := range [][]string{
+		{"-config", unknown},
+		{"-config", malformed},
+		{"-config", filepath.Join(t.TempDir(), "missing.json")},
+	} {
+		_, err := LoadServer("server", args)
+		if _, ok := err.(*ConfigError); !ok {
+			t.Errorf("%v: expected a ConfigError, got %v", args, err)
+		}
+	}
+
+	_, err := LoadServer("server", []string{"-no-such-flag"})
+	if err == nil {
+		t.Error("Unknown flag was accepted")
+	}
+}
+
+// Relative data files are resolved against the data directory; absolute and empty paths are left alone
+func TestDataPath(t *testing.T) {
+	cfg, err := LoadServer("server", []string{"-data-dir", "/var/lib/chat", "-history", "/tmp/history.log", "-bans", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AccountsFile != filepath.Join("/var/lib/chat", "accounts.txt") {
+		t.Errorf("Accounts file resolved to %q", cfg.AccountsFile)
+	}
+	if cfg.HistoryFile != "/tmp/history.log" {
+		t.Errorf("History file resolved to %q", cfg.HistoryFile)
+	}
+	if cfg.BansFile != "" {
+		t.Errorf("Disabled ban list resolved to %q", cfg.BansFile)
+	}
+}
+
+// The password comes from the environment, then the password file, then the config file
+func TestLoadClientPassword(t *testing.T) {
+	passwordFile := writeFile(t, "password", "from file\r\nsecond line\n")
+	configFile := writeFile(t, "client.json", `{"username": "alice", "password": "from config"}`)
+
+	tests := []struct {
+		env      string
+		setEnv   bool
+		args     []string
+		password string
+	}{
+		{"", false, []string{"-config", configFile}, "from config"},
+		{"", false, []string{"-config", configFile, "-password-file", passwordFile}, "from file"},
+		{"from env", true, []string{"-config", configFile, "-password-file", passwordFile}, "from env"},
+		{"", true, []string{"-config", configFile}, ""},
+	}
+
+	// Restores the variable once the test ends
+	t.Setenv(PasswordEnv, "")
+
+	for _, test := range tests {
+		if test.setEnv {
+			t.Setenv(PasswordEnv, test.env)
+		} else {
+			os.Unsetenv(PasswordEnv)
+		}
+
+		cfg, err := LoadClient("client", test.args)
+		if err != nil {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+		if cfg.Password != test.password {
+			t.Errorf("%v with %v=%q set %v: password is %q, expected %q", test.args, PasswordEnv, test.env, test.setEnv, cfg.Password, test.password)
+		}
+		if cfg.Username != "alice" {
+			t.Errorf("%v: username is %q", test.args, cfg.Username)
+		}
+	}
+
+	os.Unsetenv(PasswordEnv)
+	_, err := LoadClient("client", []string{"-password-file", filepath.Join(t.TempDir(), "missing")})
+	if _, ok := err.(*ConfigError); !ok {
+		t.Errorf("Missing password file returned %v", err)
+	}
+}
+
+// The admin token is never a flag; it comes from the environment, then the token file, then the config file
+func TestLoadServerAdminToken(t *testing.T) {
+	tokenFile := writeFile(t, "token", "from file\n")
+	configFile := writeFile(t, "server.json", `{"admin_token": "from config"}`)
+	t.Setenv(AdminTokenEnv, "")
+
+	os.Unsetenv(AdminTokenEnv)
+	cfg, err := LoadServer("server", []string{"-config", configFile})
+	if err != nil || cfg.AdminToken != "from config" {
+		t.Errorf("Loaded token %q, %v from the config file", cfg.AdminToken, err)
+	}
+
+	cfg, err = LoadServer("server", []string{"-config", configFile, "-admin-token-file", tokenFile})
+	if err != nil || cfg.AdminToken != "from file" {
+		t.Errorf("Loaded token %q, %v from the token file", cfg.AdminToken, err)
+	}
+
+	os.Setenv(AdminTokenEnv, "from env")
+	cfg, err = LoadServer("server", []string{"-config", configFile, "-admin-token-file", tokenFile})
+	if err != nil || cfg.AdminToken != "from env" {
+		t.Errorf("Loaded token %q, %v from the environment", cfg.AdminToken, err)
+	}
+
+	_, err = LoadServer("server", []string{"-admin-token", "secret"})
+	if err == nil {
+		t.Error("The admin token was accepted as a flag")
+	}
+}
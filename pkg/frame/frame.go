@@ -0,0 +1,87 @@
+package frame
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// Every frame is prefixed by a little-endian uint32 holding the payload length
+	HeaderSize = 4
+
+	// Upper bound on a single payload, guards against allocating on a corrupt header
+	MaxFrameSize = 1 << 20
+)
+
+type FrameError struct {
+	Message string
+}
+
+func (err *FrameError) Error() string {
+	return err.Message
+}
+
+// Reads length-prefixed frames from a byte stream, regardless of how the stream was segmented
+type Reader struct {
+	reader *bufio.Reader
+	header [HeaderSize]byte
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{reader: bufio.NewReader(r)}
+}
+
+// Blocks until a complete frame has been read and returns its payload
+func (r *Reader) ReadFrame() ([]byte, error) {
+	_, err := io.ReadFull(r.reader, r.header[:])
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.LittleEndian.Uint32(r.header[:])
+	if length > MaxFrameSize {
+		return nil, &FrameError{Message: fmt.Sprintf("Frame of %v bytes exceeds maximum of %v", length, MaxFrameSize)}
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r.reader, payload)
+	if err == io.EOF {
+		// The header was read, so EOF here means the frame was cut short
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// Writes length-prefixed frames to a byte stream; safe for use by multiple goroutines
+type Writer struct {
+	writer io.Writer
+	mutex  sync.Mutex
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{writer: w}
+}
+
+// Writes the header and payload with a single call so concurrent frames never interleave
+func (w *Writer) WriteFrame(payload []byte) error {
+	if len(payload) > MaxFrameSize {
+		return &FrameError{Message: fmt.Sprintf("Frame of %v bytes exceeds maximum of %v", len(payload), MaxFrameSize)}
+	}
+
+	buffer := make([]byte, HeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buffer, uint32(len(payload)))
+	copy(buffer[HeaderSize:], payload)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	_, err := w.writer.Write(buffer)
+	return err
+}
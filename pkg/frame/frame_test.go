@@ -0,0 +1,89 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestRoundTrip(t *testing.T) {
+	payloads := [][]byte{{}, []byte("a"), []byte("hello"), bytes.Repeat([]byte{0xAB}, 70000), make([]byte, MaxFrameSize)}
+
+	var stream bytes.Buffer
+	writer := NewWriter(&stream)
+	for _, payload := range payloads {
+		err := writer.WriteFrame(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader := NewReader(&stream)
+	for i, expected := range payloads {
+		payload, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Frame %v: %v", i, err)
+		}
+		if !bytes.Equal(payload, expected) {
+			t.Fatalf("Frame %v: read %v bytes, expected %v", i, len(payload), len(expected))
+		}
+	}
+
+	_, err := reader.ReadFrame()
+	if err != io.EOF {
+		t.Errorf("Expected EOF after the last frame, got %v", err)
+	}
+}
+
+// Frames split across reads, down to a byte at a time, must come out whole
+func TestSplitReads(t *testing.T) {
+	var stream bytes.Buffer
+	writer := NewWriter(&stream)
+	writer.WriteFrame([]byte("first"))
+	writer.WriteFrame([]byte("second"))
+
+	reader := NewReader(iotest.OneByteReader(&stream))
+	for _, expected := range []string{"first", "second"} {
+		payload, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(payload) != expected {
+			t.Errorf("Read %q, expected %q", payload, expected)
+		}
+	}
+}
+
+func TestTruncatedFrame(t *testing.T) {
+	var stream bytes.Buffer
+	NewWriter(&stream).WriteFrame([]byte("cut short"))
+	stream.Truncate(stream.Len() - 3)
+
+	_, err := NewReader(&stream).ReadFrame()
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected ErrUnexpectedEOF, got %v", err)
+	}
+
+	// A partial header is cut short too
+	_, err = NewReader(bytes.NewReader([]byte{1, 0})).ReadFrame()
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected ErrUnexpectedEOF for a partial header, got %v", err)
+	}
+}
+
+func TestOversizedFrame(t *testing.T) {
+	err := NewWriter(io.Discard).WriteFrame(make([]byte, MaxFrameSize+1))
+	if _, ok := err.(*FrameError); !ok {
+		t.Errorf("Expected a FrameError writing an oversized frame, got %v", err)
+	}
+
+	// The header alone is enough to refuse the frame, before any payload is allocated
+	header := make([]byte, HeaderSize)
+	binary.LittleEndian.PutUint32(header, MaxFrameSize+1)
+	_, err = NewReader(bytes.NewReader(header)).ReadFrame()
+	if _, ok := err.(*FrameError); !ok {
+		t.Errorf("Expected a FrameError reading an oversized frame, got %v", err)
+	}
+}
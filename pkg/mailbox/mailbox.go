@@ -0,0 +1,390 @@
+package mailbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/frame"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+const (
+	// Messages held for a single recipient before further ones are refused
+	DefaultCapacity = 100
+)
+
+type MailboxError struct {
+	Message string
+}
+
+func (err *MailboxError) Error() string {
+	return err.Message
+}
+
+// A whisper held for a recipient who was offline when it was sent
+type Message struct {
+	Recipient string
+	Sent      time.Time
+	Response  response.Response
+}
+
+// Undelivered messages by recipient, oldest first, kept in an append-only log of length-prefixed records
+// Adding a message appends it and removing delivered messages appends a record saying how many, so neither rewrites the file;
+// the log is compacted to the messages still held when it is opened. An empty path keeps the messages in memory only
+type Store struct {
+	Path     string
+	Capacity int
+	messages map[string][]Message
+	file     *os.File
+	writer   *frame.Writer
+	mutex    sync.Mutex
+}
+
+func NewStore() *Store {
+	return &Store{Capacity: DefaultCapacity, messages: make(map[string][]Message)}
+}
+
+func OpenStore(path string) (*Store, error) {
+	store := NewStore()
+	store.Path = path
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replay the log; a truncated final record is left over from a crash and cut off
+	reader := frame.NewReader(file)
+	var offset int64
+	records := 0
+	for {
+		buf, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			err = file.Truncate(offset)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, &MailboxError{Message: fmt.Sprintf("Corrupt mailbox %v: %v", path, err)}
+		}
+
+		err = store.replay(buf)
+		if err != nil {
+			file.Close()
+			return nil, &MailboxError{Message: fmt.Sprintf("Corrupt mailbox %v: %v", path, err)}
+		}
+
+		records++
+		offset += int64(frame.HeaderSize + len(buf))
+	}
+
+	held := 0
+	for _, messages := range store.messages {
+		held += len(messages)
+	}
+
+	// Delivered messages are dropped from the log once, here, rather than each time they are removed
+	if records > held {
+		file.Close()
+		err = store.compact()
+		if err != nil {
+			return nil, err
+		}
+
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	store.file = file
+	store.writer = frame.NewWriter(file)
+	return store, nil
+}
+
+// Applies a record read back from the log
+func (store *Store) replay(buffer []byte) error {
+	if len(buffer) == 0 {
+		return io.ErrUnexpectedEOF
+	}
+
+	switch recordType(buffer[0]) {
+	case record_Message:
+		msg, err := DeserializeMessage(buffer[1:])
+		if err != nil {
+			return err
+		}
+		store.messages[msg.Recipient] = append(store.messages[msg.Recipient], msg)
+	case record_Removal:
+		recipient, count, err := deserializeRemoval(buffer[1:])
+		if err != nil {
+			return err
+		}
+		store.drop(recipient, count)
+	default:
+		return &MailboxError{Message: fmt.Sprintf("Unknown record type %v", buffer[0])}
+	}
+
+	return nil
+}
+
+// Holds a message for its recipient and appends it to the log; fails if the recipient's mailbox is full
+func (store *Store) Add(msg Message) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.Capacity > 0 && len(store.messages[msg.Recipient]) >= store.Capacity {
+		return &MailboxError{Message: fmt.Sprintf("The mailbox of %v is full", msg.Recipient)}
+	}
+
+	// The message is only held once it is on disk, so a message is never delivered that the sender was told was lost
+	buf, err := SerializeMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	err = store.append(record_Message, buf)
+	if err != nil {
+		return err
+	}
+
+	store.messages[msg.Recipient] = append(store.messages[msg.Recipient], msg)
+	return nil
+}
+
+// Returns a recipient's messages, oldest first, without removing them
+func (store *Store) Messages(recipient string) []Message {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return append([]Message(nil), store.messages[recipient]...)
+}
+
+// Removes a recipient's oldest messages once they have been delivered, appending a record of the removal to the log
+func (store *Store) Remove(recipient string, count int) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if count <= 0 {
+		return nil
+	}
+
+	err := store.append(record_Removal, serializeRemoval(recipient, count))
+	if err != nil {
+		return err
+	}
+
+	store.drop(recipient, count)
+	return nil
+}
+
+// Number of messages held for a recipient
+func (store *Store) Count(recipient string) int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return len(store.messages[recipient])
+}
+
+// Flushes the log to disk and closes it
+func (store *Store) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.file == nil {
+		return nil
+	}
+
+	err := store.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	err = store.file.Close()
+	store.file = nil
+	store.writer = nil
+	return err
+}
+
+func (store *Store) drop(recipient string, count int) {
+	messages := store.messages[recipient]
+	if count >= len(messages) {
+		delete(store.messages, recipient)
+		return
+	}
+	store.messages[recipient] = messages[count:]
+}
+
+// Appends a record to the log and flushes it to disk; does nothing for a store kept in memory
+func (store *Store) append(kind recordType, body []byte) error {
+	if store.writer == nil {
+		return nil
+	}
+
+	err := store.writer.WriteFrame(append([]byte{byte(kind)}, body...))
+	if err != nil {
+		return err
+	}
+
+	return store.file.Sync()
+}
+
+// Rewrites the log with only the messages held; the rename keeps the old log intact if writing fails
+func (store *Store) compact() error {
+	tmp := store.Path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	buffered := bufio.NewWriter(file)
+	writer := frame.NewWriter(buffered)
+	for _, messages := range store.messages {
+		for _, msg := range messages {
+			var buf []byte
+			buf, err = SerializeMessage(msg)
+			if err == nil {
+				err = writer.WriteFrame(append([]byte{byte(record_Message)}, buf...))
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, store.Path)
+}
+
+// Each record in the log starts with its type
+type recordType uint8
+
+const (
+	// A held message, as written by SerializeMessage
+	record_Message recordType = iota
+
+	// The number of a recipient's oldest messages that were delivered
+	record_Removal
+)
+
+// Removals are stored as the recipient then the number of messages removed
+func serializeRemoval(recipient string, count int) []byte {
+	buffer := make([]byte, 4+len(recipient)+4)
+	binary.LittleEndian.PutUint32(buffer, uint32(len(recipient)))
+	copy(buffer[4:], recipient)
+	binary.LittleEndian.PutUint32(buffer[4+len(recipient):], uint32(count))
+	return buffer
+}
+
+func deserializeRemoval(buffer []byte) (string, int, error) {
+	if len(buffer) < 4 {
+		return "", 0, io.ErrUnexpectedEOF
+	}
+
+	strLength := binary.LittleEndian.Uint32(buffer)
+	if int64(len(buffer)) != 4+int64(strLength)+4 {
+		return "", 0, io.ErrUnexpectedEOF
+	}
+
+	recipient := string(buffer[4 : 4+strLength])
+	count := binary.LittleEndian.Uint32(buffer[4+strLength:])
+	return recipient, int(count), nil
+}
+
+// Messages are stored as the recipient, the time sent, then the serialized response
+func SerializeMessage(msg Message) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	err := binary.Write(buffer, binary.LittleEndian, uint32(len(msg.Recipient)))
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, []byte(msg.Recipient))
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, msg.Sent.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := response.Serialize(msg.Response)
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, res)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func DeserializeMessage(buffer []byte) (Message, error) {
+	reader := bytes.NewReader(buffer)
+	msg := Message{}
+
+	var strLength uint32
+	var sent int64
+
+	err := binary.Read(reader, binary.LittleEndian, &strLength)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if int64(strLength) > int64(reader.Len()) {
+		return Message{}, io.ErrUnexpectedEOF
+	}
+	strBuf := make([]byte, strLength)
+	err = binary.Read(reader, binary.LittleEndian, strBuf)
+	if err != nil {
+		return Message{}, err
+	}
+	msg.Recipient = string(strBuf)
+
+	err = binary.Read(reader, binary.LittleEndian, &sent)
+	if err != nil {
+		return Message{}, err
+	}
+	msg.Sent = time.Unix(0, sent).UTC()
+
+	msg.Response, err = response.Deserialize(buffer[len(buffer)-reader.Len():])
+	if err != nil {
+		return Message{}, err
+	}
+
+	return msg, nil
+}
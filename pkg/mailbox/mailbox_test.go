@@ -0,0 +1,217 @@
+package mailbox
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+func testMessage(recipient string, content string) Message {
+	return Message{
+		Recipient: recipient,
+		Sent:      time.Date(2024, 5, 1, 12, 30, 0, 500, time.UTC),
+		Response:  response.Response{ResType: response.ResponseType_Whisper, SenderName: "alice", ReceiverName: recipient, Content: content},
+	}
+}
+
+func TestSerializeMessage(t *testing.T) {
+	msg := testMessage("bob", "hello")
+
+	buf, err := SerializeMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := DeserializeMessage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, msg) {
+		t.Errorf("Read back %+v, expected %+v", read, msg)
+	}
+
+	_, err = DeserializeMessage(buf[:6])
+	if err == nil {
+		t.Error("Read a truncated message")
+	}
+}
+
+func TestStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mailbox.dat")
+
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []Message{testMessage("bob", "first"), testMessage("carol", "hi"), testMessage("bob", "second")} {
+		err = store.Add(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Removing appends a record, so the messages stay removed after reopening
+	held := store.Messages("carol")
+	if len(held) != 1 || held[0].Response.Content != "hi" {
+		t.Fatalf("Holding %+v for carol", held)
+	}
+	err = store.Remove("carol", len(held))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Count("carol") != 0 {
+		t.Error("Removed messages came back after reopening")
+	}
+
+	held = store.Messages("bob")
+	if len(held) != 2 || held[0].Response.Content != "first" || held[1].Response.Content != "second" {
+		t.Errorf("Messages for bob read back as %+v", held)
+	}
+	store.Close()
+}
+
+// Only the messages that were delivered are removed, oldest first, and the rest survive reopening
+func TestStorePartialRemoval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mailbox.dat")
+
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"first", "second", "third"} {
+		store.Add(testMessage("bob", content))
+	}
+	err = store.Remove("bob", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	held := store.Messages("bob")
+	if len(held) != 1 || held[0].Response.Content != "third" {
+		t.Errorf("Messages for bob read back as %+v", held)
+	}
+}
+
+// Opening the log drops the messages that were taken, so it does not grow without bound
+func TestStoreCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mailbox.dat")
+
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		store.Add(testMessage("bob", "delivered"))
+		store.Remove("bob", 1)
+	}
+	store.Add(testMessage("bob", "waiting"))
+	store.Close()
+
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() >= before.Size() {
+		t.Errorf("Log is %v bytes after compaction, %v before", after.Size(), before.Size())
+	}
+
+	// The compacted log is still appended to
+	store.Add(testMessage("bob", "later"))
+	store.Close()
+
+	store, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	held := store.Messages("bob")
+	if len(held) != 2 || held[0].Response.Content != "waiting" || held[1].Response.Content != "later" {
+		t.Errorf("Messages after compaction read back as %+v", held)
+	}
+}
+
+// A record cut short by a crash is dropped, keeping the messages before it
+func TestStoreTruncatedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mailbox.dat")
+
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Add(testMessage("bob", "kept"))
+	store.Add(testMessage("bob", "cut off"))
+	store.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Truncate(path, info.Size()-3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if store.Count("bob") != 1 {
+		t.Errorf("Holding %v messages for bob, expected only the complete one", store.Count("bob"))
+	}
+}
+
+func TestStoreCapacity(t *testing.T) {
+	store := NewStore()
+	store.Capacity = 2
+
+	for i := 0; i < 2; i++ {
+		err := store.Add(testMessage("bob", "hi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := store.Add(testMessage("bob", "one too many"))
+	if _, ok := err.(*MailboxError); !ok {
+		t.Errorf("Adding to a full mailbox returned %v", err)
+	}
+
+	// Each recipient has their own capacity
+	err = store.Add(testMessage("carol", "hi"))
+	if err != nil {
+		t.Error(err)
+	}
+}
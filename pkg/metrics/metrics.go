@@ -0,0 +1,334 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Latency buckets in seconds, from 50µs to 1s
+var DefaultBuckets = []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Holds metrics in the order they were registered, and writes them in the Prometheus text exposition format
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	header() (name string, help string, kind string)
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (registry *Registry) register(m metric) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.metrics = append(registry.metrics, m)
+}
+
+// A count that only goes up
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Uint64
+}
+
+func (registry *Registry) Counter(name string, help string) *Counter {
+	counter := &Counter{name: name, help: help}
+	registry.register(counter)
+	return counter
+}
+
+func (counter *Counter) Inc() {
+	counter.value.Add(1)
+}
+
+func (counter *Counter) Add(n uint64) {
+	counter.value.Add(n)
+}
+
+func (counter *Counter) Value() uint64 {
+	return counter.value.Load()
+}
+
+func (counter *Counter) header() (string, string, string) {
+	return counter.name, counter.help, "counter"
+}
+
+func (counter *Counter) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "%v %v\n", counter.name, counter.value.Load())
+}
+
+// A value that can go up and down
+type Gauge struct {
+	name  string
+	help  string
+	value atomic.Int64
+}
+
+func (registry *Registry) Gauge(name string, help string) *Gauge {
+	gauge := &Gauge{name: name, help: help}
+	registry.register(gauge)
+	return gauge
+}
+
+func (gauge *Gauge) Set(value int64) {
+	gauge.value.Store(value)
+}
+
+func (gauge *Gauge) Add(delta int64) {
+	gauge.value.Add(delta)
+}
+
+func (gauge *Gauge) Value() int64 {
+	return gauge.value.Load()
+}
+
+func (gauge *Gauge) header() (string, string, string) {
+	return gauge.name, gauge.help, "gauge"
+}
+
+func (gauge *Gauge) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "%v %v\n", gauge.name, gauge.value.Load())
+}
+
+// Counts observations into cumulative buckets, along with their sum
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+
+	// counts[i] holds observations in (buckets[i-1], buckets[i]]; the last entry holds those above every bucket
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Uint64
+}
+
+func newHistogram(name string, help string, buckets []float64) *Histogram {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &Histogram{name: name, help: help, buckets: sorted, counts: make([]atomic.Uint64, len(sorted)+1)}
+}
+
+func (registry *Registry) Histogram(name string, help string, buckets []float64) *Histogram {
+	histogram := newHistogram(name, help, buckets)
+	registry.register(histogram)
+	return histogram
+}
+
+func (histogram *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(histogram.buckets, value)
+	histogram.counts[i].Add(1)
+	histogram.count.Add(1)
+
+	// The sum is kept as float64 bits, so it is updated by compare and swap
+	for {
+		old := histogram.sum.Load()
+		if histogram.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+value)) {
+			return
+		}
+	}
+}
+
+func (histogram *Histogram) header() (string, string, string) {
+	return histogram.name, histogram.help, "histogram"
+}
+
+func (histogram *Histogram) write(w *bufio.Writer) {
+	histogram.writeLabelled(w, "")
+}
+
+// Writes the series with extra labels, given as `name="value"` pairs, ahead of le
+func (histogram *Histogram) writeLabelled(w *bufio.Writer, labels string) {
+	prefix := ""
+	suffix := ""
+	if labels != "" {
+		prefix = labels + ","
+		suffix = "{" + labels + "}"
+	}
+
+	// Buckets are cumulative, and the count is read first so a concurrent Observe never makes +Inf smaller than a bucket
+	count := histogram.count.Load()
+	var cumulative uint64
+	for i, bound := range histogram.buckets {
+		cumulative += histogram.counts[i].Load()
+		if cumulative > count {
+			cumulative = count
+		}
+		fmt.Fprintf(w, "%v_bucket{%vle=\"%v\"} %v\n", histogram.name, prefix, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%v_bucket{%vle=\"+Inf\"} %v\n", histogram.name, prefix, count)
+	fmt.Fprintf(w, "%v_sum%v %v\n", histogram.name, suffix, formatFloat(math.Float64frombits(histogram.sum.Load())))
+	fmt.Fprintf(w, "%v_count%v %v\n", histogram.name, suffix, count)
+}
+
+// Counters split by label values, created the first time each combination is used
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mutex  sync.Mutex
+	series map[string]*Counter
+}
+
+func (registry *Registry) CounterVec(name string, help string, labels ...string) *CounterVec {
+	vec := &CounterVec{name: name, help: help, labels: labels, series: make(map[string]*Counter)}
+	registry.register(vec)
+	return vec
+}
+
+// Values are given in the order the labels were declared
+func (vec *CounterVec) With(values ...string) *Counter {
+	key := formatLabels(vec.labels, values)
+
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	counter, ok := vec.series[key]
+	if !ok {
+		counter = &Counter{name: vec.name}
+		vec.series[key] = counter
+	}
+	return counter
+}
+
+func (vec *CounterVec) header() (string, string, string) {
+	return vec.name, vec.help, "counter"
+}
+
+func (vec *CounterVec) write(w *bufio.Writer) {
+	vec.mutex.Lock()
+	keys := sortedKeys(vec.series)
+	series := make([]*Counter, len(keys))
+	for i, key := range keys {
+		series[i] = vec.series[key]
+	}
+	vec.mutex.Unlock()
+
+	for i, key := range keys {
+		fmt.Fprintf(w, "%v{%v} %v\n", vec.name, key, series[i].Value())
+	}
+}
+
+// Histograms split by label values, created the first time each combination is used
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mutex  sync.Mutex
+	series map[string]*Histogram
+}
+
+func (registry *Registry) HistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	vec := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*Histogram)}
+	registry.register(vec)
+	return vec
+}
+
+func (vec *HistogramVec) With(values ...string) *Histogram {
+	key := formatLabels(vec.labels, values)
+
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	histogram, ok := vec.series[key]
+	if !ok {
+		histogram = newHistogram(vec.name, vec.help, vec.buckets)
+		vec.series[key] = histogram
+	}
+	return histogram
+}
+
+func (vec *HistogramVec) header() (string, string, string) {
+	return vec.name, vec.help, "histogram"
+}
+
+func (vec *HistogramVec) write(w *bufio.Writer) {
+	vec.mutex.Lock()
+	keys := sortedKeys(vec.series)
+	series := make([]*Histogram, len(keys))
+	for i, key := range keys {
+		series[i] = vec.series[key]
+	}
+	vec.mutex.Unlock()
+
+	for i, key := range keys {
+		series[i].writeLabelled(w, key)
+	}
+}
+
+func sortedKeys[T any](series map[string]T) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Formats label pairs as they appear between the braces of a sample; missing values are left empty
+func formatLabels(labels []string, values []string) string {
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = fmt.Sprintf("%v=\"%v\"", label, labelEscaper.Replace(value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Writes every metric in the text exposition format
+func (registry *Registry) Write(w io.Writer) error {
+	registry.mutex.Lock()
+	metrics := append([]metric(nil), registry.metrics...)
+	registry.mutex.Unlock()
+
+	buffered := bufio.NewWriter(w)
+	for _, m := range metrics {
+		name, help, kind := m.header()
+		fmt.Fprintf(buffered, "# HELP %v %v\n", name, helpEscaper.Replace(help))
+		fmt.Fprintf(buffered, "# TYPE %v %v\n", name, kind)
+		m.write(buffered)
+	}
+	return buffered.Flush()
+}
+
+// Serves the registry for scraping
+func (registry *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		registry.Write(w)
+	})
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	registry := NewRegistry()
+
+	requests := registry.Counter("requests_total", "Requests received")
+	requests.Inc()
+	requests.Add(2)
+
+	clients := registry.Gauge("clients", "Connected clients")
+	clients.Set(5)
+	clients.Add(-2)
+
+	latency := registry.Histogram("latency_seconds", "Request latency", []float64{0.5, 0.1})
+	for _, value := range []float64{0.05, 0.1, 0.25, 2} {
+		latency.Observe(value)
+	}
+
+	var out bytes.Buffer
+	err := registry.Write(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Buckets are sorted and cumulative, and an observation on a bound falls in that bucket
+	expected := `# HELP requests_total Requests received
+# TYPE requests_total counter
+requests_total 3
+# HELP clients Connected clients
+# TYPE clients gauge
+clients 3
+# HELP latency_seconds Request latency
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="0.5"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 2.4
+latency_seconds_count 4
+`
+	if out.String() != expected {
+		t.Errorf("Wrote:\n%v\nexpected:\n%v", out.String(), expected)
+	}
+}
+
+func TestWriteLabelled(t *testing.T) {
+	registry := NewRegistry()
+
+	refused := registry.CounterVec("refused_total", "Refused connections\nby code", "code")
+	refused.With("server_full").Inc()
+	refused.With("banned").Add(2)
+	refused.With("say \"hi\"").Inc()
+
+	latency := registry.HistogramVec("handle_seconds", "Handling latency", []float64{1}, "type")
+	latency.With("message").Observe(0.5)
+
+	var out bytes.Buffer
+	registry.Write(&out)
+
+	// Series are sorted by their labels, and help text and label values are escaped
+	expected := `# HELP refused_total Refused connections\nby code
+# TYPE refused_total counter
+refused_total{code="banned"} 2
+refused_total{code="say \"hi\""} 1
+refused_total{code="server_full"} 1
+# HELP handle_seconds Handling latency
+# TYPE handle_seconds histogram
+handle_seconds_bucket{type="message",le="1"} 1
+handle_seconds_bucket{type="message",le="+Inf"} 1
+handle_seconds_sum{type="message"} 0.5
+handle_seconds_count{type="message"} 1
+`
+	if out.String() != expected {
+		t.Errorf("Wrote:\n%v\nexpected:\n%v", out.String(), expected)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	registry := NewRegistry()
+	registry.Counter("requests_total", "Requests received").Inc()
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Served %v with content type %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	recorder = httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST was answered with %v", recorder.Code)
+	}
+}
@@ -0,0 +1,172 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/edobrowo/gochatroom/pkg/frame"
+)
+
+const (
+	// Opens every hello, "GCHT" in little-endian byte order; anything else is not a gochatroom peer
+	Magic uint32 = 0x54484347
+
+	// The protocol version spoken by this build, and the oldest version it can still talk to
+	// Bump Version whenever a released layout of a request or response changes
+	Version    uint16 = 1
+	MinVersion uint16 = 1
+
+	// Magic, both versions and the feature set; longer hellos are accepted so later versions can add fields
+	HelloSize = 16
+)
+
+type ProtocolError struct {
+	Message string
+}
+
+func (err *ProtocolError) Error() string {
+	return err.Message
+}
+
+// Optional capabilities, enabled on a connection only when both sides advertise them
+type Features uint64
+
+const (
+	// The server issues session tokens and accepts Status_Resume after a dropped connection
+	Feature_Sessions Features = 1 << iota
+
+	// Failures are sent as ResponseType_Error with an ErrorCode instead of as plain server messages
+	Feature_ErrorCodes
+
+	// Edited and deleted messages are sent as ResponseType_Edit and ResponseType_Delete instead of as room notices
+	Feature_Edits
+
+	// Reaction counts are sent as ResponseType_Reactions; without it they are not sent at all
+	Feature_Reactions
+)
+
+// Every feature this build implements
+const Supported = Feature_Sessions | Feature_ErrorCodes | Feature_Edits | Feature_Reactions
+
+var featureNames = []struct {
+	feature Features
+	name    string
+}{
+	{Feature_Sessions, "sessions"},
+	{Feature_ErrorCodes, "error-codes"},
+	{Feature_Edits, "edits"},
+	{Feature_Reactions, "reactions"},
+}
+
+func (features Features) Has(feature Features) bool {
+	return features&feature == feature
+}
+
+func (features Features) String() string {
+	var names []string
+	for _, f := range featureNames {
+		if features.Has(f.feature) {
+			names = append(names, f.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// Sent by each side before anything else; the client speaks first and the server answers with what was agreed
+type Hello struct {
+	Version    uint16
+	MinVersion uint16
+	Features   Features
+}
+
+func NewHello(features Features) Hello {
+	return Hello{Version: Version, MinVersion: MinVersion, Features: features}
+}
+
+// Agrees on the newest version both sides speak and the features both advertise
+func Negotiate(local Hello, remote Hello) (Hello, error) {
+	version := local.Version
+	if remote.Version < version {
+		version = remote.Version
+	}
+
+	if version < local.MinVersion || version < remote.MinVersion {
+		return Hello{}, &ProtocolError{Message: fmt.Sprintf("Incompatible protocol version %v (supported versions are %v to %v)", remote.Version, local.MinVersion, local.Version)}
+	}
+
+	return Hello{Version: version, MinVersion: version, Features: local.Features & remote.Features}, nil
+}
+
+// Reports whether a frame begins with the hello magic
+func IsHello(buffer []byte) bool {
+	return len(buffer) >= 4 && binary.LittleEndian.Uint32(buffer) == Magic
+}
+
+func Serialize(hello Hello) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	err := binary.Write(buffer, binary.LittleEndian, Magic)
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, hello.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, hello.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, uint64(hello.Features))
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func Deserialize(buffer []byte) (Hello, error) {
+	if !IsHello(buffer) {
+		return Hello{}, &ProtocolError{Message: "Peer did not send a protocol hello; it may need to be upgraded"}
+	}
+	if len(buffer) < HelloSize {
+		return Hello{}, &ProtocolError{Message: "Protocol hello is truncated"}
+	}
+
+	hello := Hello{
+		Version:    binary.LittleEndian.Uint16(buffer[4:]),
+		MinVersion: binary.LittleEndian.Uint16(buffer[6:]),
+		Features:   Features(binary.LittleEndian.Uint64(buffer[8:])),
+	}
+	if hello.MinVersion > hello.Version {
+		return Hello{}, &ProtocolError{Message: "Protocol hello has an invalid version range"}
+	}
+
+	return hello, nil
+}
+
+func Write(writer *frame.Writer, hello Hello) error {
+	buf, err := Serialize(hello)
+	if err != nil {
+		return err
+	}
+
+	return writer.WriteFrame(buf)
+}
+
+func Read(reader *frame.Reader) (Hello, error) {
+	buf, err := reader.ReadFrame()
+	if err != nil {
+		return Hello{}, err
+	}
+
+	return Deserialize(buf)
+}
@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	agreed, err := Negotiate(NewHello(Supported), Hello{Version: Version + 1, MinVersion: 1, Features: Supported})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agreed.Version != Version || agreed.Features != Supported {
+		t.Errorf("Agreed on %+v with a newer peer", agreed)
+	}
+
+	// Only the features both sides advertise are enabled
+	agreed, err = Negotiate(NewHello(Feature_Sessions|Feature_ErrorCodes), Hello{Version: Version, MinVersion: MinVersion, Features: Feature_Sessions | Feature_Edits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agreed.Features != Feature_Sessions {
+		t.Errorf("Agreed on features %v", agreed.Features)
+	}
+
+	_, err = Negotiate(NewHello(Supported), Hello{Version: Version + 2, MinVersion: Version + 1})
+	if _, ok := err.(*ProtocolError); !ok {
+		t.Errorf("Expected a ProtocolError for a peer that is too new, got %v", err)
+	}
+}
+
+func TestHelloRoundTrip(t *testing.T) {
+	hello := NewHello(Supported)
+	buf, err := Serialize(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) != HelloSize || !IsHello(buf) {
+		t.Fatalf("Hello serialized as %x", buf)
+	}
+
+	read, err := Deserialize(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != hello {
+		t.Errorf("Read %+v, expected %+v", read, hello)
+	}
+
+	_, err = Deserialize(buf[:HelloSize-1])
+	if err == nil {
+		t.Error("Truncated hello was accepted")
+	}
+}
@@ -3,7 +3,12 @@ package request
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
 	"strings"
+
+	"github.com/edobrowo/gochatroom/pkg/frame"
 )
 
 type RequestType int
@@ -29,13 +34,123 @@ const (
 	Command_Ping CommandType = 2
 
 	Command_Unknown CommandType = 3
+
+	// Move to another room, creating it if needed
+	Command_Join CommandType = 4
+
+	// Return to the default room
+	Command_Leave CommandType = 5
+
+	// List rooms and their member counts
+	Command_Rooms CommandType = 6
+
+	// Replay recent messages from the current room
+	Command_History CommandType = 7
+
+	// Protect the current username with a password
+	Command_Register CommandType = 8
+
+	// Moderation commands, available to operators only
+	// ReceiverName holds the target; Content holds the kick reason or the ban/mute duration
+	Command_Kick   CommandType = 9
+	Command_Ban    CommandType = 10
+	Command_Unban  CommandType = 11
+	Command_Mute   CommandType = 12
+	Command_Unmute CommandType = 13
+	Command_Op     CommandType = 14
+	Command_Deop   CommandType = 15
+
+	// Show the depth of each client's outbound queue, for operators
+	Command_Queues CommandType = 16
+
+	// Replace or remove an earlier message; MessageID holds its ID and Content the new text
+	// Allowed for the message's author and operators
+	Command_Edit   CommandType = 17
+	Command_Delete CommandType = 18
+
+	// Answer an earlier message in the current room; ParentID holds its ID
+	Command_Reply CommandType = 19
+
+	// List an earlier message with every reply to it; Content holds the message ID
+	Command_Thread CommandType = 20
+
+	// Add or remove a reaction to an earlier message; MessageID holds its ID and Content the emoji or its shortcode
+	Command_React   CommandType = 21
+	Command_Unreact CommandType = 22
+
+	// Only receive room messages that mention you, until /back
+	Command_Away CommandType = 23
+	Command_Back CommandType = 24
 )
 
+var requestTypeNames = map[RequestType]string{
+	RequestType_Message: "message",
+	RequestType_Command: "command",
+	RequestType_Status:  "status",
+}
+
+// Reports whether the type is one this version of the protocol defines
+func (reqType RequestType) Known() bool {
+	_, ok := requestTypeNames[reqType]
+	return ok
+}
+
+func (reqType RequestType) String() string {
+	name, ok := requestTypeNames[reqType]
+	if !ok {
+		return fmt.Sprintf("type %v", int(reqType))
+	}
+	return name
+}
+
+var commandTypeNames = map[CommandType]string{
+	Command_Whisper:  "whisper",
+	Command_Ping:     "ping",
+	Command_Unknown:  "unknown",
+	Command_Join:     "join",
+	Command_Leave:    "leave",
+	Command_Rooms:    "rooms",
+	Command_History:  "history",
+	Command_Register: "register",
+	Command_Kick:     "kick",
+	Command_Ban:      "ban",
+	Command_Unban:    "unban",
+	Command_Mute:     "mute",
+	Command_Unmute:   "unmute",
+	Command_Op:       "op",
+	Command_Deop:     "deop",
+	Command_Queues:   "queues",
+	Command_Edit:     "edit",
+	Command_Delete:   "delete",
+	Command_Reply:    "reply",
+	Command_Thread:   "thread",
+	Command_React:    "react",
+	Command_Unreact:  "unreact",
+	Command_Away:     "away",
+	Command_Back:     "back",
+}
+
+func (cmdType CommandType) Known() bool {
+	_, ok := commandTypeNames[cmdType]
+	return ok
+}
+
+func (cmdType CommandType) String() string {
+	name, ok := commandTypeNames[cmdType]
+	if !ok {
+		return fmt.Sprintf("command %v", int(cmdType))
+	}
+	return name
+}
+
 type StatusType int
 
 const (
-	// Associates a connection to a username
+	// Associates a connection to a username; Content carries the password for registered accounts
 	Status_Register StatusType = 0
+
+	// Reclaims a username after a dropped connection; Content carries the session token
+	Status_Resume StatusType = 1
 )
 
 type Request struct {
@@ -45,7 +160,16 @@ type Request struct {
 	SenderName   string
 	ReceiverName string
 	Content      string
-	ClientAddr   string
+
+	// For edits, deletions and reactions, the ID of the message they change
+	MessageID uint64
+
+	// For replies, the ID of the message being answered
+	ParentID uint64
+
+	// Set by the server to identify the connection a request arrived on; neither is serialized
+	ClientAddr string
+	ConnID     uint64
 }
 
 func Parse(str string) Request {
@@ -55,11 +179,34 @@ func Parse(str string) Request {
 	requestIsCommand := strings.HasPrefix(str, "/")
 
 	commands := map[string]CommandType{
-		"whisper": Command_Whisper,
-		"w":       Command_Whisper,
-		"tell":    Command_Whisper,
-		"msg":     Command_Whisper,
-		"ping":    Command_Ping,
+		"whisper":  Command_Whisper,
+		"w":        Command_Whisper,
+		"tell":     Command_Whisper,
+		"msg":      Command_Whisper,
+		"ping":     Command_Ping,
+		"join":     Command_Join,
+		"j":        Command_Join,
+		"leave":    Command_Leave,
+		"rooms":    Command_Rooms,
+		"history":  Command_History,
+		"register": Command_Register,
+		"kick":     Command_Kick,
+		"ban":      Command_Ban,
+		"unban":    Command_Unban,
+		"mute":     Command_Mute,
+		"unmute":   Command_Unmute,
+		"op":       Command_Op,
+		"deop":     Command_Deop,
+		"queues":   Command_Queues,
+		"edit":     Command_Edit,
+		"delete":   Command_Delete,
+		"reply":    Command_Reply,
+		"re":       Command_Reply,
+		"thread":   Command_Thread,
+		"react":    Command_React,
+		"unreact":  Command_Unreact,
+		"away":     Command_Away,
+		"back":     Command_Back,
 	}
 
 	if requestIsCommand {
@@ -90,6 +237,74 @@ func Parse(str string) Request {
 			break
 		case Command_Ping:
 			req.CmdType = Command_Ping
+			break
+		case Command_Join:
+			req.CmdType = Command_Join
+
+			if len(tokens) >= 2 {
+				req.Content = tokens[1]
+			}
+
+			break
+		case Command_Leave:
+			req.CmdType = Command_Leave
+			break
+		case Command_Rooms:
+			req.CmdType = Command_Rooms
+			break
+		case Command_Queues, Command_Away, Command_Back:
+			req.CmdType = command
+			break
+		case Command_Reply:
+			req.CmdType = Command_Reply
+
+			// An ID that does not parse is left as zero, which the server rejects
+			if len(tokens) >= 2 {
+				req.ParentID, _ = strconv.ParseUint(tokens[1], 10, 64)
+			}
+			if len(tokens) >= 3 {
+				req.Content = strings.Join(tokens[2:], " ")
+			}
+
+			break
+		case Command_History, Command_Thread:
+			req.CmdType = command
+
+			if len(tokens) >= 2 {
+				req.Content = tokens[1]
+			}
+
+			break
+		case Command_Register:
+			req.CmdType = Command_Register
+
+			if len(tokens) >= 2 {
+				req.Content = strings.Join(tokens[1:], " ")
+			}
+
+			break
+		case Command_Edit, Command_Delete, Command_React, Command_Unreact:
+			req.CmdType = command
+
+			// As with replies, an ID that does not parse is left as zero
+			if len(tokens) >= 2 {
+				req.MessageID, _ = strconv.ParseUint(tokens[1], 10, 64)
+			}
+			if len(tokens) >= 3 {
+				req.Content = strings.Join(tokens[2:], " ")
+			}
+
+			break
+		case Command_Kick, Command_Ban, Command_Unban, Command_Mute, Command_Unmute, Command_Op, Command_Deop:
+			req.CmdType = command
+
+			if len(tokens) >= 2 {
+				req.ReceiverName = tokens[1]
+			}
+			if len(tokens) >= 3 {
+				req.Content = strings.Join(tokens[2:], " ")
+			}
+
 			break
 		default:
 			req.CmdType = Command_Unknown
@@ -152,6 +367,16 @@ func Serialize(req Request) ([]byte, error) {
 		return nil, err
 	}
 
+	err = binary.Write(buffer, binary.LittleEndian, req.MessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, req.ParentID)
+	if err != nil {
+		return nil, err
+	}
+
 	return buffer.Bytes(), nil
 }
 
@@ -161,7 +386,7 @@ func Deserialize(buffer []byte) (Request, error) {
 	req := Request{}
 
 	var strBuf []byte
-	var strLength int32
+	var strLength uint32
 	var reqType uint32
 	var cmdType uint32
 	var stType uint32
@@ -190,6 +415,9 @@ func Deserialize(buffer []byte) (Request, error) {
 		return Request{}, err
 	}
 
+	if int64(strLength) > int64(reader.Len()) {
+		return Request{}, io.ErrUnexpectedEOF
+	}
 	strBuf = make([]byte, strLength)
 	err = binary.Read(reader, binary.LittleEndian, strBuf)
 	if err != nil {
@@ -202,6 +430,9 @@ func Deserialize(buffer []byte) (Request, error) {
 		return Request{}, err
 	}
 
+	if int64(strLength) > int64(reader.Len()) {
+		return Request{}, io.ErrUnexpectedEOF
+	}
 	strBuf = make([]byte, strLength)
 	err = binary.Read(reader, binary.LittleEndian, strBuf)
 	if err != nil {
@@ -214,6 +445,9 @@ func Deserialize(buffer []byte) (Request, error) {
 		return Request{}, err
 	}
 
+	if int64(strLength) > int64(reader.Len()) {
+		return Request{}, io.ErrUnexpectedEOF
+	}
 	strBuf = make([]byte, strLength)
 	err = binary.Read(reader, binary.LittleEndian, strBuf)
 	if err != nil {
@@ -221,5 +455,35 @@ func Deserialize(buffer []byte) (Request, error) {
 	}
 	req.Content = string(strBuf)
 
+	err = binary.Read(reader, binary.LittleEndian, &req.MessageID)
+	if err != nil {
+		return Request{}, err
+	}
+
+	err = binary.Read(reader, binary.LittleEndian, &req.ParentID)
+	if err != nil {
+		return Request{}, err
+	}
+
 	return req, nil
 }
+
+// Serializes a request and writes it as a single frame
+func Write(writer *frame.Writer, req Request) error {
+	buf, err := Serialize(req)
+	if err != nil {
+		return err
+	}
+
+	return writer.WriteFrame(buf)
+}
+
+// Reads a single frame and deserializes it into a request
+func Read(reader *frame.Reader) (Request, error) {
+	buf, err := reader.ReadFrame()
+	if err != nil {
+		return Request{}, err
+	}
+
+	return Deserialize(buf)
+}
@@ -0,0 +1,49 @@
+package response
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type ReactionError struct {
+	Message string
+}
+
+func (err *ReactionError) Error() string {
+	return err.Message
+}
+
+// The number of users who reacted to a message with an emoji
+type Reaction struct {
+	Emoji string
+	Count int
+}
+
+// Formats counts as they are sent in ResponseType_Reactions: each emoji followed by its count, separated by spaces, such as "👍 2 🎉 1"
+// Emoji never contain spaces; an empty string means the message has no reactions left
+func FormatReactions(reactions []Reaction) string {
+	fields := make([]string, 0, 2*len(reactions))
+	for _, reaction := range reactions {
+		fields = append(fields, reaction.Emoji, strconv.Itoa(reaction.Count))
+	}
+	return strings.Join(fields, " ")
+}
+
+func ParseReactions(content string) ([]Reaction, error) {
+	fields := strings.Fields(content)
+	if len(fields)%2 != 0 {
+		return nil, &ReactionError{Message: fmt.Sprintf("Malformed reactions %q", content)}
+	}
+
+	reactions := make([]Reaction, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		count, err := strconv.Atoi(fields[i+1])
+		if err != nil || count < 0 {
+			return nil, &ReactionError{Message: fmt.Sprintf("Malformed reaction count %q", fields[i+1])}
+		}
+		reactions = append(reactions, Reaction{Emoji: fields[i], Count: count})
+	}
+
+	return reactions, nil
+}
@@ -3,6 +3,12 @@ package response
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/frame"
 )
 
 type ResponseType int
@@ -22,13 +28,160 @@ const (
 
 	// Indicates to the client to close its connection
 	ResponseType_TerminateConnection ResponseType = 4
+
+	// Response from the server to all users in a room
+	ResponseType_ServerRoom ResponseType = 5
+
+	// Confirms registration; Content carries the token used to resume the session after a dropped connection
+	ResponseType_Session ResponseType = 6
+
+	// A request failed; Code says why and Content carries a message for the user
+	ResponseType_Error ResponseType = 7
+
+	// An earlier message was changed; ID identifies it, SenderName is its author, ReceiverName who changed it and Content its new text
+	ResponseType_Edit ResponseType = 8
+
+	// An earlier message was removed and should be shown as deleted; ID identifies it, SenderName is its author and ReceiverName who removed it
+	ResponseType_Delete ResponseType = 9
+
+	// The reactions to an earlier message changed; ID identifies it, SenderName is who reacted and Content the counts, as formatted by FormatReactions
+	ResponseType_Reactions ResponseType = 10
+)
+
+var responseTypeNames = map[ResponseType]string{
+	ResponseType_Message:             "message",
+	ResponseType_Whisper:             "whisper",
+	ResponseType_ServerPriv:          "server_priv",
+	ResponseType_ServerAll:           "server_all",
+	ResponseType_TerminateConnection: "terminate",
+	ResponseType_ServerRoom:          "server_room",
+	ResponseType_Session:             "session",
+	ResponseType_Error:               "error",
+	ResponseType_Edit:                "edit",
+	ResponseType_Delete:              "delete",
+	ResponseType_Reactions:           "reactions",
+}
+
+func (resType ResponseType) String() string {
+	name, ok := responseTypeNames[resType]
+	if !ok {
+		return fmt.Sprintf("type %v", int(resType))
+	}
+	return name
+}
+
+// Machine-readable reason for a ResponseType_Error, also set on ResponseType_TerminateConnection
+// Values are part of the protocol, so existing codes must never be renumbered
+type ErrorCode int
+
+const (
+	ErrorCode_None ErrorCode = 0
+
+	// The request could not be understood
+	ErrorCode_MalformedRequest ErrorCode = 1
+	ErrorCode_UnknownCommand   ErrorCode = 2
+
+	// The request was understood but its arguments were wrong; Content explains the usage
+	ErrorCode_InvalidArgument ErrorCode = 3
+
+	// Registration failures
+	ErrorCode_UsernameTaken        ErrorCode = 4
+	ErrorCode_InvalidUsername      ErrorCode = 5
+	ErrorCode_AuthenticationFailed ErrorCode = 6
+	ErrorCode_SessionExpired       ErrorCode = 7
+
+	ErrorCode_NoSuchUser ErrorCode = 8
+
+	// The user may not perform the request, e.g. a moderation command from a non-operator
+	ErrorCode_Unauthorized ErrorCode = 9
+
+	ErrorCode_RateLimited    ErrorCode = 10
+	ErrorCode_Muted          ErrorCode = 11
+	ErrorCode_MessageTooLong ErrorCode = 12
+
+	// Reasons a connection is refused or closed
+	ErrorCode_Banned             ErrorCode = 13
+	ErrorCode_Kicked             ErrorCode = 14
+	ErrorCode_ServerFull         ErrorCode = 15
+	ErrorCode_TooManyConnections ErrorCode = 16
+	ErrorCode_ShuttingDown       ErrorCode = 17
+
+	// The server does not offer the feature, e.g. accounts when no account store is configured
+	ErrorCode_Unavailable ErrorCode = 18
+
+	ErrorCode_Internal ErrorCode = 19
+
+	// The peer did not open with a compatible protocol hello
+	ErrorCode_IncompatibleProtocol ErrorCode = 20
+
+	// No retained message has the given ID
+	ErrorCode_NoSuchMessage ErrorCode = 21
 )
 
+var errorCodeNames = map[ErrorCode]string{
+	ErrorCode_None:                 "none",
+	ErrorCode_MalformedRequest:     "malformed request",
+	ErrorCode_UnknownCommand:       "unknown command",
+	ErrorCode_InvalidArgument:      "invalid argument",
+	ErrorCode_UsernameTaken:        "username taken",
+	ErrorCode_InvalidUsername:      "invalid username",
+	ErrorCode_AuthenticationFailed: "authentication failed",
+	ErrorCode_SessionExpired:       "session expired",
+	ErrorCode_NoSuchUser:           "no such user",
+	ErrorCode_Unauthorized:         "unauthorized",
+	ErrorCode_RateLimited:          "rate limited",
+	ErrorCode_Muted:                "muted",
+	ErrorCode_MessageTooLong:       "message too long",
+	ErrorCode_Banned:               "banned",
+	ErrorCode_Kicked:               "kicked",
+	ErrorCode_ServerFull:           "server full",
+	ErrorCode_TooManyConnections:   "too many connections",
+	ErrorCode_ShuttingDown:         "shutting down",
+	ErrorCode_Unavailable:          "unavailable",
+	ErrorCode_Internal:             "internal error",
+	ErrorCode_IncompatibleProtocol: "incompatible protocol",
+	ErrorCode_NoSuchMessage:        "no such message",
+}
+
+func (code ErrorCode) String() string {
+	name, ok := errorCodeNames[code]
+	if !ok {
+		return fmt.Sprintf("error %v", int(code))
+	}
+	return name
+}
+
 type Response struct {
 	ResType      ResponseType
 	SenderName   string
 	ReceiverName string
 	Content      string
+	Code         ErrorCode
+
+	// Assigned by the server to broadcasts and whispers: an ID that increases with every message, and when it was sent in UTC
+	// Both are zero on other responses
+	ID   uint64
+	Time time.Time
+
+	// For replies, the ID of the message being answered; zero otherwise
+	ParentID uint64
+
+	// Users named with @username in a message or edit, in the order they appear
+	Mentions []string
+}
+
+// Mentions are matched without regard to case, so @Bob reaches bob
+func (res Response) MentionsUser(username string) bool {
+	for _, mention := range res.Mentions {
+		if strings.EqualFold(mention, username) {
+			return true
+		}
+	}
+	return false
+}
+
+func Error(code ErrorCode, content string) Response {
+	return Response{ResType: ResponseType_Error, Code: code, Content: content}
 }
 
 func Serialize(res Response) ([]byte, error) {
@@ -70,6 +223,50 @@ func Serialize(res Response) ([]byte, error) {
 		return nil, err
 	}
 
+	err = binary.Write(buffer, binary.LittleEndian, uint32(res.Code))
+	if err != nil {
+		return nil, err
+	}
+
+	// The time is sent as Unix nanoseconds, with 0 for none
+	var timestamp int64
+	if !res.Time.IsZero() {
+		timestamp = res.Time.UnixNano()
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, res.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, res.ParentID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Mentions are sent as a count followed by that many strings
+	err = binary.Write(buffer, binary.LittleEndian, uint32(len(res.Mentions)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mention := range res.Mentions {
+		err = binary.Write(buffer, binary.LittleEndian, uint32(len(mention)))
+		if err != nil {
+			return nil, err
+		}
+
+		err = binary.Write(buffer, binary.LittleEndian, []byte(mention))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return buffer.Bytes(), nil
 }
 
@@ -79,7 +276,7 @@ func Deserialize(buffer []byte) (Response, error) {
 	res := Response{}
 
 	var strBuf []byte
-	var strLength int32
+	var strLength uint32
 	var resType uint32
 
 	err := binary.Read(reader, binary.LittleEndian, &resType)
@@ -94,6 +291,9 @@ func Deserialize(buffer []byte) (Response, error) {
 		return Response{}, err
 	}
 
+	if int64(strLength) > int64(reader.Len()) {
+		return Response{}, io.ErrUnexpectedEOF
+	}
 	strBuf = make([]byte, strLength)
 	err = binary.Read(reader, binary.LittleEndian, strBuf)
 	if err != nil {
@@ -106,6 +306,9 @@ func Deserialize(buffer []byte) (Response, error) {
 		return Response{}, err
 	}
 
+	if int64(strLength) > int64(reader.Len()) {
+		return Response{}, io.ErrUnexpectedEOF
+	}
 	strBuf = make([]byte, strLength)
 	err = binary.Read(reader, binary.LittleEndian, strBuf)
 	if err != nil {
@@ -118,6 +321,9 @@ func Deserialize(buffer []byte) (Response, error) {
 		return Response{}, err
 	}
 
+	if int64(strLength) > int64(reader.Len()) {
+		return Response{}, io.ErrUnexpectedEOF
+	}
 	strBuf = make([]byte, strLength)
 	err = binary.Read(reader, binary.LittleEndian, strBuf)
 	if err != nil {
@@ -125,5 +331,78 @@ func Deserialize(buffer []byte) (Response, error) {
 	}
 	res.Content = string(strBuf)
 
+	var code uint32
+	err = binary.Read(reader, binary.LittleEndian, &code)
+	if err != nil {
+		return Response{}, err
+	}
+	res.Code = ErrorCode(code)
+
+	var timestamp int64
+	err = binary.Read(reader, binary.LittleEndian, &res.ID)
+	if err != nil {
+		return Response{}, err
+	}
+
+	err = binary.Read(reader, binary.LittleEndian, &timestamp)
+	if err != nil {
+		return Response{}, err
+	}
+	if timestamp != 0 {
+		res.Time = time.Unix(0, timestamp).UTC()
+	}
+
+	err = binary.Read(reader, binary.LittleEndian, &res.ParentID)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var count uint32
+	err = binary.Read(reader, binary.LittleEndian, &count)
+	if err != nil {
+		return Response{}, err
+	}
+
+	// Every mention takes at least its length prefix, so a larger count cannot be genuine
+	if int64(count)*4 > int64(reader.Len()) {
+		return Response{}, io.ErrUnexpectedEOF
+	}
+	for i := uint32(0); i < count; i++ {
+		err = binary.Read(reader, binary.LittleEndian, &strLength)
+		if err != nil {
+			return Response{}, err
+		}
+
+		if int64(strLength) > int64(reader.Len()) {
+			return Response{}, io.ErrUnexpectedEOF
+		}
+		strBuf = make([]byte, strLength)
+		err = binary.Read(reader, binary.LittleEndian, strBuf)
+		if err != nil {
+			return Response{}, err
+		}
+		res.Mentions = append(res.Mentions, string(strBuf))
+	}
+
 	return res, nil
 }
+
+// Serializes a response and writes it as a single frame
+func Write(writer *frame.Writer, res Response) error {
+	buf, err := Serialize(res)
+	if err != nil {
+		return err
+	}
+
+	return writer.WriteFrame(buf)
+}
+
+// Reads a single frame and deserializes it into a response
+func Read(reader *frame.Reader) (Response, error) {
+	buf, err := reader.ReadFrame()
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Deserialize(buf)
+}
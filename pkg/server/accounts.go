@@ -0,0 +1,241 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+	"github.com/edobrowo/gochatroom/pkg/validation"
+)
+
+const (
+	// Failed logins allowed for a username, or from an address, before further attempts are refused for the rest of the window
+	MaxFailedLogins   = 5
+	FailedLoginWindow = 5 * time.Minute
+
+	// Usernames and addresses with recent failures that are tracked before expired ones are swept
+	MaxTrackedLoginFailures = 10000
+
+	// Passwords hashed at once; further logins wait for a slot without holding up the event loop
+	MaxConcurrentHashes = 4
+)
+
+// Applies the username policy, then refuses names that imitate one already taken; returns the normalized name
+func (server *Server) ValidateUsername(name string) (string, error) {
+	name, err := server.UsernamePolicy.Validate(name)
+	if err != nil {
+		return "", err
+	}
+
+	other, confusable := server.FindConfusable(name)
+	if confusable {
+		return "", &validation.ValidationError{Reason: validation.Reason_Confusable, Message: fmt.Sprintf("%v is too similar to %v", name, other)}
+	}
+
+	return name, nil
+}
+
+// Finds a name in use by another connection, a held session or an account that looks like the given name without being it
+func (server *Server) FindConfusable(name string) (string, bool) {
+	skeleton := validation.Skeleton(name)
+
+	var names []string
+	for _, client := range server.Connections {
+		names = append(names, client.Username)
+	}
+	for reserved := range server.Reservations {
+		names = append(names, reserved)
+	}
+	if server.Accounts != nil {
+		names = append(names, server.Accounts.Usernames()...)
+	}
+
+	for _, other := range names {
+		if other != "" && other != name && validation.Skeleton(other) == skeleton {
+			return other, true
+		}
+	}
+	return "", false
+}
+
+// Checks the password sent with Status_Register; usernames without an account may be used as guests
+// Hashing is slow, so a password is first checked off the event loop and reported as pending; once it is accepted the request is handled again
+func (server *Server) AuthenticateRegistration(client *ClientConn, req request.Request) (authenticated bool, pending bool, err error) {
+	if server.Accounts == nil || !server.Accounts.Exists(req.SenderName) {
+		return false, false, nil
+	}
+
+	if req.Content == "" {
+		return false, false, &ServerError{Code: response.ErrorCode_AuthenticationFailed, Message: fmt.Sprintf("Username %v is registered; a password is required", req.SenderName)}
+	}
+
+	if client.VerifiedLogin == req.SenderName {
+		client.VerifiedLogin = ""
+		server.ClearFailedLogins(req.SenderName)
+		return true, false, nil
+	}
+
+	wait, locked := server.LoginLockout(req.SenderName, req.ClientAddr)
+	if locked {
+		server.Log.Printf("Refused login after repeated failures (username = %v, address = %v)\n", req.SenderName, req.ClientAddr)
+		return false, false, &ServerError{Code: response.ErrorCode_AuthenticationFailed, Message: fmt.Sprintf("Too many failed logins; try again in %v", wait.Round(time.Second))}
+	}
+
+	// A client that sends several registrations at once only has the first checked
+	if !client.LoginPending {
+		server.VerifyPassword(client, req)
+	}
+	return false, true, nil
+}
+
+// Hashes a login attempt off the event loop, then either refuses the connection or handles its registration again
+func (server *Server) VerifyPassword(client *ClientConn, req request.Request) {
+	client.LoginPending = true
+	go func() {
+		server.hashSlots <- struct{}{}
+		ok := server.Accounts.Authenticate(req.SenderName, req.Content)
+		<-server.hashSlots
+
+		server.Post(func() {
+			client.LoginPending = false
+			if server.FindClient(client.ID) != client || client.Username != "" || server.IsClosing() {
+				return
+			}
+
+			if !ok {
+				server.Log.Printf("Failed login (username = %v, address = %v)\n", req.SenderName, req.ClientAddr)
+				server.RecordFailedLogin(req.SenderName, req.ClientAddr)
+				server.Metrics.RegistrationFailures.With(response.ErrorCode_AuthenticationFailed.String()).Inc()
+				server.SendTo(client.ID, response.Response{ResType: response.ResponseType_TerminateConnection, Code: response.ErrorCode_AuthenticationFailed, Content: "Incorrect password"})
+				return
+			}
+
+			client.VerifiedLogin = req.SenderName
+			server.handleRequest(req)
+		})
+	}()
+}
+
+type loginFailures struct {
+	count int
+	since time.Time
+}
+
+func loginFailureKeys(username string, addr string) []string {
+	return []string{"user:" + username, "addr:" + ClientHost(addr)}
+}
+
+// Reports whether logins for a username or from an address are refused after too many failures, and for how much longer
+func (server *Server) LoginLockout(username string, addr string) (time.Duration, bool) {
+	var wait time.Duration
+	for _, key := range loginFailureKeys(username, addr) {
+		failures, ok := server.failedLogins[key]
+		if !ok || failures.count < MaxFailedLogins {
+			continue
+		}
+
+		remaining := time.Until(failures.since.Add(FailedLoginWindow))
+		if remaining <= 0 {
+			delete(server.failedLogins, key)
+			continue
+		}
+		if remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, wait > 0
+}
+
+// Counts a failed login against both the username and the address; failures are forgotten a window after the first
+func (server *Server) RecordFailedLogin(username string, addr string) {
+	now := time.Now()
+
+	// Expired entries are swept before the map can grow large
+	if len(server.failedLogins) >= MaxTrackedLoginFailures {
+		for key, failures := range server.failedLogins {
+			if now.Sub(failures.since) > FailedLoginWindow {
+				delete(server.failedLogins, key)
+			}
+		}
+	}
+
+	for _, key := range loginFailureKeys(username, addr) {
+		failures, ok := server.failedLogins[key]
+		if !ok || now.Sub(failures.since) > FailedLoginWindow {
+			failures = &loginFailures{since: now}
+			server.failedLogins[key] = failures
+		}
+		failures.count++
+	}
+}
+
+// A successful login clears the failures counted against the username, but not those against the address
+func (server *Server) ClearFailedLogins(username string) {
+	delete(server.failedLogins, "user:"+username)
+}
+
+// Handles /register <password>, which claims the sender's current username
+func (server *Server) HandleRegisterCommand(req request.Request) {
+	res := response.Response{ResType: response.ResponseType_ServerPriv, SenderName: req.SenderName, ReceiverName: req.SenderName}
+
+	client := server.FindClient(req.ConnID)
+	if client == nil || client.Username == "" {
+		return
+	}
+
+	if server.Accounts == nil {
+		server.SendError(req.ConnID, response.ErrorCode_Unavailable, "Accounts are not enabled on this server")
+		return
+	}
+
+	if client.Authenticated {
+		server.SendError(req.ConnID, response.ErrorCode_InvalidArgument, fmt.Sprintf("%v is already registered", client.Username))
+		return
+	}
+
+	if req.Content == "" {
+		server.SendError(req.ConnID, response.ErrorCode_InvalidArgument, "Usage: /register <password>")
+		return
+	}
+
+	// Operator accounts are created ahead of time, so a guest who takes an operator's name cannot claim the role
+	if server.Operators[client.Username] {
+		server.SendError(req.ConnID, response.ErrorCode_Unauthorized, fmt.Sprintf("%v is an operator name; its account must be created by the server administrator", client.Username))
+		return
+	}
+
+	if client.LoginPending {
+		server.SendError(req.ConnID, response.ErrorCode_InvalidArgument, "Your registration is still being processed")
+		return
+	}
+
+	// Hashing the password and saving the account are both slow, so they run off the event loop
+	client.LoginPending = true
+	username := client.Username
+	go func() {
+		server.hashSlots <- struct{}{}
+		err := server.Accounts.Register(username, req.Content)
+		<-server.hashSlots
+
+		server.Post(func() {
+			client.LoginPending = false
+			if server.FindClient(client.ID) != client {
+				return
+			}
+
+			if err != nil {
+				server.SendError(req.ConnID, response.ErrorCode_InvalidArgument, err.Error())
+				return
+			}
+
+			// Messages sent from now on belong to the account
+			client.Authenticated = true
+			client.Principal, _ = NewPrincipal(client.Username, true)
+			server.Log.Printf("Registered account (username = %v, address = %v)\n", client.Username, client.ClientAddr)
+
+			res.Content = fmt.Sprintf("Registered %v; log in with this password from now on", client.Username)
+			server.SendTo(req.ConnID, res)
+		})
+	}()
+}
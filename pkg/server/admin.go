@@ -0,0 +1,325 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+const (
+	// Name recorded as the moderator for actions taken through the admin API
+	AdminName = "admin"
+
+	// Largest request body the admin API reads
+	MaxAdminBodySize = 64 * 1024
+)
+
+type AdminClient struct {
+	ID             ConnID    `json:"id"`
+	Username       string    `json:"username"`
+	Address        string    `json:"address"`
+	Room           string    `json:"room"`
+	ConnectedSince time.Time `json:"connected_since"`
+	Codec          string    `json:"codec"`
+	Authenticated  bool      `json:"authenticated"`
+	Operator       bool      `json:"operator"`
+	Away           bool      `json:"away"`
+	QueueDepth     int       `json:"queue_depth"`
+	QueueCapacity  int       `json:"queue_capacity"`
+	Dropped        int       `json:"dropped"`
+}
+
+type AdminRoom struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+type AdminStatus struct {
+	Status         string    `json:"status"`
+	Error          string    `json:"error,omitempty"`
+	Address        string    `json:"address"`
+	Started        time.Time `json:"started"`
+	Uptime         string    `json:"uptime"`
+	Clients        int       `json:"clients"`
+	Rooms          int       `json:"rooms"`
+	Reservations   int       `json:"reservations"`
+	Mutes          int       `json:"mutes"`
+	ShutdownReason string    `json:"shutdown_reason,omitempty"`
+}
+
+type adminKick struct {
+	Username string `json:"username"`
+	Reason   string `json:"reason"`
+}
+
+// Duration is written like the /ban command's, such as "10m"; empty bans indefinitely
+type adminBan struct {
+	Target   string `json:"target"`
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+type adminAnnouncement struct {
+	Content string `json:"content"`
+}
+
+type adminResult struct {
+	Result string `json:"result"`
+}
+
+type adminError struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// JSON endpoints for inspecting and controlling the server; every request must carry the admin token
+func (server *Server) Admin() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", server.adminMethod(http.MethodGet, server.HandleAdminStatus))
+	mux.HandleFunc("/clients", server.adminMethod(http.MethodGet, server.HandleAdminClients))
+	mux.HandleFunc("/rooms", server.adminMethod(http.MethodGet, server.HandleAdminRooms))
+	mux.HandleFunc("/kick", server.adminMethod(http.MethodPost, server.HandleAdminKick))
+	mux.HandleFunc("/ban", server.adminMethod(http.MethodPost, server.HandleAdminBan))
+	mux.HandleFunc("/announce", server.adminMethod(http.MethodPost, server.HandleAdminAnnounce))
+	return server.RequireAdminToken(mux)
+}
+
+// Rejects requests without "Authorization: Bearer <AdminToken>"
+func (server *Server) RequireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || server.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(server.AdminToken)) != 1 {
+			server.Log.Printf("Refused admin request (address = %v, path = %v)\n", r.RemoteAddr, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gochatroom"`)
+			writeJSON(w, http.StatusUnauthorized, adminError{Error: "Missing or invalid admin token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (server *Server) adminMethod(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSON(w, http.StatusMethodNotAllowed, adminError{Error: "Method not allowed"})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// Reports a failed action with an HTTP status matching its error code
+func writeAdminError(w http.ResponseWriter, err error) {
+	code := CodeOf(err, response.ErrorCode_Internal)
+
+	status := http.StatusInternalServerError
+	switch code {
+	case response.ErrorCode_NoSuchUser:
+		status = http.StatusNotFound
+		break
+	case response.ErrorCode_InvalidArgument:
+		status = http.StatusBadRequest
+		break
+	case response.ErrorCode_ShuttingDown:
+		status = http.StatusServiceUnavailable
+		break
+	}
+
+	writeJSON(w, status, adminError{Error: err.Error(), Code: code.String()})
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxAdminBodySize))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: "Malformed request body: " + err.Error(), Code: response.ErrorCode_InvalidArgument.String()})
+		return false
+	}
+	return true
+}
+
+// Changes made while the server shuts down would never reach anyone
+func (server *Server) refuseWhileClosing(w http.ResponseWriter) bool {
+	if server.IsClosing() {
+		writeAdminError(w, &ServerError{Code: response.ErrorCode_ShuttingDown, Message: "Server is shutting down"})
+		return true
+	}
+	return false
+}
+
+func (server *Server) HandleAdminStatus(w http.ResponseWriter, r *http.Request) {
+	current := server.CurrentStatus()
+	status := AdminStatus{
+		Status:  current.Code.String(),
+		Address: TCPJoinHostPort(server.ServerAddr),
+		Started: server.Started,
+		Uptime:  time.Since(server.Started).Round(time.Second).String(),
+	}
+	if current.Error != nil {
+		status.Error = current.Error.Error()
+	}
+	status.ShutdownReason = server.CloseReason()
+
+	server.Do(func() {
+		status.Clients = len(server.Connections)
+		status.Rooms = len(server.Rooms)
+		status.Reservations = len(server.Reservations)
+		status.Mutes = len(server.Mutes)
+	})
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (server *Server) HandleAdminClients(w http.ResponseWriter, r *http.Request) {
+	clients := []AdminClient{}
+	server.Do(func() {
+		for _, client := range server.Connections {
+			clients = append(clients, AdminClient{
+				ID:             client.ID,
+				Username:       client.Username,
+				Address:        client.ClientAddr,
+				Room:           client.Room,
+				ConnectedSince: client.Connected,
+				Codec:          client.Codec.Name(),
+				Authenticated:  client.Authenticated,
+				Operator:       client.Operator,
+				Away:           client.Away,
+				QueueDepth:     len(client.ResponseQueue),
+				QueueCapacity:  cap(client.ResponseQueue),
+				Dropped:        client.Dropped,
+			})
+		}
+	})
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+
+	writeJSON(w, http.StatusOK, clients)
+}
+
+func (server *Server) HandleAdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms := []AdminRoom{}
+	server.Do(func() {
+		for _, room := range server.Rooms {
+			members := []string{}
+			for id := range room.Members {
+				client, ok := server.Connections[id]
+				if ok {
+					members = append(members, client.Username)
+				}
+			}
+			sort.Strings(members)
+			rooms = append(rooms, AdminRoom{Name: room.Name, Members: members})
+		}
+	})
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+func (server *Server) HandleAdminKick(w http.ResponseWriter, r *http.Request) {
+	var body adminKick
+	if !readJSON(w, r, &body) || server.refuseWhileClosing(w) {
+		return
+	}
+
+	var result string
+	var err error
+	server.Do(func() {
+		result, err = server.Kick(AdminName, body.Username, body.Reason)
+	})
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, adminResult{Result: result})
+}
+
+func (server *Server) HandleAdminBan(w http.ResponseWriter, r *http.Request) {
+	var body adminBan
+	if !readJSON(w, r, &body) || server.refuseWhileClosing(w) {
+		return
+	}
+	if body.Target == "" {
+		writeAdminError(w, &ServerError{Code: response.ErrorCode_InvalidArgument, Message: "A username or address to ban is required"})
+		return
+	}
+
+	var duration time.Duration
+	if body.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(body.Duration)
+		if err != nil || duration < 0 {
+			writeAdminError(w, &ServerError{Code: response.ErrorCode_InvalidArgument, Message: "Invalid duration " + body.Duration})
+			return
+		}
+	}
+
+	var result string
+	var err error
+	server.Do(func() {
+		result, err = server.Ban(AdminName, body.Target, duration, body.Reason)
+	})
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, adminResult{Result: result})
+}
+
+func (server *Server) HandleAdminAnnounce(w http.ResponseWriter, r *http.Request) {
+	var body adminAnnouncement
+	if !readJSON(w, r, &body) || server.refuseWhileClosing(w) {
+		return
+	}
+	if strings.TrimSpace(body.Content) == "" {
+		writeAdminError(w, &ServerError{Code: response.ErrorCode_InvalidArgument, Message: "An announcement cannot be empty"})
+		return
+	}
+
+	recipients := 0
+	server.Do(func() {
+		announcement := response.Response{ResType: response.ResponseType_ServerAll, Content: body.Content}
+		server.Stamp(&announcement)
+		for _, client := range server.Connections {
+			if client.Username != "" {
+				server.Deliver(client, announcement)
+				recipients++
+			}
+		}
+	})
+	server.Log.Printf("Announcement sent (recipients = %v): %v\n", recipients, body.Content)
+
+	writeJSON(w, http.StatusOK, adminResult{Result: fmt.Sprintf("Announced to %v users", recipients)})
+}
+
+// Listens for admin requests on AdminAddr
+func (server *Server) StartAdmin() error {
+	if server.AdminToken == "" {
+		return &ServerError{Message: "The admin API requires a token"}
+	}
+
+	listener, err := server.listenHTTP(server.AdminAddr, server.Admin(), "Admin API")
+	if err != nil {
+		return err
+	}
+	server.AdminListener = listener
+	return nil
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/edobrowo/gochatroom/pkg/ban"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+const testAdminToken = "secret"
+
+// Serves the admin API of a running server, returning the API's URL
+func startTestAdmin(t *testing.T, server *Server) string {
+	t.Helper()
+
+	startTestServer(t, server)
+	api := httptest.NewServer(server.Admin())
+	t.Cleanup(api.Close)
+	return api.URL
+}
+
+// Sends an admin request with the given token, or none if it is empty, and decodes the JSON reply into v
+func adminRequest(t *testing.T, method string, url string, token string, body string, v any) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if v != nil {
+		err = json.NewDecoder(res.Body).Decode(v)
+		if err != nil {
+			t.Fatalf("%v %v: could not decode reply: %v", method, url, err)
+		}
+	}
+	return res
+}
+
+func TestAdminToken(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0), AdminToken: testAdminToken}
+	url := startTestAdmin(t, server)
+
+	tests := []struct {
+		token  string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{testAdminToken + "x", http.StatusUnauthorized},
+		{testAdminToken, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		res := adminRequest(t, http.MethodGet, url+"/status", test.token, "", nil)
+		if res.StatusCode != test.status {
+			t.Errorf("Token %q: status %v, expected %v", test.token, res.StatusCode, test.status)
+		}
+		if res.StatusCode == http.StatusUnauthorized && res.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("Token %q: refusal has no WWW-Authenticate header", test.token)
+		}
+	}
+
+	// Without a configured token nothing is accepted, not even an empty bearer token
+	open := &Server{Log: log.New(io.Discard, "", 0)}
+	openURL := startTestAdmin(t, open)
+
+	req, err := http.NewRequest(http.MethodGet, openURL+"/status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer ")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Empty admin token accepted a request with status %v", res.StatusCode)
+	}
+}
+
+func TestAdminMethods(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0), AdminToken: testAdminToken}
+	url := startTestAdmin(t, server)
+
+	tests := []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{http.MethodPost, "/status", http.MethodGet},
+		{http.MethodDelete, "/clients", http.MethodGet},
+		{http.MethodGet, "/kick", http.MethodPost},
+		{http.MethodGet, "/ban", http.MethodPost},
+		{http.MethodPut, "/announce", http.MethodPost},
+	}
+
+	for _, test := range tests {
+		res := adminRequest(t, test.method, url+test.path, testAdminToken, "", nil)
+		if res.StatusCode != http.StatusMethodNotAllowed || res.Header.Get("Allow") != test.allow {
+			t.Errorf("%v %v: status %v, Allow %q", test.method, test.path, res.StatusCode, res.Header.Get("Allow"))
+		}
+	}
+}
+
+func TestAdminKick(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0), AdminToken: testAdminToken}
+	url := startTestAdmin(t, server)
+
+	bob, err := dialTestClient(server.Listener.Addr().String(), "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.conn.Close()
+	bob.register(t)
+	bob.await(t, isRoomNotice("bob has connected"))
+
+	var failure adminError
+	res := adminRequest(t, http.MethodPost, url+"/kick", testAdminToken, `{"username": "bob", "extra": 1}`, &failure)
+	if res.StatusCode != http.StatusBadRequest || failure.Code != response.ErrorCode_InvalidArgument.String() {
+		t.Errorf("Unknown field: status %v, %+v", res.StatusCode, failure)
+	}
+
+	res = adminRequest(t, http.MethodPost, url+"/kick", testAdminToken, `{"username": "carol"}`, &failure)
+	if res.StatusCode != http.StatusNotFound || failure.Code != response.ErrorCode_NoSuchUser.String() {
+		t.Errorf("Unknown user: status %v, %+v", res.StatusCode, failure)
+	}
+
+	var result adminResult
+	res = adminRequest(t, http.MethodPost, url+"/kick", testAdminToken, `{"username": "bob", "reason": "testing"}`, &result)
+	if res.StatusCode != http.StatusOK || result.Result != "Kicked bob" {
+		t.Errorf("Kick: status %v, %+v", res.StatusCode, result)
+	}
+	bob.await(t, func(res response.Response) bool {
+		return isTermination(response.ErrorCode_Kicked)(res) && res.Content == "You were kicked by admin: testing"
+	})
+}
+
+func TestAdminBan(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0), AdminToken: testAdminToken}
+	url := startTestAdmin(t, server)
+
+	bob, err := dialTestClient(server.Listener.Addr().String(), "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.conn.Close()
+	bob.register(t)
+	bob.await(t, isRoomNotice("bob has connected"))
+
+	for _, body := range []string{`{"duration": "1h"}`, `{"target": "bob", "duration": "soon"}`, `{"target": "bob", "duration": "-1h"}`} {
+		var failure adminError
+		res := adminRequest(t, http.MethodPost, url+"/ban", testAdminToken, body, &failure)
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("Ban %v: status %v, %+v", body, res.StatusCode, failure)
+		}
+	}
+
+	var result adminResult
+	res := adminRequest(t, http.MethodPost, url+"/ban", testAdminToken, `{"target": "bob", "duration": "10m", "reason": "testing"}`, &result)
+	if res.StatusCode != http.StatusOK || result.Result != "Banned bob for 10m0s" {
+		t.Errorf("Ban: status %v, %+v", res.StatusCode, result)
+	}
+	bob.await(t, isTermination(response.ErrorCode_Banned))
+
+	entry, ok := server.Bans.Find(ban.Kind_User, "bob")
+	if !ok || entry.By != AdminName || entry.Reason != "testing" {
+		t.Errorf("Ban recorded as %+v", entry)
+	}
+}
+
+func TestAdminAnnounce(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0), AdminToken: testAdminToken}
+	url := startTestAdmin(t, server)
+
+	bob, err := dialTestClient(server.Listener.Addr().String(), "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.conn.Close()
+	bob.register(t)
+	bob.await(t, isRoomNotice("bob has connected"))
+
+	// Connections that have not registered are not counted
+	dialSilent(t, server.Listener.Addr().String())
+
+	var failure adminError
+	res := adminRequest(t, http.MethodPost, url+"/announce", testAdminToken, `{"content": "  "}`, &failure)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Empty announcement: status %v, %+v", res.StatusCode, failure)
+	}
+
+	var result adminResult
+	res = adminRequest(t, http.MethodPost, url+"/announce", testAdminToken, `{"content": "Restarting soon"}`, &result)
+	if res.StatusCode != http.StatusOK || result.Result != "Announced to 1 users" {
+		t.Errorf("Announce: status %v, %+v", res.StatusCode, result)
+	}
+	bob.await(t, func(res response.Response) bool {
+		return res.ResType == response.ResponseType_ServerAll && res.Content == "Restarting soon"
+	})
+}
+
+// The shutdown reason is reported from the HTTP goroutine while the server closes; meant to be run with -race
+func TestAdminStatusShutdown(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0), AdminToken: testAdminToken}
+	url := startTestAdmin(t, server)
+
+	var status AdminStatus
+	adminRequest(t, http.MethodGet, url+"/status", testAdminToken, "", &status)
+	if status.Status != Listening.String() || status.ShutdownReason != "" {
+		t.Errorf("Status before shutdown is %+v", status)
+	}
+
+	server.Shutdown("maintenance")
+
+	adminRequest(t, http.MethodGet, url+"/status", testAdminToken, "", &status)
+	if status.ShutdownReason != "maintenance" {
+		t.Errorf("Status while closing is %+v", status)
+	}
+
+	var failure adminError
+	res := adminRequest(t, http.MethodPost, url+"/announce", testAdminToken, `{"content": "hello"}`, &failure)
+	if res.StatusCode != http.StatusServiceUnavailable || failure.Code != response.ErrorCode_ShuttingDown.String() {
+		t.Errorf("Announce while closing: status %v, %+v", res.StatusCode, failure)
+	}
+	if server.CloseReason() != "maintenance" {
+		t.Errorf("Close reason is %q", server.CloseReason())
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+func editUsage(cmd request.CommandType) string {
+	if cmd == request.Command_Edit {
+		return "Usage: /edit <id> <text>"
+	}
+	return "Usage: /delete <id>"
+}
+
+// Handles /edit <id> <text> and /delete <id>, which rewrite a message in history and tell the room to replace or remove it
+// Only the author of a message or an operator may change it; authors are known by principal, since guest names can be reused
+func (server *Server) HandleEditCommand(req request.Request) {
+	client := server.FindClient(req.ConnID)
+	if client == nil || client.Username == "" {
+		return
+	}
+
+	id := req.MessageID
+	if id == 0 {
+		server.SendError(req.ConnID, response.ErrorCode_InvalidArgument, editUsage(req.CmdType))
+		return
+	}
+
+	entry, ok := server.History.Find(id)
+	if !ok {
+		server.SendError(req.ConnID, response.ErrorCode_NoSuchMessage, fmt.Sprintf("Message %v does not exist", id))
+		return
+	}
+
+	author := entry.Response.SenderName
+	owned := entry.Owner == client.Principal
+	if !owned && !client.Operator {
+		server.SendError(req.ConnID, response.ErrorCode_Unauthorized, "You can only change your own messages")
+		return
+	}
+
+	res := response.Response{SenderName: author, ReceiverName: client.Username, ID: id, Time: time.Now().UTC()}
+	switch req.CmdType {
+	case request.Command_Edit:
+		if req.Content == "" {
+			server.SendError(req.ConnID, response.ErrorCode_InvalidArgument, editUsage(req.CmdType))
+			return
+		}
+		// An edit says something new, so it is held to the same rules as a message
+		if server.RefuseMuted(client) {
+			return
+		}
+		if server.MaxMessageLength > 0 && len(req.Content) > server.MaxMessageLength {
+			server.SendError(req.ConnID, response.ErrorCode_MessageTooLong, fmt.Sprintf("Message is too long (maximum %v bytes)", server.MaxMessageLength))
+			return
+		}
+		res.ResType = response.ResponseType_Edit
+		res.Content = req.Content
+		res.Mentions = ParseMentions(req.Content, server.UsernamePolicy)
+		break
+	case request.Command_Delete:
+		res.ResType = response.ResponseType_Delete
+		break
+	}
+
+	entry, err := server.History.Amend(res)
+	if err != nil {
+		server.Log.Println("Could not amend history: ", err)
+		server.SendError(req.ConnID, response.ErrorCode_Internal, "The message could not be changed")
+		return
+	}
+
+	if res.ResType == response.ResponseType_Delete {
+		delete(server.Reactions, id)
+	}
+
+	server.Log.Printf("Amended message (id = %v, change = %v, author = %v, by = %v, room = %v)\n", id, res.ResType, author, client.Username, entry.Room)
+	server.BroadcastRoom(entry.Room, res)
+
+	// Operators may change messages in rooms they are not in
+	if client.Room != entry.Room {
+		server.SendTo(req.ConnID, res)
+	}
+}
+
+// Rewrites an edit or deletion as a room notice, for clients without Feature_Edits
+func DescribeAmendment(res response.Response) response.Response {
+	notice := response.Response{ResType: response.ResponseType_ServerRoom, Time: res.Time}
+	if res.ResType == response.ResponseType_Edit {
+		notice.Content = fmt.Sprintf("%v edited message %v: %v", res.SenderName, res.ID, res.Content)
+	} else {
+		notice.Content = fmt.Sprintf("A message from %v (%v) was deleted", res.SenderName, res.ID)
+	}
+	return notice
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/edobrowo/gochatroom/pkg/account"
+	"github.com/edobrowo/gochatroom/pkg/protocol"
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+// A guest who takes a name after its previous user left must not be able to change that user's messages
+func TestEditAfterNameReused(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0)}
+	addr := startTestServer(t, server)
+
+	// Sessions are left out so the name is free as soon as the first guest leaves
+	features := protocol.Supported &^ protocol.Feature_Sessions
+
+	first, err := dialTestClientWith(addr, "alice", features)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.register(t)
+	first.send(t, request.Request{ReqType: request.RequestType_Message, Content: "original"})
+
+	var id uint64
+	first.await(t, func(res response.Response) bool {
+		id = res.ID
+		return res.ResType == response.ResponseType_Message && res.Content == "original"
+	})
+
+	// The author may still change it
+	first.send(t, request.Parse("/edit "+formatID(id)+" changed"))
+	first.await(t, func(res response.Response) bool {
+		return res.ResType == response.ResponseType_Edit && res.ID == id
+	})
+	first.send(t, request.Parse("/react "+formatID(id)+" :+1:"))
+	first.await(t, func(res response.Response) bool {
+		return res.ResType == response.ResponseType_Reactions && res.ID == id
+	})
+	first.conn.Close()
+
+	if !waitForServer(t, server, func() bool { return len(server.Connections) == 0 }) {
+		t.Fatal("First guest was not removed")
+	}
+
+	second, err := dialTestClientWith(addr, "alice", features)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.conn.Close()
+	second.register(t)
+
+	second.send(t, request.Parse("/delete "+formatID(id)))
+	second.await(t, func(res response.Response) bool {
+		if res.ResType == response.ResponseType_Delete {
+			t.Error("A reused guest name deleted the previous guest's message")
+			return true
+		}
+		return res.ResType == response.ResponseType_Error && res.Code == response.ErrorCode_Unauthorized
+	})
+
+	// Reactions are also kept apart, so the new guest has not reacted yet
+	second.send(t, request.Parse("/unreact "+formatID(id)+" :+1:"))
+	second.await(t, func(res response.Response) bool {
+		return res.ResType == response.ResponseType_Error && res.Code == response.ErrorCode_InvalidArgument
+	})
+}
+
+// A guest who registers their name owns what they send afterwards as the account, so they can still change it after logging in again
+func TestEditAfterRegister(t *testing.T) {
+	accounts, err := account.OpenStore(filepath.Join(t.TempDir(), "accounts.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{Log: log.New(io.Discard, "", 0), Accounts: accounts}
+	addr := startTestServer(t, server)
+
+	// Sessions are left out so the name is free as soon as the first connection closes
+	features := protocol.Supported &^ protocol.Feature_Sessions
+
+	first, err := dialTestClientWith(addr, "alice", features)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.register(t)
+	first.send(t, request.Parse("/register hunter22"))
+	first.await(t, func(res response.Response) bool {
+		return res.ResType == response.ResponseType_ServerPriv && strings.HasPrefix(res.Content, "Registered alice")
+	})
+
+	if !waitForServer(t, server, func() bool {
+		for _, client := range server.Connections {
+			if client.Username == "alice" {
+				return client.Principal == "account:alice"
+			}
+		}
+		return false
+	}) {
+		t.Error("Registering did not make alice an account principal")
+	}
+
+	first.send(t, request.Request{ReqType: request.RequestType_Message, Content: "original"})
+	var id uint64
+	first.await(t, func(res response.Response) bool {
+		id = res.ID
+		return res.ResType == response.ResponseType_Message && res.Content == "original"
+	})
+	first.conn.Close()
+
+	if !waitForServer(t, server, func() bool { return len(server.Connections) == 0 }) {
+		t.Fatal("First connection was not removed")
+	}
+
+	second, err := dialTestClientWith(addr, "alice", features)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.conn.Close()
+	second.send(t, request.Request{ReqType: request.RequestType_Status, StType: request.Status_Register, Content: "hunter22"})
+	second.await(t, isRoomNotice("alice has connected"))
+
+	second.send(t, request.Parse("/edit "+formatID(id)+" changed"))
+	second.await(t, func(res response.Response) bool {
+		if res.ResType == response.ResponseType_Error {
+			t.Errorf("Could not edit a message sent after registering: %v", res.Content)
+			return true
+		}
+		return res.ResType == response.ResponseType_Edit && res.ID == id
+	})
+}
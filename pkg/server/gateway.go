@@ -0,0 +1,113 @@
+package server
+
+import (
+	"crypto/tls"
+	_ "embed"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/edobrowo/gochatroom/pkg/response"
+	"github.com/edobrowo/gochatroom/pkg/websocket"
+)
+
+//go:embed web/index.html
+var indexPage []byte
+
+// Serves the chat page, and upgrades /ws to a WebSocket that joins the chat like any TCP client
+func (server *Server) Gateway() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(indexPage)
+	})
+	mux.HandleFunc("/ws", server.HandleWebSocket)
+	return mux
+}
+
+// Browsers attach an Origin to every WebSocket request; pages served from other sites may only connect if listed
+func (server *Server) AllowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	parsed, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range server.GatewayOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (server *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !server.AllowedOrigin(r) {
+		server.Log.Printf("Refused WebSocket from another origin (address = %v, origin = %v)\n", r.RemoteAddr, r.Header.Get("Origin"))
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	// Browsers are told why they were turned away by the HTTP status, before the connection is upgraded
+	err := server.Admit(r.RemoteAddr)
+	if err != nil {
+		refusal := err.(*ServerError)
+		server.Metrics.RejectedConnections.With(refusal.Code.String()).Inc()
+		status := http.StatusForbidden
+		if refusal.Code == response.ErrorCode_TooManyConnections {
+			status = http.StatusTooManyRequests
+		}
+		http.Error(w, refusal.Message, status)
+		return
+	}
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		server.Forget(r.RemoteAddr)
+		server.Log.Printf("WebSocket upgrade failed (address = %v): %v\n", r.RemoteAddr, err)
+		return
+	}
+
+	// From here on the connection is handled exactly like one accepted over TCP
+	server.Greet(server.Track(conn, r.RemoteAddr))
+}
+
+// Listens for browsers on GatewayAddr
+func (server *Server) StartGateway() error {
+	listener, err := server.listenHTTP(server.GatewayAddr, server.Gateway(), "WebSocket gateway")
+	if err != nil {
+		return err
+	}
+	server.GatewayListener = listener
+	return nil
+}
+
+// Serves HTTP on its own goroutine, using TLS when the chat listener does; the caller closes the listener to stop it
+func (server *Server) listenHTTP(addr string, handler http.Handler, name string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if server.TLSConfig != nil {
+		listener = tls.NewListener(listener, server.TLSConfig)
+	}
+
+	httpServer := &http.Server{Handler: handler, ReadHeaderTimeout: HandshakeTimeout, ErrorLog: server.Log}
+	go func() {
+		err := httpServer.Serve(listener)
+		if err != nil && !server.IsClosing() {
+			server.Log.Printf("%v failure: %v\n", name, err)
+		}
+	}()
+
+	server.Log.Printf("%v listening on %v\n", name, addr)
+	return listener, nil
+}
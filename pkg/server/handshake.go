@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/codec"
+	"github.com/edobrowo/gochatroom/pkg/protocol"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+const (
+	// How long a new connection has to send its hello before it is dropped
+	HandshakeTimeout = 10 * time.Second
+)
+
+// Exchanges hellos with a new connection, then hands it to the event loop
+// Runs on its own goroutine, so a slow or silent peer only holds up itself
+func (server *Server) Greet(conn net.Conn) {
+	conn = &countingConn{Conn: conn, metrics: server.Metrics}
+
+	client := &ClientConn{
+		Connection: conn,
+		ClientAddr: conn.RemoteAddr().String(),
+		Connected:  time.Now(),
+	}
+
+	// The hello also tells the server which codec the connection speaks
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	var err error
+	client.Codec, err = codec.Detect(conn, conn)
+	if err != nil {
+		server.Log.Printf("Handshake failed (address = %v): %v\n", client.ClientAddr, err)
+		conn.Close()
+		return
+	}
+
+	// Older clients open with a request instead of a hello; they still understand the termination notice
+	hello, err := client.Codec.ReadHello()
+	if err == nil {
+		hello, err = protocol.Negotiate(protocol.NewHello(server.Features), hello)
+	}
+	if _, refused := err.(*protocol.ProtocolError); refused {
+		server.Log.Printf("Refused client (address = %v): %v\n", client.ClientAddr, err)
+		server.RejectClient(client, response.ErrorCode_IncompatibleProtocol, err.Error())
+		return
+	}
+	if err == nil {
+		err = client.Codec.WriteHello(hello)
+	}
+	if err != nil {
+		server.Log.Printf("Handshake failed (address = %v): %v\n", client.ClientAddr, err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	client.Protocol = hello
+	server.Log.Printf("Handshake complete (address = %v, codec = %v, version = %v, features = %v)\n", client.ClientAddr, client.Codec.Name(), hello.Version, hello.Features)
+	server.Accepted <- client
+}
+
+// Sends a termination notice to a connection turned away before its hello, then closes it
+// The notice is written in the codec of the peer's first bytes, which it is given little time to send
+func (server *Server) Refuse(conn net.Conn, err *ServerError) {
+	server.Metrics.RejectedConnections.With(err.Code.String()).Inc()
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(RefuseTimeout))
+	c, detectErr := codec.Detect(conn, conn)
+	if detectErr != nil {
+		return
+	}
+	c.WriteResponse(response.Response{ResType: response.ResponseType_TerminateConnection, Code: err.Code, Content: err.Message})
+}
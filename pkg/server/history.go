@@ -0,0 +1,592 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/frame"
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+const (
+	// Number of messages kept per room by the in-memory store
+	DefaultHistoryCapacity = 500
+
+	// Number of messages replayed to a newly registered user
+	DefaultHistoryReplay = 20
+
+	// Upper bound on a single /history request
+	MaxHistoryRequest = 100
+)
+
+type HistoryEntry struct {
+	// Monotonic across all rooms, assigned by the store
+	Seq      uint64
+	Room     string
+	Time     time.Time
+	Response response.Response
+
+	// Principal of the client that sent the message
+	Owner string
+
+	// Set by amendments; deleted entries keep their place but are never replayed
+	Edited  bool
+	Deleted bool
+}
+
+// Backend for message history
+type HistoryStore interface {
+	// Records a message sent to a room by the given principal, assigning it the next sequence number
+	Append(room string, owner string, res response.Response) (HistoryEntry, error)
+
+	// Returns up to n of the most recent entries in a room, oldest first
+	Recent(room string, n int) ([]HistoryEntry, error)
+
+	// Returns the retained entries in a room with a sequence number greater than seq, oldest first
+	Since(room string, seq uint64) ([]HistoryEntry, error)
+
+	// Sequence number of the most recently appended entry in any room
+	LastSeq() uint64
+
+	// Highest message ID recorded, so IDs keep increasing across restarts
+	LastMessageID() uint64
+
+	// Finds a retained message by its ID, in any room
+	Find(id uint64) (HistoryEntry, bool)
+
+	// Applies a ResponseType_Edit or ResponseType_Delete to the retained message it names, returning the amended entry
+	Amend(res response.Response) (HistoryEntry, error)
+
+	// The retained messages of the thread containing a message: the first message it replies to, directly or not, and every reply beneath that, oldest first
+	Thread(id uint64) ([]HistoryEntry, error)
+
+	// Flushes and releases any resources held by the store
+	Close() error
+}
+
+// Fixed-size buffer that overwrites its oldest entry once full
+type historyRing struct {
+	entries []HistoryEntry
+	start   int
+	count   int
+}
+
+func (ring *historyRing) push(entry HistoryEntry) {
+	if ring.count < len(ring.entries) {
+		ring.entries[(ring.start+ring.count)%len(ring.entries)] = entry
+		ring.count++
+		return
+	}
+
+	ring.entries[ring.start] = entry
+	ring.start = (ring.start + 1) % len(ring.entries)
+}
+
+func (ring *historyRing) since(seq uint64) []HistoryEntry {
+	result := make([]HistoryEntry, 0)
+	for i := 0; i < ring.count; i++ {
+		entry := ring.entries[(ring.start+i)%len(ring.entries)]
+		if entry.Seq > seq && !entry.Deleted {
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
+
+// The n most recent entries that have not been deleted, oldest first
+func (ring *historyRing) last(n int) []HistoryEntry {
+	result := make([]HistoryEntry, 0, n)
+	for i := ring.count - 1; i >= 0 && len(result) < n; i-- {
+		entry := ring.entries[(ring.start+i)%len(ring.entries)]
+		if !entry.Deleted {
+			result = append(result, entry)
+		}
+	}
+
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+// The given message and every retained reply beneath it, oldest first; replies to deleted messages are still included
+func (ring *historyRing) thread(root uint64) []HistoryEntry {
+	members := map[uint64]bool{root: true}
+	result := make([]HistoryEntry, 0)
+	for i := 0; i < ring.count; i++ {
+		entry := ring.entries[(ring.start+i)%len(ring.entries)]
+		// Replies always come after their parent, so one pass finds every generation
+		if entry.Response.ID != root && !members[entry.Response.ParentID] {
+			continue
+		}
+		members[entry.Response.ID] = true
+		if !entry.Deleted {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+func (ring *historyRing) find(id uint64) *HistoryEntry {
+	for i := 0; i < ring.count; i++ {
+		entry := &ring.entries[(ring.start+i)%len(ring.entries)]
+		if entry.Response.ID == id {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Keeps the most recent messages of each room in memory; history is lost on restart
+type MemoryHistory struct {
+	Capacity int
+	rooms    map[string]*historyRing
+	seq      uint64
+	lastID   uint64
+	mutex    sync.Mutex
+}
+
+func NewMemoryHistory(capacity int) *MemoryHistory {
+	if capacity <= 0 {
+		capacity = DefaultHistoryCapacity
+	}
+	return &MemoryHistory{Capacity: capacity, rooms: make(map[string]*historyRing)}
+}
+
+func (history *MemoryHistory) Append(room string, owner string, res response.Response) (HistoryEntry, error) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	history.seq++
+	entry := HistoryEntry{Seq: history.seq, Room: room, Time: time.Now().UTC(), Response: res, Owner: owner}
+	history.insert(entry)
+
+	return entry, nil
+}
+
+// Adds an entry that already has a sequence number, used when loading from disk
+func (history *MemoryHistory) insert(entry HistoryEntry) {
+	ring, ok := history.rooms[entry.Room]
+	if !ok {
+		ring = &historyRing{entries: make([]HistoryEntry, history.Capacity)}
+		history.rooms[entry.Room] = ring
+	}
+	ring.push(entry)
+
+	if entry.Seq > history.seq {
+		history.seq = entry.Seq
+	}
+	if entry.Response.ID > history.lastID {
+		history.lastID = entry.Response.ID
+	}
+}
+
+func (history *MemoryHistory) Recent(room string, n int) ([]HistoryEntry, error) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	ring, ok := history.rooms[room]
+	if !ok || n <= 0 {
+		return []HistoryEntry{}, nil
+	}
+
+	return ring.last(n), nil
+}
+
+func (history *MemoryHistory) Since(room string, seq uint64) ([]HistoryEntry, error) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	ring, ok := history.rooms[room]
+	if !ok {
+		return []HistoryEntry{}, nil
+	}
+
+	return ring.since(seq), nil
+}
+
+func (history *MemoryHistory) LastSeq() uint64 {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	return history.seq
+}
+
+func (history *MemoryHistory) find(id uint64) *HistoryEntry {
+	if id == 0 {
+		return nil
+	}
+	for _, ring := range history.rooms {
+		entry := ring.find(id)
+		if entry != nil {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (history *MemoryHistory) Find(id uint64) (HistoryEntry, bool) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	entry := history.find(id)
+	if entry == nil || entry.Deleted {
+		return HistoryEntry{}, false
+	}
+	return *entry, true
+}
+
+func (history *MemoryHistory) Amend(res response.Response) (HistoryEntry, error) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	entry := history.find(res.ID)
+	if entry == nil || entry.Deleted {
+		return HistoryEntry{}, &ServerError{Code: response.ErrorCode_NoSuchMessage, Message: fmt.Sprintf("Message %v does not exist", res.ID)}
+	}
+
+	switch res.ResType {
+	case response.ResponseType_Edit:
+		entry.Response.Content = res.Content
+		entry.Edited = true
+		break
+	case response.ResponseType_Delete:
+		// Deleted text is dropped rather than hidden
+		entry.Response.Content = ""
+		entry.Deleted = true
+		break
+	default:
+		return HistoryEntry{}, &ServerError{Code: response.ErrorCode_Internal, Message: fmt.Sprintf("Cannot amend history with a %v response", res.ResType)}
+	}
+
+	return *entry, nil
+}
+
+func (history *MemoryHistory) Thread(id uint64) ([]HistoryEntry, error) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	entry := history.find(id)
+	if entry == nil || entry.Deleted {
+		return nil, &ServerError{Code: response.ErrorCode_NoSuchMessage, Message: fmt.Sprintf("Message %v does not exist", id)}
+	}
+
+	// Walk up to the first message of the thread that is still retained; parents always have smaller IDs
+	ring := history.rooms[entry.Room]
+	for entry.Response.ParentID != 0 && entry.Response.ParentID < entry.Response.ID {
+		parent := ring.find(entry.Response.ParentID)
+		if parent == nil {
+			break
+		}
+		entry = parent
+	}
+
+	return ring.thread(entry.Response.ID), nil
+}
+
+func (history *MemoryHistory) LastMessageID() uint64 {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	return history.lastID
+}
+
+func (history *MemoryHistory) Close() error {
+	return nil
+}
+
+// Appends every message to a log file, serving reads from an in-memory cache rebuilt on open
+type FileHistory struct {
+	Path   string
+	file   *os.File
+	writer *frame.Writer
+	cache  *MemoryHistory
+	mutex  sync.Mutex
+}
+
+func OpenFileHistory(path string, capacity int) (*FileHistory, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	history := &FileHistory{Path: path, file: file, writer: frame.NewWriter(file), cache: NewMemoryHistory(capacity)}
+
+	// Replay the log into the cache; a truncated final record is left over from a crash and cut off
+	reader := frame.NewReader(file)
+	var offset int64
+	for {
+		buf, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			err = file.Truncate(offset)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		entry, err := DeserializeHistoryEntry(buf)
+		if err != nil {
+			file.Close()
+			return nil, &ServerError{Message: fmt.Sprintf("Corrupt history log %v: %v", path, err)}
+		}
+
+		// Amendments are applied to the message they name, which may since have been dropped from the cache
+		if IsAmendment(entry.Response) {
+			history.cache.Amend(entry.Response)
+		} else {
+			history.cache.insert(entry)
+		}
+		offset += int64(frame.HeaderSize + len(buf))
+	}
+
+	return history, nil
+}
+
+func (history *FileHistory) Append(room string, owner string, res response.Response) (HistoryEntry, error) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	entry, err := history.cache.Append(room, owner, res)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	buf, err := SerializeHistoryEntry(entry)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	err = history.writer.WriteFrame(buf)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	return entry, nil
+}
+
+func (history *FileHistory) Recent(room string, n int) ([]HistoryEntry, error) {
+	return history.cache.Recent(room, n)
+}
+
+func (history *FileHistory) Since(room string, seq uint64) ([]HistoryEntry, error) {
+	return history.cache.Since(room, seq)
+}
+
+func (history *FileHistory) LastSeq() uint64 {
+	return history.cache.LastSeq()
+}
+
+func (history *FileHistory) Find(id uint64) (HistoryEntry, bool) {
+	return history.cache.Find(id)
+}
+
+// Amendments are appended to the log like messages, and replayed over the messages they name when the log is opened
+func (history *FileHistory) Amend(res response.Response) (HistoryEntry, error) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	entry, err := history.cache.Amend(res)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	buf, err := SerializeHistoryEntry(HistoryEntry{Room: entry.Room, Time: time.Now().UTC(), Response: res})
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	err = history.writer.WriteFrame(buf)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	return entry, nil
+}
+
+func (history *FileHistory) Thread(id uint64) ([]HistoryEntry, error) {
+	return history.cache.Thread(id)
+}
+
+func (history *FileHistory) LastMessageID() uint64 {
+	return history.cache.LastMessageID()
+}
+
+func (history *FileHistory) Close() error {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	err := history.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	return history.file.Close()
+}
+
+func IsAmendment(res response.Response) bool {
+	return res.ResType == response.ResponseType_Edit || res.ResType == response.ResponseType_Delete
+}
+
+// History entries are stored as the sequence number, timestamp, room name, owner, then the serialized response
+func SerializeHistoryEntry(entry HistoryEntry) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	err := binary.Write(buffer, binary.LittleEndian, entry.Seq)
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, entry.Time.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, uint32(len(entry.Room)))
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, []byte(entry.Room))
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, uint32(len(entry.Owner)))
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, []byte(entry.Owner))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := response.Serialize(entry.Response)
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buffer, binary.LittleEndian, res)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func DeserializeHistoryEntry(buffer []byte) (HistoryEntry, error) {
+	reader := bytes.NewReader(buffer)
+	entry := HistoryEntry{}
+
+	var timestamp int64
+	var strLength uint32
+
+	err := binary.Read(reader, binary.LittleEndian, &entry.Seq)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	err = binary.Read(reader, binary.LittleEndian, &timestamp)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	entry.Time = time.Unix(0, timestamp).UTC()
+
+	err = binary.Read(reader, binary.LittleEndian, &strLength)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	if int64(strLength) > int64(reader.Len()) {
+		return HistoryEntry{}, io.ErrUnexpectedEOF
+	}
+	strBuf := make([]byte, strLength)
+	err = binary.Read(reader, binary.LittleEndian, strBuf)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	entry.Room = string(strBuf)
+
+	err = binary.Read(reader, binary.LittleEndian, &strLength)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	if int64(strLength) > int64(reader.Len()) {
+		return HistoryEntry{}, io.ErrUnexpectedEOF
+	}
+	strBuf = make([]byte, strLength)
+	err = binary.Read(reader, binary.LittleEndian, strBuf)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	entry.Owner = string(strBuf)
+
+	entry.Response, err = response.Deserialize(buffer[len(buffer)-reader.Len():])
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	return entry, nil
+}
+
+// Sends up to n recent messages from a room to a single client, preceded by a header line
+func (server *Server) ReplayHistory(id ConnID, room string, n int) {
+	entries, err := server.History.Recent(room, n)
+	if err != nil {
+		server.Log.Println("Could not read history: ", err)
+		server.SendError(id, response.ErrorCode_Internal, "History is unavailable")
+		return
+	}
+
+	if len(entries) == 0 {
+		server.SendTo(id, response.Response{ResType: response.ResponseType_ServerPriv, Content: fmt.Sprintf("No messages in #%v yet", room)})
+		return
+	}
+
+	server.SendTo(id, response.Response{ResType: response.ResponseType_ServerPriv, Content: fmt.Sprintf("Last %v messages in #%v:", len(entries), room)})
+	for _, entry := range entries {
+		server.SendTo(id, entry.Response)
+		server.SendReactions(id, entry.Response.ID)
+	}
+}
+
+// Handles /history [n], defaulting to the same count replayed on registration
+func (server *Server) HandleHistoryCommand(req request.Request) {
+	client := server.FindClient(req.ConnID)
+	if client == nil || client.Room == "" {
+		return
+	}
+
+	n := server.HistoryReplay
+	if req.Content != "" {
+		parsed, err := strconv.Atoi(req.Content)
+		if err != nil || parsed <= 0 {
+			server.SendError(req.ConnID, response.ErrorCode_InvalidArgument, "Usage: /history [count]")
+			return
+		}
+		n = parsed
+	}
+	if n > MaxHistoryRequest {
+		n = MaxHistoryRequest
+	}
+
+	server.ReplayHistory(req.ConnID, client.Room, n)
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+func TestHistoryEntryRoundTrip(t *testing.T) {
+	entry := HistoryEntry{
+		Seq:      7,
+		Room:     "general",
+		Time:     time.Unix(1700000000, 0).UTC(),
+		Response: response.Response{ResType: response.ResponseType_Message, SenderName: "alice", Content: "hi", ID: 12},
+		Owner:    "guest:0123",
+	}
+
+	buf, err := SerializeHistoryEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := DeserializeHistoryEntry(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Seq != entry.Seq || read.Room != entry.Room || !read.Time.Equal(entry.Time) || read.Owner != entry.Owner || read.Response.ID != entry.Response.ID || read.Response.Content != entry.Response.Content {
+		t.Errorf("Read %+v, expected %+v", read, entry)
+	}
+}
+
+func historyContents(entries []HistoryEntry) []string {
+	contents := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		contents = append(contents, entry.Response.Content)
+	}
+	return contents
+}
+
+// Each room keeps its own ring of the most recent messages, overwriting the oldest once full
+func TestMemoryHistoryRing(t *testing.T) {
+	history := NewMemoryHistory(3)
+	for _, content := range []string{"one", "two", "three", "four", "five"} {
+		history.Append("general", "", response.Response{Content: content})
+	}
+	history.Append("dev", "", response.Response{Content: "elsewhere"})
+
+	recent, _ := history.Recent("general", 10)
+	if contents := historyContents(recent); !reflect.DeepEqual(contents, []string{"three", "four", "five"}) {
+		t.Errorf("Recent messages are %v", contents)
+	}
+	recent, _ = history.Recent("general", 2)
+	if contents := historyContents(recent); !reflect.DeepEqual(contents, []string{"four", "five"}) {
+		t.Errorf("Two most recent messages are %v", contents)
+	}
+	recent, _ = history.Recent("lobby", 10)
+	if len(recent) != 0 {
+		t.Errorf("Empty room has history %v", historyContents(recent))
+	}
+
+	// Sequence numbers are shared by every room, and Since skips the ones already seen
+	since, _ := history.Since("general", 4)
+	if contents := historyContents(since); !reflect.DeepEqual(contents, []string{"five"}) {
+		t.Errorf("Messages since 4 are %v", contents)
+	}
+	if history.LastSeq() != 6 {
+		t.Errorf("Last sequence number is %v, expected 6", history.LastSeq())
+	}
+}
+
+// Reopening the log replays it, so recent messages and sequence numbers carry on where they left off
+func TestFileHistoryReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.log")
+
+	history, err := OpenFileHistory(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"one", "two", "three"} {
+		_, err = history.Append("general", "", response.Response{Content: content})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	history.Append("dev", "", response.Response{Content: "elsewhere"})
+	history.Close()
+
+	// A record cut short by a crash is dropped
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Truncate(path, info.Size()-3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenFileHistory(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	recent, _ := reopened.Recent("general", 10)
+	if contents := historyContents(recent); !reflect.DeepEqual(contents, []string{"two", "three"}) {
+		t.Errorf("Replayed messages are %v", contents)
+	}
+	recent, _ = reopened.Recent("dev", 10)
+	if len(recent) != 0 {
+		t.Errorf("Truncated message was replayed: %v", historyContents(recent))
+	}
+
+	entry, err := reopened.Append("general", "", response.Response{Content: "four"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Seq != 4 {
+		t.Errorf("Appended sequence number %v after reopening, expected 4", entry.Seq)
+	}
+}
+
+func TestFileHistoryReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.log")
+
+	history, err := OpenFileHistory(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history.Append("general", "account:alice", response.Response{SenderName: "alice", Content: "first", ID: 1})
+	history.Append("general", "guest:ab", response.Response{SenderName: "bob", Content: "second", ID: 2})
+	history.Amend(response.Response{ResType: response.ResponseType_Edit, ID: 1, Content: "edited"})
+	history.Amend(response.Response{ResType: response.ResponseType_Delete, ID: 2})
+	err = history.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenFileHistory(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	entry, ok := reopened.Find(1)
+	if !ok || entry.Owner != "account:alice" || entry.Response.Content != "edited" || !entry.Edited {
+		t.Errorf("Reopened entry is %+v", entry)
+	}
+	if _, ok := reopened.Find(2); ok {
+		t.Error("Deleted message was found after reopening")
+	}
+	if reopened.LastMessageID() != 2 {
+		t.Errorf("Last message ID is %v, expected 2", reopened.LastMessageID())
+	}
+}
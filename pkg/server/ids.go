@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// IDs reserved by each write of the ID file, so it is not written for every message
+	MessageIDBlock = 1000
+)
+
+// Hands out message IDs that keep increasing across restarts, saving the highest ID that may be in use to a file
+// The file is written before any ID of a new block is handed out, so a crash skips the rest of the block instead of reusing it
+// An empty path keeps the IDs in memory only; owned by the event loop
+type MessageIDs struct {
+	Path     string
+	last     uint64
+	reserved uint64
+}
+
+func NewMessageIDs() *MessageIDs {
+	return &MessageIDs{}
+}
+
+func OpenMessageIDs(path string) (*MessageIDs, error) {
+	ids := NewMessageIDs()
+	ids.Path = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reserved, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return nil, &ServerError{Message: fmt.Sprintf("Corrupt message ID file %v: %v", path, err)}
+	}
+
+	// IDs up to the saved reservation may have been handed out before the restart
+	ids.last = reserved
+	ids.reserved = reserved
+	return ids, nil
+}
+
+// Makes sure IDs handed out from now on are above the given one, such as the last ID in the history log
+func (ids *MessageIDs) Seed(id uint64) {
+	if id > ids.last {
+		ids.last = id
+	}
+}
+
+func (ids *MessageIDs) Last() uint64 {
+	return ids.last
+}
+
+// Returns the next message ID; it is handed out even if the file could not be written, which is reported as the error
+func (ids *MessageIDs) Next() (uint64, error) {
+	ids.last++
+
+	var err error
+	if ids.Path != "" && ids.last > ids.reserved {
+		err = ids.save(ids.last + MessageIDBlock - 1)
+	}
+	return ids.last, err
+}
+
+// Replaces the file in one step, so a crash leaves either the old reservation or the new one
+func (ids *MessageIDs) save(reserved uint64) error {
+	temp := ids.Path + ".tmp"
+	file, err := os.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	// Synced before the rename, or a crash could leave the new name pointing at an empty file
+	_, err = file.WriteString(strconv.FormatUint(reserved, 10) + "\n")
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	err = os.Rename(temp, ids.Path)
+	if err != nil {
+		return err
+	}
+
+	ids.reserved = reserved
+	return nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMessageIDsAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "message_ids.txt")
+
+	ids, err := OpenMessageIDs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last uint64
+	for i := 0; i < 3; i++ {
+		last, err = ids.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if last != 3 {
+		t.Fatalf("Third ID is %v, expected 3", last)
+	}
+
+	// A restart skips the rest of the block rather than risk handing out an ID twice
+	reopened, err := OpenMessageIDs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := reopened.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next <= last || next != MessageIDBlock+1 {
+		t.Errorf("ID after restarting is %v, expected %v", next, MessageIDBlock+1)
+	}
+}
+
+// IDs continue past the history log even if the file is behind it
+func TestMessageIDsSeed(t *testing.T) {
+	ids := NewMessageIDs()
+	ids.Seed(41)
+	ids.Seed(7)
+
+	next, err := ids.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != 42 {
+		t.Errorf("ID after seeding with 41 is %v, expected 42", next)
+	}
+}
+
+func TestCorruptMessageIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "message_ids.txt")
+	err := os.WriteFile(path, []byte("not a number\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenMessageIDs(path)
+	if _, ok := err.(*ServerError); !ok {
+		t.Errorf("Expected a ServerError, got %v", err)
+	}
+}
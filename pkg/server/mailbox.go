@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/mailbox"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+// Holds a whisper for a registered user who is offline, telling the sender; reports whether the whisper was handled
+func (server *Server) HoldWhisper(sender *ClientConn, res response.Response) bool {
+	if server.Mailbox == nil || server.Accounts == nil || !server.Accounts.Exists(res.ReceiverName) {
+		return false
+	}
+
+	err := server.Mailbox.Add(mailbox.Message{Recipient: res.ReceiverName, Sent: time.Now().UTC(), Response: res})
+	if err != nil {
+		var full *mailbox.MailboxError
+		if errors.As(err, &full) {
+			server.SendError(sender.ID, response.ErrorCode_Unavailable, err.Error())
+			return true
+		}
+		server.Log.Println("Could not save mailbox: ", err)
+		server.SendError(sender.ID, response.ErrorCode_Internal, fmt.Sprintf("Could not queue your message for %v", res.ReceiverName))
+		return true
+	}
+
+	server.Log.Printf("Queued whisper for offline user (username = %v, from = %v)\n", res.ReceiverName, sender.Username)
+	server.Deliver(sender, res)
+	server.SendTo(sender.ID, response.Response{ResType: response.ResponseType_ServerPriv, Content: fmt.Sprintf("%v is offline; your message was queued for delivery", res.ReceiverName)})
+	return true
+}
+
+// Sends an authenticated user the whispers held for them, which keep the ID and time they were sent with
+// A whisper stays in the mailbox until it is queued for the client, so one dropped by a full queue is sent at the next login
+func (server *Server) DeliverMail(client *ClientConn) {
+	if server.Mailbox == nil || !client.Authenticated {
+		return
+	}
+
+	messages := server.Mailbox.Messages(client.Username)
+	if len(messages) == 0 {
+		return
+	}
+
+	if !server.Deliver(client, response.Response{ResType: response.ResponseType_ServerPriv, Content: fmt.Sprintf("%v messages arrived while you were away:", len(messages))}) {
+		return
+	}
+
+	delivered := 0
+	for _, msg := range messages {
+		res := msg.Response
+		if res.Time.IsZero() {
+			res.Time = msg.Sent
+		}
+		if !server.Deliver(client, res) {
+			break
+		}
+		delivered++
+	}
+
+	err := server.Mailbox.Remove(client.Username, delivered)
+	if err != nil {
+		server.Log.Println("Could not save mailbox: ", err)
+		return
+	}
+	server.Log.Printf("Delivered held whispers (username = %v, count = %v)\n", client.Username, delivered)
+}
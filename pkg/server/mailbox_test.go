@@ -0,0 +1,41 @@
+package server
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"github.com/edobrowo/gochatroom/pkg/mailbox"
+	"github.com/edobrowo/gochatroom/pkg/protocol"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+// Whispers that do not fit in the client's queue stay in the mailbox for the next login
+func TestDeliverMailKeepsDropped(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0), Metrics: NewServerMetrics(), Mailbox: mailbox.NewStore(), Overflow: Overflow_DropNewest}
+	for _, content := range []string{"first", "second", "third"} {
+		server.Mailbox.Add(mailbox.Message{Recipient: "bob", Response: response.Response{ResType: response.ResponseType_Whisper, SenderName: "alice", ReceiverName: "bob", Content: content}})
+	}
+
+	// Room for the notice and one whisper
+	client := &ClientConn{Username: "bob", Authenticated: true, Protocol: protocol.NewHello(protocol.Supported), ResponseQueue: make(chan response.Response, 2), Finished: make(chan struct{})}
+	server.DeliverMail(client)
+
+	held := server.Mailbox.Messages("bob")
+	if len(held) != 2 || held[0].Response.Content != "second" {
+		t.Fatalf("Holding %+v after a partial delivery", held)
+	}
+
+	client.ResponseQueue = make(chan response.Response, 10)
+	server.DeliverMail(client)
+	if server.Mailbox.Count("bob") != 0 {
+		t.Errorf("Holding %v messages after a full delivery", server.Mailbox.Count("bob"))
+	}
+	<-client.ResponseQueue
+	for _, expected := range []string{"second", "third"} {
+		res := <-client.ResponseQueue
+		if res.Content != expected {
+			t.Errorf("Delivered %q, expected %q", res.Content, expected)
+		}
+	}
+}
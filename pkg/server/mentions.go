@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+	"github.com/edobrowo/gochatroom/pkg/validation"
+)
+
+// Most distinct users a single message may mention; further names are ignored
+const MaxMentions = 20
+
+// Finds the users named with @username in a message, in the order they first appear
+// Names are read with the characters the username policy allows, and the same name in another case is only counted once
+// An @ inside a word, as in an email address, is not a mention, and a trailing full stop ends the name
+func ParseMentions(content string, policy validation.Policy) []string {
+	var mentions []string
+	seen := make(map[string]bool)
+
+	runes := []rune(validation.Normalize(content))
+	for i := 0; i < len(runes) && len(mentions) < MaxMentions; i++ {
+		if runes[i] != '@' || (i > 0 && policy.Allows(runes[i-1])) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && policy.Allows(runes[end]) {
+			end++
+		}
+		name := strings.TrimRight(string(runes[i+1:end]), ".")
+		i = end - 1
+
+		key := strings.ToLower(name)
+		if name != "" && !seen[key] {
+			seen[key] = true
+			mentions = append(mentions, name)
+		}
+	}
+
+	return mentions
+}
+
+// Reports whether an away client should be spared a room response; their own messages and those that mention them still arrive, as do notices
+func (server *Server) Suppressed(client *ClientConn, res response.Response) bool {
+	if !client.Away || res.SenderName == client.Username || res.MentionsUser(client.Username) {
+		return false
+	}
+
+	switch res.ResType {
+	case response.ResponseType_Message:
+		client.Missed++
+		return true
+	case response.ResponseType_Edit, response.ResponseType_Delete, response.ResponseType_Reactions:
+		return true
+	}
+	return false
+}
+
+// Handles /away and /back, which switch the client in and out of mention-only delivery
+func (server *Server) HandleAwayCommand(req request.Request) {
+	client := server.FindClient(req.ConnID)
+	if client == nil || client.Username == "" {
+		return
+	}
+
+	res := response.Response{ResType: response.ResponseType_ServerPriv, ReceiverName: client.Username}
+	switch req.CmdType {
+	case request.Command_Away:
+		client.Away = true
+		client.Missed = 0
+		res.Content = "You are away; only messages that mention you will be shown until you use /back"
+		break
+	case request.Command_Back:
+		if !client.Away {
+			res.Content = "You are not away"
+			break
+		}
+		client.Away = false
+		res.Content = fmt.Sprintf("Welcome back; you missed %v messages, which /history can replay", client.Missed)
+		client.Missed = 0
+		break
+	}
+
+	server.Log.Printf("Away status changed (username = %v, away = %v)\n", client.Username, client.Away)
+	server.SendTo(req.ConnID, res)
+}
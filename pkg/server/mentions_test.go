@@ -0,0 +1,73 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/edobrowo/gochatroom/pkg/response"
+	"github.com/edobrowo/gochatroom/pkg/validation"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected []string
+	}{
+		{"hello", nil},
+		{"@bob hi", []string{"bob"}},
+		{"hi @bob.", []string{"bob"}},
+		{"@alice and @bob_2, then @alice again", []string{"alice", "bob_2"}},
+		{"@Bob and @bob", []string{"Bob"}},
+		{"mail me at alice@example.com", nil},
+		{"just an @ sign", nil},
+		{"(@carol)", []string{"carol"}},
+		{"@ｄａｖｅ", []string{"dave"}},
+		{"@Ελένη!", []string{"Ελένη"}},
+	}
+
+	for _, test := range tests {
+		mentions := ParseMentions(test.content, validation.DefaultPolicy)
+		if !reflect.DeepEqual(mentions, test.expected) {
+			t.Errorf("ParseMentions(%q) = %q, expected %q", test.content, mentions, test.expected)
+		}
+	}
+}
+
+// Names are read with the characters the server's own policy allows
+func TestParseMentionsPolicy(t *testing.T) {
+	policy := validation.Policy{MaxLength: 16, AllowedPunctuation: "_+"}
+
+	mentions := ParseMentions("@a+b @c.d @e-f", policy)
+	expected := []string{"a+b", "c", "e"}
+	if !reflect.DeepEqual(mentions, expected) {
+		t.Errorf("Mentions are %q, expected %q", mentions, expected)
+	}
+
+	ascii := validation.Policy{ASCIIOnly: true}
+	mentions = ParseMentions("@bob頑張って", ascii)
+	if !reflect.DeepEqual(mentions, []string{"bob"}) {
+		t.Errorf("Mentions are %q, expected only bob", mentions)
+	}
+}
+
+func TestParseMentionsLimit(t *testing.T) {
+	content := ""
+	for i := 0; i < MaxMentions+5; i++ {
+		content += "@user" + string(rune('a'+i)) + " "
+	}
+
+	mentions := ParseMentions(content, validation.DefaultPolicy)
+	if len(mentions) != MaxMentions {
+		t.Errorf("Found %v mentions, expected at most %v", len(mentions), MaxMentions)
+	}
+}
+
+func TestMentionsUser(t *testing.T) {
+	res := response.Response{Mentions: ParseMentions("hey @Bob", validation.DefaultPolicy)}
+	if !res.MentionsUser("bob") || !res.MentionsUser("BOB") {
+		t.Error("Mentions are not matched regardless of case")
+	}
+	if res.MentionsUser("bobby") {
+		t.Error("A longer name was matched")
+	}
+}
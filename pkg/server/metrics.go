@@ -0,0 +1,115 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/metrics"
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+// Counters and histograms describing server load, exposed at /metrics on MetricsAddr
+type ServerMetrics struct {
+	Registry *metrics.Registry
+
+	ConnectedClients    *metrics.Gauge
+	Connections         *metrics.Counter
+	RejectedConnections *metrics.CounterVec
+
+	Requests        *metrics.CounterVec
+	RequestDuration *metrics.HistogramVec
+	Responses       *metrics.CounterVec
+
+	// Bytes read from and written to client connections, including protocol overhead
+	ReceivedBytes *metrics.Counter
+	SentBytes     *metrics.Counter
+
+	// Responses discarded because a queue was full, and requests discarded by the rate limiter
+	DroppedResponses   *metrics.Counter
+	RateLimitedDropped *metrics.Counter
+
+	RegistrationFailures *metrics.CounterVec
+}
+
+func NewServerMetrics() *ServerMetrics {
+	registry := metrics.NewRegistry()
+	return &ServerMetrics{
+		Registry: registry,
+
+		ConnectedClients:    registry.Gauge("chatroom_connected_clients", "Clients currently connected."),
+		Connections:         registry.Counter("chatroom_connections_total", "Clients accepted after the protocol hello."),
+		RejectedConnections: registry.CounterVec("chatroom_rejected_connections_total", "Connections refused before they were accepted, by reason.", "code"),
+
+		Requests:        registry.CounterVec("chatroom_requests_total", "Requests handled, by request and command type.", "type", "command"),
+		RequestDuration: registry.HistogramVec("chatroom_request_duration_seconds", "Time spent handling each request on the event loop.", metrics.DefaultBuckets, "type"),
+		Responses:       registry.CounterVec("chatroom_responses_total", "Responses queued for clients, by response type.", "type"),
+
+		ReceivedBytes: registry.Counter("chatroom_received_bytes_total", "Bytes read from client connections."),
+		SentBytes:     registry.Counter("chatroom_sent_bytes_total", "Bytes written to client connections."),
+
+		DroppedResponses:   registry.Counter("chatroom_dropped_responses_total", "Responses dropped because a client's outbound queue was full."),
+		RateLimitedDropped: registry.Counter("chatroom_rate_limited_requests_total", "Requests dropped for exceeding the rate limit."),
+
+		RegistrationFailures: registry.CounterVec("chatroom_registration_failures_total", "Registrations refused, by reason.", "code"),
+	}
+}
+
+// Clients choose the request and command types they send, so types the server does not know share one label
+const UnknownLabel = "unknown"
+
+// Records a handled request; commands are further split by command type
+func (m *ServerMetrics) ObserveRequest(req request.Request, started time.Time) {
+	reqType := UnknownLabel
+	if req.ReqType.Known() {
+		reqType = req.ReqType.String()
+	}
+
+	command := ""
+	if req.ReqType == request.RequestType_Command {
+		command = UnknownLabel
+		if req.CmdType.Known() {
+			command = req.CmdType.String()
+		}
+	}
+
+	m.Requests.With(reqType, command).Inc()
+	m.RequestDuration.With(reqType).Observe(time.Since(started).Seconds())
+}
+
+func (m *ServerMetrics) ObserveResponse(res response.Response) {
+	m.Responses.With(res.ResType.String()).Inc()
+}
+
+// Counts the bytes passing through a client connection, including codec framing
+// WebSocket connections are wrapped once upgraded, so their frame headers and the HTTP handshake are not counted
+type countingConn struct {
+	net.Conn
+	metrics *ServerMetrics
+}
+
+func (conn *countingConn) Read(p []byte) (int, error) {
+	n, err := conn.Conn.Read(p)
+	conn.metrics.ReceivedBytes.Add(uint64(n))
+	return n, err
+}
+
+func (conn *countingConn) Write(p []byte) (int, error) {
+	n, err := conn.Conn.Write(p)
+	conn.metrics.SentBytes.Add(uint64(n))
+	return n, err
+}
+
+// Serves the metrics for scraping on MetricsAddr; unlike the admin API it needs no token, so bind it to a private address
+func (server *Server) StartMetrics() error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", server.Metrics.Registry.Handler())
+
+	listener, err := server.listenHTTP(server.MetricsAddr, mux, "Metrics endpoint")
+	if err != nil {
+		return err
+	}
+	server.MetricsListener = listener
+	return nil
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/request"
+)
+
+// Unknown request and command types must not each add a series
+func TestObserveRequestLabels(t *testing.T) {
+	m := NewServerMetrics()
+
+	m.ObserveRequest(request.Request{ReqType: request.RequestType_Command, CmdType: request.Command_Whisper}, time.Now())
+	for i := 100; i < 110; i++ {
+		m.ObserveRequest(request.Request{ReqType: request.RequestType(i)}, time.Now())
+		m.ObserveRequest(request.Request{ReqType: request.RequestType_Command, CmdType: request.CommandType(i)}, time.Now())
+	}
+
+	var output bytes.Buffer
+	err := m.Registry.Write(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := output.String()
+
+	expected := []string{
+		`chatroom_requests_total{type="command",command="whisper"} 1`,
+		`chatroom_requests_total{type="unknown",command=""} 10`,
+		`chatroom_requests_total{type="command",command="unknown"} 10`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(text, line) {
+			t.Errorf("Metrics are missing %v", line)
+		}
+	}
+
+	if strings.Contains(text, "type 10") || strings.Contains(text, "command 10") {
+		t.Error("Metrics have a label for an unknown type")
+	}
+}
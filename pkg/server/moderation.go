@@ -0,0 +1,349 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/ban"
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+func IsModerationCommand(cmd request.CommandType) bool {
+	switch cmd {
+	case request.Command_Kick, request.Command_Ban, request.Command_Unban, request.Command_Mute, request.Command_Unmute, request.Command_Op, request.Command_Deop, request.Command_Queues:
+		return true
+	}
+	return false
+}
+
+// Finds the connection registered under a username, or nil if the user is not connected
+func (server *Server) FindUser(username string) *ClientConn {
+	for _, client := range server.Connections {
+		if client.Username == username {
+			return client
+		}
+	}
+	return nil
+}
+
+// The host part of a client address, used for IP bans; IP addresses are canonicalized as by CanonicalIP
+func ClientHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host, _ = CanonicalIP(host)
+	return host
+}
+
+// Writes an IP address one way, so that 2001:DB8::1 and 2001:db8:0::1, or ::ffff:10.0.0.1 and 10.0.0.1, name the same address
+// Reports false, returning the target unchanged, when it is not an IP address
+func CanonicalIP(target string) (string, bool) {
+	ip := net.ParseIP(target)
+	if ip == nil {
+		return target, false
+	}
+	return ip.String(), true
+}
+
+// Splits "[duration] [reason]"; a missing or unparseable duration leaves the whole string as the reason
+func ParseDurationArg(arg string) (time.Duration, string) {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return 0, ""
+	}
+
+	duration, err := time.ParseDuration(fields[0])
+	if err != nil || duration <= 0 {
+		return 0, strings.Join(fields, " ")
+	}
+
+	return duration, strings.Join(fields[1:], " ")
+}
+
+func describeDuration(duration time.Duration) string {
+	if duration == 0 {
+		return "indefinitely"
+	}
+	return "for " + duration.String()
+}
+
+// Operators listed in the configuration must log in with a password, so the role cannot be taken by a guest using the name
+func (server *Server) IsConfiguredOperator(client *ClientConn) bool {
+	return client.Authenticated && server.Operators[client.Username]
+}
+
+// Sends a termination notice and detaches the client, so it is not held for a session resume and its room is told why it left
+func (server *Server) Disconnect(client *ClientConn, code response.ErrorCode, reason string, announcement string) {
+	if client.Room != "" {
+		room := client.Room
+		server.RemoveFromRoom(client.ID, room)
+		client.Room = ""
+		if announcement != "" {
+			server.BroadcastRoom(room, response.Response{ResType: response.ResponseType_ServerRoom, Content: announcement})
+		}
+	}
+	client.SessionToken = ""
+
+	server.SendTo(client.ID, response.Response{ResType: response.ResponseType_TerminateConnection, Code: code, Content: reason})
+}
+
+// Reports whether a user is muted, and until when; a zero time means indefinitely
+func (server *Server) IsMuted(username string) (bool, time.Time) {
+	until, ok := server.Mutes[username]
+	if !ok {
+		return false, time.Time{}
+	}
+
+	if !until.IsZero() && time.Now().After(until) {
+		delete(server.Mutes, username)
+		return false, time.Time{}
+	}
+
+	return true, until
+}
+
+// Tells a muted client it cannot talk, and for how long; reports whether the client is muted
+func (server *Server) RefuseMuted(client *ClientConn) bool {
+	muted, until := server.IsMuted(client.Username)
+	if !muted {
+		return false
+	}
+
+	notice := "You are muted"
+	if !until.IsZero() {
+		notice += fmt.Sprintf(" for another %v", time.Until(until).Round(time.Second))
+	}
+	server.SendError(client.ID, response.ErrorCode_Muted, notice)
+	return true
+}
+
+func (server *Server) HandleModerationCommand(req request.Request) {
+	res := response.Response{ResType: response.ResponseType_ServerPriv, SenderName: req.SenderName, ReceiverName: req.SenderName}
+
+	client := server.FindClient(req.ConnID)
+	if client == nil || client.Username == "" {
+		return
+	}
+
+	if !client.Operator {
+		server.SendError(req.ConnID, response.ErrorCode_Unauthorized, "You are not an operator")
+		return
+	}
+
+	// The only operator command without a target
+	if req.CmdType == request.Command_Queues {
+		res.Content = server.DescribeQueues()
+		server.SendTo(req.ConnID, res)
+		return
+	}
+
+	if req.ReceiverName == "" {
+		server.SendError(req.ConnID, response.ErrorCode_InvalidArgument, moderationUsage(req.CmdType))
+		return
+	}
+
+	// Banning your own address would disconnect you as well
+	host, _ := CanonicalIP(req.ReceiverName)
+	self := req.ReceiverName == client.Username || host == ClientHost(client.ClientAddr)
+	if self && req.CmdType != request.Command_Unban && req.CmdType != request.Command_Unmute {
+		server.SendError(req.ConnID, response.ErrorCode_InvalidArgument, "You cannot moderate yourself")
+		return
+	}
+
+	var err error
+	switch req.CmdType {
+	case request.Command_Kick:
+		res.Content, err = server.Kick(client.Username, req.ReceiverName, req.Content)
+		break
+	case request.Command_Ban:
+		duration, reason := ParseDurationArg(req.Content)
+		res.Content, err = server.Ban(client.Username, req.ReceiverName, duration, reason)
+		break
+	case request.Command_Unban:
+		res.Content, err = server.Unban(req.ReceiverName)
+		break
+	case request.Command_Mute:
+		duration, _ := ParseDurationArg(req.Content)
+		res.Content, err = server.Mute(client.Username, req.ReceiverName, duration)
+		break
+	case request.Command_Unmute:
+		res.Content, err = server.Unmute(req.ReceiverName)
+		break
+	case request.Command_Op:
+		res.Content, err = server.SetOperator(client.Username, req.ReceiverName, true)
+		break
+	case request.Command_Deop:
+		res.Content, err = server.SetOperator(client.Username, req.ReceiverName, false)
+		break
+	}
+
+	if err != nil {
+		server.SendError(req.ConnID, CodeOf(err, response.ErrorCode_Internal), err.Error())
+		return
+	}
+	server.SendTo(req.ConnID, res)
+}
+
+func moderationUsage(cmd request.CommandType) string {
+	switch cmd {
+	case request.Command_Kick:
+		return "Usage: /kick <user> [reason]"
+	case request.Command_Ban:
+		return "Usage: /ban <user|ip> [duration] [reason]"
+	case request.Command_Unban:
+		return "Usage: /unban <user|ip>"
+	case request.Command_Mute:
+		return "Usage: /mute <user> [duration]"
+	case request.Command_Unmute:
+		return "Usage: /unmute <user>"
+	case request.Command_Op:
+		return "Usage: /op <user>"
+	case request.Command_Deop:
+		return "Usage: /deop <user>"
+	}
+	return "Unknown command"
+}
+
+// Disconnects a user; returns a description of the result for the operator
+func (server *Server) Kick(by string, username string, reason string) (string, error) {
+	target := server.FindUser(username)
+	if target == nil {
+		return "", &ServerError{Code: response.ErrorCode_NoSuchUser, Message: fmt.Sprintf("User %v does not exist", username)}
+	}
+
+	notice := fmt.Sprintf("You were kicked by %v", by)
+	announcement := fmt.Sprintf("%v was kicked by %v", username, by)
+	if reason != "" {
+		notice += ": " + reason
+		announcement += ": " + reason
+	}
+
+	server.Log.Printf("Kicked user (username = %v, address = %v, by = %v)\n", username, target.ClientAddr, by)
+	server.Disconnect(target, response.ErrorCode_Kicked, notice, announcement)
+
+	return fmt.Sprintf("Kicked %v", username), nil
+}
+
+// Bans a username, or an address if the target parses as an IP, and disconnects anyone it covers
+func (server *Server) Ban(by string, target string, duration time.Duration, reason string) (string, error) {
+	entry := ban.Ban{Kind: ban.Kind_User, Target: target, By: by, Reason: reason}
+	target, isIP := CanonicalIP(target)
+	if isIP {
+		entry.Kind = ban.Kind_IP
+		entry.Target = target
+	}
+	if duration > 0 {
+		entry.Expires = time.Now().Add(duration)
+	}
+
+	err := server.Bans.Add(entry)
+	if err != nil {
+		server.Log.Println("Could not save ban: ", err)
+		return "", &ServerError{Code: response.ErrorCode_Internal, Message: fmt.Sprintf("Could not ban %v: %v", target, err)}
+	}
+	server.Log.Printf("Banned %v %v %v (by = %v)\n", entry.Kind, target, describeDuration(duration), by)
+
+	for _, client := range server.Connections {
+		if !(entry.Kind == ban.Kind_User && client.Username == target) && !(entry.Kind == ban.Kind_IP && ClientHost(client.ClientAddr) == target) {
+			continue
+		}
+
+		announcement := ""
+		if client.Username != "" {
+			announcement = fmt.Sprintf("%v was banned by %v", client.Username, by)
+		}
+		server.Disconnect(client, response.ErrorCode_Banned, entry.Describe(), announcement)
+	}
+
+	// A banned user must not be able to resume a dropped session either
+	if entry.Kind == ban.Kind_User {
+		delete(server.Reservations, target)
+	}
+
+	return fmt.Sprintf("Banned %v %v", target, describeDuration(duration)), nil
+}
+
+func (server *Server) Unban(target string) (string, error) {
+	kind := ban.Kind_User
+	target, isIP := CanonicalIP(target)
+	if isIP {
+		kind = ban.Kind_IP
+	}
+
+	ok, err := server.Bans.Remove(kind, target)
+	if err != nil {
+		server.Log.Println("Could not save bans: ", err)
+		return "", &ServerError{Code: response.ErrorCode_Internal, Message: fmt.Sprintf("Could not unban %v: %v", target, err)}
+	}
+	if !ok {
+		return "", &ServerError{Code: response.ErrorCode_InvalidArgument, Message: fmt.Sprintf("%v is not banned", target)}
+	}
+
+	server.Log.Printf("Unbanned %v %v\n", kind, target)
+	return fmt.Sprintf("Unbanned %v", target), nil
+}
+
+// Mutes are kept by username, so they outlast reconnects but not a server restart
+func (server *Server) Mute(by string, username string, duration time.Duration) (string, error) {
+	target := server.FindUser(username)
+	if target == nil {
+		return "", &ServerError{Code: response.ErrorCode_NoSuchUser, Message: fmt.Sprintf("User %v does not exist", username)}
+	}
+
+	var until time.Time
+	if duration > 0 {
+		until = time.Now().Add(duration)
+	}
+	server.Mutes[username] = until
+
+	server.Log.Printf("Muted user (username = %v, by = %v) %v\n", username, by, describeDuration(duration))
+	server.SendTo(target.ID, response.Response{ResType: response.ResponseType_ServerPriv, Content: fmt.Sprintf("You were muted by %v %v", by, describeDuration(duration))})
+
+	return fmt.Sprintf("Muted %v %v", username, describeDuration(duration)), nil
+}
+
+func (server *Server) Unmute(username string) (string, error) {
+	muted, _ := server.IsMuted(username)
+	if !muted {
+		return "", &ServerError{Code: response.ErrorCode_InvalidArgument, Message: fmt.Sprintf("%v is not muted", username)}
+	}
+	delete(server.Mutes, username)
+
+	server.Log.Printf("Unmuted user (username = %v)\n", username)
+	target := server.FindUser(username)
+	if target != nil {
+		server.SendTo(target.ID, response.Response{ResType: response.ResponseType_ServerPriv, Content: "You are no longer muted"})
+	}
+
+	return fmt.Sprintf("Unmuted %v", username), nil
+}
+
+// Grants or revokes the operator role for the rest of the user's session
+func (server *Server) SetOperator(by string, username string, operator bool) (string, error) {
+	target := server.FindUser(username)
+	if target == nil {
+		return "", &ServerError{Code: response.ErrorCode_NoSuchUser, Message: fmt.Sprintf("User %v does not exist", username)}
+	}
+
+	if target.Operator == operator {
+		if operator {
+			return "", &ServerError{Code: response.ErrorCode_InvalidArgument, Message: fmt.Sprintf("%v is already an operator", username)}
+		}
+		return "", &ServerError{Code: response.ErrorCode_InvalidArgument, Message: fmt.Sprintf("%v is not an operator", username)}
+	}
+
+	target.Operator = operator
+	server.Log.Printf("Set operator (username = %v, operator = %v, by = %v)\n", username, operator, by)
+
+	if operator {
+		server.SendTo(target.ID, response.Response{ResType: response.ResponseType_ServerPriv, Content: fmt.Sprintf("You were made an operator by %v", by)})
+		return fmt.Sprintf("%v is now an operator", username), nil
+	}
+
+	server.SendTo(target.ID, response.Response{ResType: response.ResponseType_ServerPriv, Content: fmt.Sprintf("Your operator role was removed by %v", by)})
+	return fmt.Sprintf("%v is no longer an operator", username), nil
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"github.com/edobrowo/gochatroom/pkg/account"
+	"github.com/edobrowo/gochatroom/pkg/ban"
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+// Starts a server where alice is a configured operator, logged in, and bob is a guest
+func startModeratedServer(t *testing.T) (*Server, string, *testClient, *testClient) {
+	t.Helper()
+
+	accounts, err := account.OpenStore(filepath.Join(t.TempDir(), "accounts.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = accounts.Register("alice", "hunter22")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{Log: log.New(io.Discard, "", 0), Accounts: accounts, Operators: map[string]bool{"alice": true}}
+	addr := startTestServer(t, server)
+
+	alice, err := dialTestClient(addr, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { alice.conn.Close() })
+	alice.send(t, request.Request{ReqType: request.RequestType_Status, StType: request.Status_Register, Content: "hunter22"})
+	alice.await(t, isRoomNotice("alice has connected"))
+
+	bob, err := dialTestClient(addr, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bob.conn.Close() })
+	bob.register(t)
+	bob.await(t, isRoomNotice("bob has connected"))
+	alice.await(t, isRoomNotice("bob has connected"))
+
+	return server, addr, alice, bob
+}
+
+func isError(code response.ErrorCode) func(response.Response) bool {
+	return func(res response.Response) bool {
+		return res.ResType == response.ResponseType_Error && res.Code == code
+	}
+}
+
+func isTermination(code response.ErrorCode) func(response.Response) bool {
+	return func(res response.Response) bool {
+		return res.ResType == response.ResponseType_TerminateConnection && res.Code == code
+	}
+}
+
+func TestKick(t *testing.T) {
+	_, _, alice, bob := startModeratedServer(t)
+
+	// Guests are not operators
+	bob.send(t, request.Parse("/kick alice"))
+	bob.await(t, isError(response.ErrorCode_Unauthorized))
+
+	alice.send(t, request.Parse("/kick alice"))
+	alice.await(t, isError(response.ErrorCode_InvalidArgument))
+
+	alice.send(t, request.Parse("/kick bob spamming"))
+	bob.await(t, func(res response.Response) bool {
+		return isTermination(response.ErrorCode_Kicked)(res) && res.Content == "You were kicked by alice: spamming"
+	})
+	alice.await(t, isRoomNotice("bob was kicked by alice: spamming"))
+	alice.await(t, isServerNotice("Kicked bob"))
+
+	alice.send(t, request.Parse("/kick bob"))
+	alice.await(t, isError(response.ErrorCode_NoSuchUser))
+}
+
+func TestBanUser(t *testing.T) {
+	server, addr, alice, bob := startModeratedServer(t)
+
+	alice.send(t, request.Parse("/ban bob 1h flooding"))
+	bob.await(t, isTermination(response.ErrorCode_Banned))
+	alice.await(t, isServerNotice("Banned bob for 1h0m0s"))
+
+	// The name is refused when registering again
+	again, err := dialTestClient(addr, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.conn.Close()
+	again.register(t)
+	again.await(t, isTermination(response.ErrorCode_Banned))
+
+	alice.send(t, request.Parse("/unban bob"))
+	alice.await(t, isServerNotice("Unbanned bob"))
+
+	_, banned := server.Bans.Find(ban.Kind_User, "bob")
+	if banned {
+		t.Error("bob is still banned")
+	}
+
+	alice.send(t, request.Parse("/unban bob"))
+	alice.await(t, isError(response.ErrorCode_InvalidArgument))
+}
+
+// Addresses are banned and unbanned however they are written
+func TestBanIP(t *testing.T) {
+	server, addr, _, bob := startModeratedServer(t)
+
+	var result string
+	var err error
+	server.Do(func() {
+		result, err = server.Ban("alice", "::ffff:127.0.0.1", 0, "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "Banned 127.0.0.1 indefinitely" {
+		t.Errorf("Ban returned %q", result)
+	}
+
+	// Everyone on the address is disconnected, and new connections from it are refused
+	bob.await(t, isTermination(response.ErrorCode_Banned))
+	expectRefusal(t, dialSilent(t, addr), response.ErrorCode_Banned)
+
+	server.Do(func() {
+		result, err = server.Unban("0:0:0:0:0:ffff:7f00:1")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := dialTestClient(addr, "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.conn.Close()
+}
+
+func TestMute(t *testing.T) {
+	_, _, alice, bob := startModeratedServer(t)
+
+	alice.send(t, request.Parse("/mute bob"))
+	bob.await(t, isServerNotice("You were muted by alice indefinitely"))
+	alice.await(t, isServerNotice("Muted bob indefinitely"))
+
+	bob.send(t, request.Request{ReqType: request.RequestType_Message, Content: "hello"})
+	bob.await(t, isError(response.ErrorCode_Muted))
+
+	alice.send(t, request.Parse("/unmute bob"))
+	bob.await(t, isServerNotice("You are no longer muted"))
+
+	bob.send(t, request.Request{ReqType: request.RequestType_Message, Content: "hello"})
+	alice.await(t, isMessage("bob", "hello"))
+
+	alice.send(t, request.Parse("/unmute bob"))
+	alice.await(t, isError(response.ErrorCode_InvalidArgument))
+}
+
+func TestOp(t *testing.T) {
+	_, _, alice, bob := startModeratedServer(t)
+
+	alice.send(t, request.Parse("/op bob"))
+	bob.await(t, isServerNotice("You were made an operator by alice"))
+	alice.await(t, isServerNotice("bob is now an operator"))
+
+	alice.send(t, request.Parse("/op bob"))
+	alice.await(t, isError(response.ErrorCode_InvalidArgument))
+
+	// The new operator can moderate until the role is revoked
+	bob.send(t, request.Parse("/mute alice 1m"))
+	bob.await(t, isServerNotice("Muted alice for 1m0s"))
+
+	alice.send(t, request.Parse("/deop bob"))
+	alice.await(t, isServerNotice("bob is no longer an operator"))
+
+	bob.send(t, request.Parse("/unmute alice"))
+	bob.await(t, isError(response.ErrorCode_Unauthorized))
+}
+
+// A configured operator name without an account cannot be registered by whoever connects with it first
+func TestRegisterOperatorName(t *testing.T) {
+	server, addr, _, _ := startModeratedServer(t)
+	server.Do(func() {
+		server.Operators["carol"] = true
+	})
+
+	carol, err := dialTestClient(addr, "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer carol.conn.Close()
+	carol.register(t)
+	carol.await(t, isRoomNotice("carol has connected"))
+
+	carol.send(t, request.Parse("/register hunter22"))
+	carol.await(t, isError(response.ErrorCode_Unauthorized))
+
+	carol.send(t, request.Parse("/kick bob"))
+	carol.await(t, isError(response.ErrorCode_Unauthorized))
+	if server.Accounts.Exists("carol") {
+		t.Error("An account was created for an operator name")
+	}
+}
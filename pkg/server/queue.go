@@ -0,0 +1,172 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/protocol"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+const (
+	DefaultQueueSize    = 256
+	DefaultWriteTimeout = 10 * time.Second
+
+	// Overflows are logged on the first dropped response and then every this many
+	OverflowLogInterval = 100
+)
+
+// What to do with a response when a client's outbound queue is full
+type OverflowPolicy int
+
+const (
+	Overflow_DropOldest OverflowPolicy = iota
+	Overflow_DropNewest
+	Overflow_Disconnect
+)
+
+var overflowPolicyNames = map[string]OverflowPolicy{
+	"drop-oldest": Overflow_DropOldest,
+	"drop-newest": Overflow_DropNewest,
+	"disconnect":  Overflow_Disconnect,
+}
+
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	policy, ok := overflowPolicyNames[name]
+	if !ok {
+		return 0, &ServerError{Message: fmt.Sprintf("Unknown overflow policy %v; expected drop-oldest, drop-newest or disconnect", name)}
+	}
+	return policy, nil
+}
+
+func (policy OverflowPolicy) String() string {
+	for name, p := range overflowPolicyNames {
+		if p == policy {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// Queues a response for a client without ever blocking the event loop
+// Nothing is queued once the client's Send goroutine has exited; reports whether the response was queued
+func (server *Server) Deliver(client *ClientConn, res response.Response) bool {
+	select {
+	case <-client.Finished:
+		return false
+	default:
+	}
+
+	// Clients that did not negotiate error codes are sent failures as plain server messages
+	if res.ResType == response.ResponseType_Error && !client.Protocol.Features.Has(protocol.Feature_ErrorCodes) {
+		res.ResType = response.ResponseType_ServerPriv
+	}
+
+	// Clients that cannot replace a message they have shown are told about the change instead
+	if (res.ResType == response.ResponseType_Edit || res.ResType == response.ResponseType_Delete) && !client.Protocol.Features.Has(protocol.Feature_Edits) {
+		res = DescribeAmendment(res)
+	}
+
+	// Reactions are meant to be quiet, so clients that cannot show them as counts are not told about them
+	if res.ResType == response.ResponseType_Reactions && !client.Protocol.Features.Has(protocol.Feature_Reactions) {
+		return false
+	}
+	server.Metrics.ObserveResponse(res)
+
+	select {
+	case client.ResponseQueue <- res:
+		return true
+	default:
+	}
+
+	// Termination notices always make room for themselves, since the client is being disconnected anyway
+	policy := server.Overflow
+	if res.ResType == response.ResponseType_TerminateConnection {
+		policy = Overflow_DropOldest
+	}
+
+	client.Dropped++
+	server.Metrics.DroppedResponses.Inc()
+	if client.Dropped%OverflowLogInterval == 1 {
+		server.Log.Printf("Outbound queue full (username = %v, address = %v, dropped = %v, policy = %v)\n", client.Username, client.ClientAddr, client.Dropped, policy)
+	}
+
+	switch policy {
+	case Overflow_DropOldest:
+		// Only the event loop sends on the queue, so once an item is taken there is room for this one
+		select {
+		case <-client.ResponseQueue:
+		default:
+		}
+		select {
+		case client.ResponseQueue <- res:
+			return true
+		default:
+		}
+		break
+	case Overflow_DropNewest:
+		break
+	case Overflow_Disconnect:
+		// Closing the connection makes both client goroutines fail and report, which removes the client
+		if !client.Overflowed {
+			client.Overflowed = true
+			server.Log.Printf("Disconnecting slow client (username = %v, address = %v)\n", client.Username, client.ClientAddr)
+			client.Connection.Close()
+		}
+		break
+	}
+	return false
+}
+
+// Closes a client connection, tolerating connections already closed by an overflow
+func closeConnection(conn net.Conn) error {
+	err := conn.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+type QueueStat struct {
+	ID       ConnID
+	Username string
+	Depth    int
+	Capacity int
+	Dropped  int
+}
+
+// Reports the outbound queue of every connection, sorted by ID; called on the event loop
+func (server *Server) QueueStats() []QueueStat {
+	stats := make([]QueueStat, 0, len(server.Connections))
+	for _, client := range server.Connections {
+		stats = append(stats, QueueStat{
+			ID:       client.ID,
+			Username: client.Username,
+			Depth:    len(client.ResponseQueue),
+			Capacity: cap(client.ResponseQueue),
+			Dropped:  client.Dropped,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+// Describes the outbound queues for the /queues operator command
+func (server *Server) DescribeQueues() string {
+	stats := server.QueueStats()
+
+	entries := make([]string, len(stats))
+	for i, stat := range stats {
+		name := stat.Username
+		if name == "" {
+			name = "(unregistered)"
+		}
+		entries[i] = fmt.Sprintf("%v #%v %v/%v dropped %v", name, stat.ID, stat.Depth, stat.Capacity, stat.Dropped)
+	}
+
+	return fmt.Sprintf("Outbound queues (%v): %v", server.Overflow, strings.Join(entries, ", "))
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/frame"
+	"github.com/edobrowo/gochatroom/pkg/protocol"
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+// Large enough that a few of them fill the socket buffers
+var filler = strings.Repeat("x", 512<<10)
+
+// Registers a client that then reads nothing until the test resumes reading from the returned reader
+func dialStalled(t *testing.T, addr string, name string) (net.Conn, *frame.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	writer := frame.NewWriter(conn)
+	reader := frame.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(testTimeout))
+
+	err = protocol.Write(writer, protocol.NewHello(protocol.Supported))
+	if err == nil {
+		_, err = protocol.Read(reader)
+	}
+	if err == nil {
+		err = request.Write(writer, request.Request{ReqType: request.RequestType_Status, StType: request.Status_Register, SenderName: name})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		res, err := response.Read(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isRoomNotice(name + " has connected")(res) {
+			break
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, reader
+}
+
+// Delivers large responses to a stalled client until its queue overflows
+func fillQueue(t *testing.T, server *Server, client *ClientConn) {
+	t.Helper()
+
+	for i := 0; i < 10000; i++ {
+		var dropped int
+		server.Do(func() {
+			server.Deliver(client, response.Response{ResType: response.ResponseType_ServerPriv, Content: filler})
+			dropped = client.Dropped
+		})
+		if dropped > 0 {
+			return
+		}
+	}
+
+	t.Fatal("Queue of a stalled client never overflowed")
+}
+
+func findTestUser(server *Server, name string) *ClientConn {
+	var client *ClientConn
+	server.Do(func() {
+		client = server.FindUser(name)
+	})
+	return client
+}
+
+// Reads the stalled client's backlog until a response with the given content, reporting whether it was seen
+func readBacklog(t *testing.T, conn net.Conn, reader *frame.Reader, content string) bool {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	for {
+		res, err := response.Read(reader)
+		if err != nil {
+			return false
+		}
+		if res.Content == content {
+			return true
+		}
+	}
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0), QueueSize: 4, Overflow: Overflow_DropOldest}
+	addr := startTestServer(t, server)
+
+	conn, reader := dialStalled(t, addr, "bob")
+	client := findTestUser(server, "bob")
+	fillQueue(t, server, client)
+
+	// The queue is still full, so the newest response makes room for itself
+	server.Do(func() {
+		server.Deliver(client, response.Response{ResType: response.ResponseType_ServerPriv, Content: "last"})
+	})
+
+	var stats []QueueStat
+	server.Do(func() {
+		stats = server.QueueStats()
+	})
+	if len(stats) != 1 || stats[0].Dropped < 2 || stats[0].Capacity != 4 {
+		t.Errorf("Queue stats are %+v, expected at least 2 dropped from a queue of 4", stats)
+	}
+
+	if !readBacklog(t, conn, reader, "last") {
+		t.Error("Newest response was dropped")
+	}
+}
+
+func TestOverflowDropNewest(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0), QueueSize: 4, Overflow: Overflow_DropNewest}
+	addr := startTestServer(t, server)
+
+	conn, reader := dialStalled(t, addr, "bob")
+	client := findTestUser(server, "bob")
+	fillQueue(t, server, client)
+
+	server.Do(func() {
+		server.Deliver(client, response.Response{ResType: response.ResponseType_ServerPriv, Content: "dropped"})
+	})
+	server.Do(func() {
+		server.Deliver(client, response.Response{ResType: response.ResponseType_TerminateConnection, Content: "end"})
+	})
+
+	// Termination notices make room for themselves whatever the policy, and end the backlog
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	for {
+		res, err := response.Read(reader)
+		if err != nil {
+			t.Fatal("Termination notice was not received: ", err)
+		}
+		if res.Content == "dropped" {
+			t.Error("Response delivered to a full queue was sent")
+		}
+		if res.ResType == response.ResponseType_TerminateConnection {
+			break
+		}
+	}
+
+	var dropped int
+	server.Do(func() {
+		dropped = client.Dropped
+	})
+	if dropped < 3 {
+		t.Errorf("Dropped %v responses, expected at least 3", dropped)
+	}
+}
+
+func TestOverflowDisconnect(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0), QueueSize: 4, Overflow: Overflow_Disconnect}
+	addr := startTestServer(t, server)
+
+	conn, reader := dialStalled(t, addr, "bob")
+	client := findTestUser(server, "bob")
+	fillQueue(t, server, client)
+
+	if !waitForServer(t, server, func() bool { return server.FindUser("bob") == nil }) {
+		t.Fatal("Overflowing client was not removed")
+	}
+
+	if readBacklog(t, conn, reader, "never sent") {
+		t.Error("Read past the end of a closed connection")
+	}
+}
+
+// A client that stops reading is disconnected once a write takes longer than the write timeout, whatever the policy
+func TestWriteTimeout(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0), QueueSize: 4, Overflow: Overflow_DropNewest, WriteTimeout: 100 * time.Millisecond}
+	addr := startTestServer(t, server)
+
+	dialStalled(t, addr, "bob")
+	client := findTestUser(server, "bob")
+
+	// Responses keep coming until the socket buffers are full and a write is left waiting on the reader
+	removed := waitForServer(t, server, func() bool {
+		if server.FindUser("bob") == nil {
+			return true
+		}
+		server.Deliver(client, response.Response{ResType: response.ResponseType_ServerPriv, Content: filler})
+		return false
+	})
+	if !removed {
+		t.Fatal("Stalled client was not removed after its write timed out")
+	}
+}
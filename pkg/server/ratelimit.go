@@ -0,0 +1,254 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/ban"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+const (
+	// Dropped requests within this interval of the last violation count as the same violation
+	ViolationInterval = time.Second
+
+	DefaultMaxConnectionsPerIP = 10
+
+	// How long a connection refused before its hello has to send it, so the refusal can be written in its codec
+	RefuseTimeout = time.Second
+)
+
+// Limits how quickly requests are accepted from a connection, and how offenders are dealt with
+type FloodPolicy struct {
+	// Sustained requests per second, and how many may arrive at once; a zero rate disables rate limiting
+	Rate  float64
+	Burst int
+
+	// Violations before a temporary mute, and before disconnecting; 0 disables the step
+	// The first violation only warns, and a zero MuteDuration mutes for DefaultFloodPolicy's
+	MuteAfter       int
+	MuteDuration    time.Duration
+	DisconnectAfter int
+
+	// Violations are forgotten after this long without one
+	Forgive time.Duration
+}
+
+var DefaultFloodPolicy = FloodPolicy{
+	Rate:            5,
+	Burst:           10,
+	MuteAfter:       3,
+	MuteDuration:    30 * time.Second,
+	DisconnectAfter: 6,
+	Forgive:         time.Minute,
+}
+
+type FloodLevel int
+
+const (
+	Flood_None FloodLevel = iota
+	Flood_Warn
+	Flood_Mute
+	Flood_Disconnect
+)
+
+// Reported by a Receive goroutine when a client crosses one of the escalation thresholds
+type FloodViolation struct {
+	ID    ConnID
+	Level FloodLevel
+}
+
+// Refills at Rate tokens per second up to Burst; each request takes one token
+type TokenBucket struct {
+	Rate   float64
+	Burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{Rate: rate, Burst: float64(burst), tokens: float64(burst)}
+}
+
+func (bucket *TokenBucket) Allow(now time.Time) bool {
+	if !bucket.last.IsZero() {
+		bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.Rate
+		if bucket.tokens > bucket.Burst {
+			bucket.tokens = bucket.Burst
+		}
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+	return true
+}
+
+// Tracks one connection's request rate and violations; owned by the connection's Receive goroutine
+type FloodGuard struct {
+	Policy        FloodPolicy
+	bucket        *TokenBucket
+	violations    int
+	lastViolation time.Time
+}
+
+func NewFloodGuard(policy FloodPolicy) *FloodGuard {
+	if policy.Rate <= 0 {
+		return nil
+	}
+	return &FloodGuard{Policy: policy, bucket: NewTokenBucket(policy.Rate, policy.Burst)}
+}
+
+// Reports whether a request may be handled, and the escalation step reached if this request crossed a threshold
+func (guard *FloodGuard) Check(now time.Time) (bool, FloodLevel) {
+	if guard.bucket.Allow(now) {
+		return true, Flood_None
+	}
+
+	if !guard.lastViolation.IsZero() && now.Sub(guard.lastViolation) < ViolationInterval {
+		return false, Flood_None
+	}
+	if guard.Policy.Forgive > 0 && now.Sub(guard.lastViolation) > guard.Policy.Forgive {
+		guard.violations = 0
+	}
+	guard.violations++
+	guard.lastViolation = now
+
+	if guard.Policy.DisconnectAfter > 0 && guard.violations == guard.Policy.DisconnectAfter {
+		return false, Flood_Disconnect
+	}
+	if guard.Policy.MuteAfter > 0 && guard.violations == guard.Policy.MuteAfter {
+		return false, Flood_Mute
+	}
+	if guard.violations == 1 {
+		return false, Flood_Warn
+	}
+
+	return false, Flood_None
+}
+
+// Counts open connections by host, from when they are accepted until they are closed
+// Connections are admitted on the accept goroutines, before the event loop knows about them, so the counts are locked
+type hostCounter struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+// Takes a slot for the host, unless it already holds limit of them; a limit of 0 is unlimited
+func (counter *hostCounter) acquire(host string, limit int) bool {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	if counter.counts == nil {
+		counter.counts = make(map[string]int)
+	}
+	if limit > 0 && counter.counts[host] >= limit {
+		return false
+	}
+	counter.counts[host]++
+	return true
+}
+
+func (counter *hostCounter) release(host string) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	counter.counts[host]--
+	if counter.counts[host] <= 0 {
+		delete(counter.counts, host)
+	}
+}
+
+// Gives its host's slot back the first time it is closed
+type admittedConn struct {
+	net.Conn
+	hosts   *hostCounter
+	host    string
+	release sync.Once
+}
+
+func (conn *admittedConn) Close() error {
+	conn.release.Do(func() {
+		conn.hosts.release(conn.host)
+	})
+	return conn.Conn.Close()
+}
+
+// Checks a new connection's address against the ban list and the per-address limit before anything is read from it
+// On success the host holds a slot until Track's connection is closed; on failure the error is a ServerError with the reason
+func (server *Server) Admit(addr string) error {
+	host := ClientHost(addr)
+
+	entry, banned := server.Bans.Find(ban.Kind_IP, host)
+	if banned {
+		server.Log.Println("Rejected banned client: ", addr)
+		return &ServerError{Code: response.ErrorCode_Banned, Message: entry.Describe()}
+	}
+
+	if !server.hosts.acquire(host, server.MaxConnectionsPerIP) {
+		server.Log.Println("Rejected client, too many connections from its address: ", addr)
+		return &ServerError{Code: response.ErrorCode_TooManyConnections, Message: "Too many connections from your address"}
+	}
+
+	return nil
+}
+
+// Wraps an admitted connection so that closing it frees the slot Admit took for addr
+func (server *Server) Track(conn net.Conn, addr string) net.Conn {
+	return &admittedConn{Conn: conn, hosts: &server.hosts, host: ClientHost(addr)}
+}
+
+// Frees the slot of a connection that was admitted but never tracked, such as a failed WebSocket upgrade
+func (server *Server) Forget(addr string) {
+	server.hosts.release(ClientHost(addr))
+}
+
+func (server *Server) HandleFloodViolation(violation FloodViolation) {
+	client := server.FindClient(violation.ID)
+	if client == nil {
+		return
+	}
+
+	server.Log.Printf("Flood violation (username = %v, address = %v, level = %v)\n", client.Username, client.ClientAddr, violation.Level)
+
+	switch violation.Level {
+	case Flood_Warn:
+		server.SendError(client.ID, response.ErrorCode_RateLimited, "You are sending messages too quickly; slow down or you will be muted")
+		break
+	case Flood_Mute:
+		// Unregistered connections have nothing to mute, so they only get the warning
+		if client.Username == "" {
+			break
+		}
+		duration := server.Flood.MuteDuration
+		if duration <= 0 {
+			duration = DefaultFloodPolicy.MuteDuration
+		}
+		until := time.Now().Add(duration)
+
+		// A mute only ever lengthens an existing one, so flooding cannot cut short an operator's longer or indefinite mute
+		muted, current := server.IsMuted(client.Username)
+		if muted && (current.IsZero() || current.After(until)) {
+			break
+		}
+
+		server.Mutes[client.Username] = until
+		server.SendTo(client.ID, response.Response{ResType: response.ResponseType_ServerPriv, Content: fmt.Sprintf("You were muted for %v for flooding", duration)})
+		break
+	case Flood_Disconnect:
+		announcement := ""
+		if client.Username != "" {
+			announcement = fmt.Sprintf("%v was disconnected for flooding", client.Username)
+		}
+		server.Disconnect(client, response.ErrorCode_RateLimited, "Disconnected for flooding", announcement)
+		break
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := NewTokenBucket(2, 3)
+	now := time.Unix(1700000000, 0)
+
+	// A full bucket allows a burst, then nothing until it refills
+	for i := 0; i < 3; i++ {
+		if !bucket.Allow(now) {
+			t.Fatalf("Request %v of the burst was refused", i+1)
+		}
+	}
+	if bucket.Allow(now) {
+		t.Error("Request past the burst was allowed")
+	}
+
+	// Two tokens a second, so one more after half a second
+	now = now.Add(500 * time.Millisecond)
+	if !bucket.Allow(now) {
+		t.Error("Refilled token was refused")
+	}
+	if bucket.Allow(now) {
+		t.Error("Bucket refilled faster than its rate")
+	}
+
+	// Refilling stops at the burst
+	now = now.Add(time.Hour)
+	allowed := 0
+	for bucket.Allow(now) {
+		allowed++
+	}
+	if allowed != 3 {
+		t.Errorf("Allowed %v requests after a long idle, expected the burst of 3", allowed)
+	}
+
+	// A burst below one still allows single requests
+	if !NewTokenBucket(1, 0).Allow(now) {
+		t.Error("Bucket with no burst refused its first request")
+	}
+}
+
+// Violations are counted once per ViolationInterval and escalate from a warning to a mute to a disconnect
+func TestFloodGuardEscalation(t *testing.T) {
+	// Slow enough that no token comes back during the test
+	policy := FloodPolicy{Rate: 0.001, Burst: 1, MuteAfter: 3, MuteDuration: time.Minute, DisconnectAfter: 5, Forgive: time.Minute}
+	guard := NewFloodGuard(policy)
+	now := time.Unix(1700000000, 0)
+
+	ok, _ := guard.Check(now)
+	if !ok {
+		t.Fatal("First request was refused")
+	}
+
+	expected := []FloodLevel{Flood_Warn, Flood_None, Flood_Mute, Flood_None, Flood_Disconnect}
+	for i, level := range expected {
+		// Requests dropped within the interval of a violation count as the same one
+		now = now.Add(ViolationInterval / 10)
+		ok, got := guard.Check(now)
+		if ok || got != level {
+			t.Fatalf("Violation %v: got %v, %v; expected a refusal at level %v", i+1, ok, got, level)
+		}
+		ok, got = guard.Check(now)
+		if ok || got != Flood_None {
+			t.Fatalf("Violation %v was counted twice", i+1)
+		}
+
+		now = now.Add(ViolationInterval)
+	}
+
+	// Violations are forgotten after a quiet spell, so the next one only warns
+	now = now.Add(policy.Forgive + time.Second)
+	_, got := guard.Check(now)
+	if got != Flood_Warn {
+		t.Errorf("Violation after the forgive period reached level %v, expected a warning", got)
+	}
+
+	if NewFloodGuard(FloodPolicy{}) != nil {
+		t.Error("A zero rate did not disable rate limiting")
+	}
+}
+
+// A flood mute lengthens a shorter mute but never shortens a longer or indefinite one
+func TestFloodMute(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0), Flood: FloodPolicy{Rate: 1, Burst: 1, MuteAfter: 1}}
+	addr := startTestServer(t, server)
+
+	bob, err := dialTestClient(addr, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.conn.Close()
+	bob.register(t)
+	bob.await(t, isRoomNotice("bob has connected"))
+
+	mute := func(current time.Time, set bool) time.Time {
+		var until time.Time
+		server.Do(func() {
+			delete(server.Mutes, "bob")
+			if set {
+				server.Mutes["bob"] = current
+			}
+			server.HandleFloodViolation(FloodViolation{ID: server.FindUser("bob").ID, Level: Flood_Mute})
+			until = server.Mutes["bob"]
+		})
+		return until
+	}
+
+	// A zero duration mutes for the default rather than not at all
+	until := mute(time.Time{}, false)
+	remaining := time.Until(until)
+	if remaining <= 0 || remaining > DefaultFloodPolicy.MuteDuration {
+		t.Errorf("Muted for %v, expected the default %v", remaining, DefaultFloodPolicy.MuteDuration)
+	}
+	bob.await(t, isServerNotice("You were muted for 30s for flooding"))
+
+	until = mute(time.Time{}, true)
+	if !until.IsZero() {
+		t.Errorf("An indefinite mute was cut short to %v", until)
+	}
+
+	longer := time.Now().Add(time.Hour)
+	until = mute(longer, true)
+	if !until.Equal(longer) {
+		t.Errorf("A longer mute was cut short to %v", until)
+	}
+
+	shorter := time.Now().Add(time.Second)
+	until = mute(shorter, true)
+	if !until.After(shorter) {
+		t.Errorf("A shorter mute was not extended")
+	}
+
+	// Expired mutes are replaced too
+	expired := time.Now().Add(-time.Second)
+	until = mute(expired, true)
+	if !until.After(time.Now()) {
+		t.Errorf("An expired mute was not replaced")
+	}
+}
+
+func TestFloodDisconnect(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0)}
+	addr := startTestServer(t, server)
+
+	bob, err := dialTestClient(addr, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.conn.Close()
+	bob.register(t)
+	bob.await(t, isRoomNotice("bob has connected"))
+
+	server.Do(func() {
+		server.HandleFloodViolation(FloodViolation{ID: server.FindUser("bob").ID, Level: Flood_Warn})
+	})
+	bob.await(t, isError(response.ErrorCode_RateLimited))
+
+	server.Do(func() {
+		server.HandleFloodViolation(FloodViolation{ID: server.FindUser("bob").ID, Level: Flood_Disconnect})
+	})
+	bob.await(t, isTermination(response.ErrorCode_RateLimited))
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+const (
+	// Different reactions a single message may collect
+	MaxReactionsPerMessage = 20
+
+	// Longest reaction accepted, in bytes; enough for emoji joined into sequences
+	MaxReactionLength = 32
+
+	// Messages whose reactions are kept; the oldest are forgotten first
+	MaxReactedMessages = 1000
+)
+
+// Shortcodes accepted in place of the emoji they name
+var Shortcodes = map[string]string{
+	":+1:":       "👍",
+	":thumbsup:": "👍",
+	":-1:":       "👎",
+	":heart:":    "❤️",
+	":joy:":      "😂",
+	":smile:":    "😄",
+	":tada:":     "🎉",
+	":eyes:":     "👀",
+	":fire:":     "🔥",
+	":rocket:":   "🚀",
+	":thinking:": "🤔",
+	":100:":      "💯",
+	":pray:":     "🙏",
+	":cry:":      "😢",
+	":check:":    "✅",
+}
+
+// The principals who reacted to a message with one emoji, in the order they reacted
+type ReactionUsers struct {
+	Emoji string
+	Users []string
+}
+
+// Reactions to a single message, in the order each emoji was first used
+type MessageReactions []ReactionUsers
+
+func (reactions MessageReactions) Counts() []response.Reaction {
+	counts := make([]response.Reaction, len(reactions))
+	for i, reaction := range reactions {
+		counts[i] = response.Reaction{Emoji: reaction.Emoji, Count: len(reaction.Users)}
+	}
+	return counts
+}
+
+// Resolves a shortcode to its emoji and checks that a reaction is a single emoji rather than text
+func ParseReaction(arg string) (string, error) {
+	emoji, ok := Shortcodes[strings.ToLower(arg)]
+	if ok {
+		return emoji, nil
+	}
+
+	if arg == "" || len(arg) > MaxReactionLength {
+		return "", &ServerError{Code: response.ErrorCode_InvalidArgument, Message: "A reaction must be a single emoji or a shortcode such as :+1:"}
+	}
+	// Emoji may include ASCII, as keycaps do, but never letters of any script, and never consist of ASCII alone
+	// Invisible control and format characters are refused too, except those that join emoji into sequences
+	emojiLike := false
+	for _, r := range arg {
+		if unicode.IsSpace(r) || unicode.IsLetter(r) || unicode.IsControl(r) || (unicode.Is(unicode.Cf, r) && !joinsEmoji(r)) {
+			emojiLike = false
+			break
+		}
+		if r > unicode.MaxASCII {
+			emojiLike = true
+		}
+	}
+	if !emojiLike {
+		return "", &ServerError{Code: response.ErrorCode_InvalidArgument, Message: fmt.Sprintf("Unknown reaction %v; use an emoji or a shortcode such as :+1:", arg)}
+	}
+
+	return arg, nil
+}
+
+// The zero-width joiner combines emoji such as families, and tag characters spell out subdivision flags
+func joinsEmoji(r rune) bool {
+	return r == '\u200d' || (r >= 0xe0020 && r <= 0xe007f)
+}
+
+func reactUsage(cmd request.CommandType) string {
+	if cmd == request.Command_React {
+		return "Usage: /react <id> <emoji>"
+	}
+	return "Usage: /unreact <id> <emoji>"
+}
+
+// Handles /react and /unreact, then sends the message's room its new counts
+func (server *Server) HandleReactCommand(req request.Request) {
+	client := server.FindClient(req.ConnID)
+	if client == nil || client.Username == "" {
+		return
+	}
+
+	id := req.MessageID
+	if id == 0 || req.Content == "" {
+		server.SendError(req.ConnID, response.ErrorCode_InvalidArgument, reactUsage(req.CmdType))
+		return
+	}
+
+	emoji, err := ParseReaction(req.Content)
+	if err != nil {
+		server.SendError(req.ConnID, CodeOf(err, response.ErrorCode_InvalidArgument), err.Error())
+		return
+	}
+
+	entry, ok := server.FindInRoom(client, id)
+	if !ok {
+		return
+	}
+
+	if req.CmdType == request.Command_React {
+		if server.RefuseMuted(client) {
+			return
+		}
+		err = server.AddReaction(id, emoji, client.Principal)
+	} else {
+		err = server.RemoveReaction(id, emoji, client.Principal)
+	}
+	if err != nil {
+		server.SendError(req.ConnID, CodeOf(err, response.ErrorCode_InvalidArgument), err.Error())
+		return
+	}
+
+	res := response.Response{ResType: response.ResponseType_Reactions, SenderName: client.Username, ID: id, Content: response.FormatReactions(server.Reactions[id].Counts())}
+	server.BroadcastRoom(entry.Room, res)
+}
+
+// Records a user's reaction to a message; users are known by principal, so a reused guest name starts afresh
+// Called on the event loop
+func (server *Server) AddReaction(id uint64, emoji string, principal string) error {
+	reactions, tracked := server.Reactions[id]
+	for i, reaction := range reactions {
+		if reaction.Emoji != emoji {
+			continue
+		}
+		for _, user := range reaction.Users {
+			if user == principal {
+				return &ServerError{Code: response.ErrorCode_InvalidArgument, Message: fmt.Sprintf("You already reacted to message %v with %v", id, emoji)}
+			}
+		}
+		reactions[i].Users = append(reactions[i].Users, principal)
+		return nil
+	}
+
+	if len(reactions) >= MaxReactionsPerMessage {
+		return &ServerError{Code: response.ErrorCode_InvalidArgument, Message: fmt.Sprintf("Message %v has too many different reactions", id)}
+	}
+	server.Reactions[id] = append(reactions, ReactionUsers{Emoji: emoji, Users: []string{principal}})
+
+	if !tracked && len(server.Reactions) > MaxReactedMessages {
+		server.forgetOldestReactions()
+	}
+	return nil
+}
+
+func (server *Server) RemoveReaction(id uint64, emoji string, principal string) error {
+	reactions := server.Reactions[id]
+	for i, reaction := range reactions {
+		if reaction.Emoji != emoji {
+			continue
+		}
+		for j, user := range reaction.Users {
+			if user != principal {
+				continue
+			}
+
+			reactions[i].Users = append(reaction.Users[:j], reaction.Users[j+1:]...)
+			if len(reactions[i].Users) == 0 {
+				reactions = append(reactions[:i], reactions[i+1:]...)
+			}
+			if len(reactions) == 0 {
+				delete(server.Reactions, id)
+			} else {
+				server.Reactions[id] = reactions
+			}
+			return nil
+		}
+	}
+
+	return &ServerError{Code: response.ErrorCode_InvalidArgument, Message: fmt.Sprintf("You have not reacted to message %v with %v", id, emoji)}
+}
+
+// Message IDs increase, so the smallest belong to the messages least likely to still be seen
+func (server *Server) forgetOldestReactions() {
+	ids := make([]uint64, 0, len(server.Reactions))
+	for id := range server.Reactions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids[:len(ids)-MaxReactedMessages] {
+		delete(server.Reactions, id)
+	}
+}
+
+// Sends the reaction counts of a replayed message, if it has any
+func (server *Server) SendReactions(connID ConnID, id uint64) {
+	reactions, ok := server.Reactions[id]
+	if ok {
+		server.SendTo(connID, response.Response{ResType: response.ResponseType_Reactions, ID: id, Content: response.FormatReactions(reactions.Counts())})
+	}
+}
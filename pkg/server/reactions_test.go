@@ -0,0 +1,152 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+func TestParseReaction(t *testing.T) {
+	valid := []struct {
+		arg      string
+		expected string
+	}{
+		{":+1:", "👍"},
+		{":TADA:", "🎉"},
+		{"👍", "👍"},
+		{"❤️", "❤️"},
+		{"1️⃣", "1️⃣"},
+		{"👍🏽", "👍🏽"},
+		{"👨\u200d👩\u200d👧", "👨\u200d👩\u200d👧"},
+		{"🏴\U000e0067\U000e0062\U000e0073\U000e0063\U000e0074\U000e007f", "🏴\U000e0067\U000e0062\U000e0073\U000e0063\U000e0074\U000e007f"},
+	}
+	for _, test := range valid {
+		emoji, err := ParseReaction(test.arg)
+		if err != nil || emoji != test.expected {
+			t.Errorf("Parsed %q as %q, %v; expected %q", test.arg, emoji, err, test.expected)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"ok",
+		"+1",
+		":nope:",
+		"👍 👍",
+		"日本",
+		"é",
+		"Ωmega",
+		"👍\u200b",
+		"\u200b\u200b",
+		"👍\u202e",
+		"👍\x07",
+		"🎉🎉🎉🎉🎉🎉🎉🎉🎉",
+	}
+	for _, arg := range invalid {
+		_, err := ParseReaction(arg)
+		if CodeOf(err, response.ErrorCode_Internal) != response.ErrorCode_InvalidArgument {
+			t.Errorf("Parsing %q returned %v", arg, err)
+		}
+	}
+}
+
+func TestAddRemoveReaction(t *testing.T) {
+	server := &Server{Reactions: make(map[uint64]MessageReactions)}
+
+	for _, step := range []struct {
+		emoji     string
+		principal string
+	}{
+		{"👍", "account:alice"},
+		{"🎉", "account:alice"},
+		{"👍", "guest:bob"},
+	} {
+		err := server.AddReaction(1, step.emoji, step.principal)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []response.Reaction{{Emoji: "👍", Count: 2}, {Emoji: "🎉", Count: 1}}
+	if counts := server.Reactions[1].Counts(); !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Counts are %+v, expected %+v", counts, expected)
+	}
+
+	err := server.AddReaction(1, "👍", "account:alice")
+	if err == nil {
+		t.Error("Reacted twice with the same emoji")
+	}
+
+	err = server.RemoveReaction(1, "🎉", "guest:bob")
+	if err == nil {
+		t.Error("Removed a reaction that was never added")
+	}
+
+	// An emoji nobody is left reacting with is dropped, and so is a message left without reactions
+	err = server.RemoveReaction(1, "🎉", "account:alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []response.Reaction{{Emoji: "👍", Count: 2}}
+	if counts := server.Reactions[1].Counts(); !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Counts are %+v, expected %+v", counts, expected)
+	}
+
+	server.RemoveReaction(1, "👍", "account:alice")
+	server.RemoveReaction(1, "👍", "guest:bob")
+	if _, ok := server.Reactions[1]; ok {
+		t.Errorf("Message without reactions is still tracked: %+v", server.Reactions[1])
+	}
+}
+
+func TestReactionLimits(t *testing.T) {
+	server := &Server{Reactions: make(map[uint64]MessageReactions)}
+
+	for i := 0; i < MaxReactionsPerMessage; i++ {
+		err := server.AddReaction(1, string(rune(0x1f600+i)), "account:alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := server.AddReaction(1, "👍", "account:alice")
+	if err == nil {
+		t.Errorf("Added more than %v different reactions", MaxReactionsPerMessage)
+	}
+
+	// Existing reactions can still be joined
+	err = server.AddReaction(1, string(rune(0x1f600)), "guest:bob")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// Only the reactions of the most recent messages are kept, by message ID
+func TestForgetOldestReactions(t *testing.T) {
+	server := &Server{Reactions: make(map[uint64]MessageReactions)}
+
+	for id := uint64(1); id <= MaxReactedMessages+5; id++ {
+		err := server.AddReaction(id, "👍", "account:alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(server.Reactions) != MaxReactedMessages {
+		t.Errorf("Tracking reactions to %v messages, expected %v", len(server.Reactions), MaxReactedMessages)
+	}
+	for id := uint64(1); id <= 5; id++ {
+		if _, ok := server.Reactions[id]; ok {
+			t.Errorf("Reactions to message %v were kept", id)
+		}
+	}
+	if _, ok := server.Reactions[MaxReactedMessages+5]; !ok {
+		t.Error("Reactions to the newest message were forgotten")
+	}
+
+	// Reacting again to a message that is tracked forgets nothing
+	server.AddReaction(MaxReactedMessages+5, "🎉", "account:alice")
+	if _, ok := server.Reactions[6]; !ok {
+		t.Error("Reactions were forgotten when a tracked message was reacted to")
+	}
+}
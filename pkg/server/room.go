@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+const (
+	// Every registered user starts in the default room, and it is never removed
+	DefaultRoom = "lobby"
+
+	MaxRoomNameLength = 24
+)
+
+type Room struct {
+	Name string
+
+	// Connection IDs of the users currently in the room
+	Members map[ConnID]bool
+}
+
+func NewRoom(name string) *Room {
+	return &Room{Name: name, Members: make(map[ConnID]bool)}
+}
+
+// Room names are case-insensitive and may be written with a leading #
+func NormalizeRoomName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimPrefix(name, "#"))
+
+	if name == "" {
+		return "", &ServerError{Code: response.ErrorCode_InvalidArgument, Message: "Room name cannot be empty"}
+	}
+	if len(name) > MaxRoomNameLength {
+		return "", &ServerError{Code: response.ErrorCode_InvalidArgument, Message: fmt.Sprintf("Room name must be %v characters or less", MaxRoomNameLength)}
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return "", &ServerError{Code: response.ErrorCode_InvalidArgument, Message: "Room name may only contain letters, digits, - and _"}
+		}
+	}
+
+	return name, nil
+}
+
+func IsRoomCommand(cmd request.CommandType) bool {
+	return cmd == request.Command_Join || cmd == request.Command_Leave || cmd == request.Command_Rooms
+}
+
+// Moves a registered client into a room, announcing the move to both the old and new rooms
+func (server *Server) JoinRoom(id ConnID, name string) error {
+	client := server.FindClient(id)
+	if client == nil {
+		return &ServerError{Message: "Client does not exist"}
+	}
+
+	name, err := NormalizeRoomName(name)
+	if err != nil {
+		return err
+	}
+
+	if client.Room == name {
+		return &ServerError{Code: response.ErrorCode_InvalidArgument, Message: fmt.Sprintf("You are already in #%v", name)}
+	}
+
+	if client.Room != "" {
+		server.RemoveFromRoom(client.ID, client.Room)
+		server.BroadcastRoom(client.Room, response.Response{ResType: response.ResponseType_ServerRoom, Content: fmt.Sprintf("%v has left #%v", client.Username, client.Room)})
+	}
+
+	server.AddToRoom(client, name)
+
+	server.BroadcastRoom(name, response.Response{ResType: response.ResponseType_ServerRoom, Content: fmt.Sprintf("%v has joined #%v", client.Username, name)})
+
+	return nil
+}
+
+// Places a client in a room without any announcement, creating the room if needed
+func (server *Server) AddToRoom(client *ClientConn, name string) {
+	room, ok := server.Rooms[name]
+	if !ok {
+		room = NewRoom(name)
+		server.Rooms[name] = room
+		server.Log.Printf("Created room #%v\n", name)
+	}
+
+	client.Room = name
+	room.Members[client.ID] = true
+}
+
+// Removes a client from a room's member set, discarding the room once it is empty
+func (server *Server) RemoveFromRoom(id ConnID, name string) {
+	room, ok := server.Rooms[name]
+	if !ok {
+		return
+	}
+
+	delete(room.Members, id)
+
+	if len(room.Members) == 0 && room.Name != DefaultRoom {
+		delete(server.Rooms, name)
+		server.Log.Printf("Removed empty room #%v\n", name)
+	}
+}
+
+// Describes every room as "#name (members)", sorted by name
+func (server *Server) ListRooms() string {
+	names := make([]string, 0, len(server.Rooms))
+	for name := range server.Rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]string, len(names))
+	for i, name := range names {
+		entries[i] = fmt.Sprintf("#%v (%v)", name, len(server.Rooms[name].Members))
+	}
+
+	return "Rooms: " + strings.Join(entries, ", ")
+}
+
+// Sends a response to every member of a room
+func (server *Server) BroadcastRoom(name string, res response.Response) {
+	room, ok := server.Rooms[name]
+	if !ok {
+		return
+	}
+
+	server.Stamp(&res)
+	for id := range room.Members {
+		client, ok := server.Connections[id]
+		if ok && !server.Suppressed(client, res) {
+			server.Deliver(client, res)
+		}
+	}
+}
+
+func (server *Server) HandleRoomCommand(req request.Request) {
+	res := response.Response{ResType: response.ResponseType_ServerPriv, SenderName: req.SenderName, ReceiverName: req.SenderName}
+
+	switch req.CmdType {
+	case request.Command_Join:
+		err := server.JoinRoom(req.ConnID, req.Content)
+		if err != nil {
+			server.SendError(req.ConnID, CodeOf(err, response.ErrorCode_Internal), err.Error())
+		}
+		break
+	case request.Command_Leave:
+		err := server.JoinRoom(req.ConnID, DefaultRoom)
+		if err != nil {
+			server.SendError(req.ConnID, CodeOf(err, response.ErrorCode_Internal), err.Error())
+		}
+		break
+	case request.Command_Rooms:
+		res.Content = server.ListRooms()
+		server.SendResponse(res, req.ConnID)
+		break
+	}
+}
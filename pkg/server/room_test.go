@@ -0,0 +1,99 @@
+package server
+
+import (
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+func TestNormalizeRoomName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		valid    bool
+	}{
+		{"games", "games", true},
+		{"#Games", "games", true},
+		{"dev-ops_2", "dev-ops_2", true},
+		{"café", "café", true},
+		{"", "", false},
+		{"#", "", false},
+		{"two words", "", false},
+		{"a.b", "", false},
+		{strings.Repeat("a", MaxRoomNameLength), strings.Repeat("a", MaxRoomNameLength), true},
+		{strings.Repeat("a", MaxRoomNameLength+1), "", false},
+	}
+
+	for _, test := range tests {
+		name, err := NormalizeRoomName(test.name)
+		if test.valid && (err != nil || name != test.expected) {
+			t.Errorf("NormalizeRoomName(%q) = %q, %v; expected %q", test.name, name, err, test.expected)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("NormalizeRoomName(%q) = %q; expected an error", test.name, name)
+		}
+	}
+}
+
+func isServerNotice(content string) func(response.Response) bool {
+	return func(res response.Response) bool {
+		return res.ResType == response.ResponseType_ServerPriv && res.Content == content
+	}
+}
+
+// Clients move between rooms with /join and /leave, and messages only reach the sender's room
+func TestRooms(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0)}
+	addr := startTestServer(t, server)
+
+	alice, err := dialTestClient(addr, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.conn.Close()
+	alice.register(t)
+	alice.await(t, isRoomNotice("alice has connected"))
+
+	bob, err := dialTestClient(addr, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.conn.Close()
+	bob.register(t)
+	bob.await(t, isRoomNotice("bob has connected"))
+
+	alice.send(t, request.Parse("/join #Games"))
+	alice.await(t, isRoomNotice("alice has joined #games"))
+	bob.await(t, isRoomNotice("alice has left #lobby"))
+
+	alice.send(t, request.Parse("/join games"))
+	alice.await(t, func(res response.Response) bool {
+		return res.ResType == response.ResponseType_Error && res.Code == response.ErrorCode_InvalidArgument
+	})
+
+	bob.send(t, request.Parse("/rooms"))
+	bob.await(t, isServerNotice("Rooms: #games (1), #lobby (1)"))
+
+	// Bob's own message arrives without alice's, which was only sent to #games
+	alice.send(t, request.Request{ReqType: request.RequestType_Message, Content: "in games"})
+	alice.await(t, isMessage("alice", "in games"))
+	bob.send(t, request.Request{ReqType: request.RequestType_Message, Content: "in lobby"})
+	bob.await(t, func(res response.Response) bool {
+		if isMessage("alice", "in games")(res) {
+			t.Error("A message sent to #games reached #lobby")
+		}
+		return isMessage("bob", "in lobby")(res)
+	})
+
+	// The room is removed once its last member leaves
+	alice.send(t, request.Parse("/leave"))
+	alice.await(t, isRoomNotice("alice has joined #lobby"))
+	bob.await(t, isRoomNotice("alice has joined #lobby"))
+
+	bob.send(t, request.Parse("/rooms"))
+	bob.await(t, isServerNotice("Rooms: #lobby (2)"))
+}
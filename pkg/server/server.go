@@ -1,15 +1,28 @@
 package server
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
-
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/account"
+	"github.com/edobrowo/gochatroom/pkg/ban"
+	"github.com/edobrowo/gochatroom/pkg/codec"
+	"github.com/edobrowo/gochatroom/pkg/mailbox"
+	"github.com/edobrowo/gochatroom/pkg/protocol"
 	"github.com/edobrowo/gochatroom/pkg/request"
 	"github.com/edobrowo/gochatroom/pkg/response"
+	"github.com/edobrowo/gochatroom/pkg/validation"
 )
 
 type ServerError struct {
+	// Sent to the client along with the message when the error is reported back to it
+	Code    response.ErrorCode
 	Message string
 }
 
@@ -17,6 +30,15 @@ func (err *ServerError) Error() string {
 	return err.Message
 }
 
+// The code to report a failure with; errors that do not carry one are reported with the fallback
+func CodeOf(err error, fallback response.ErrorCode) response.ErrorCode {
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) && serverErr.Code != response.ErrorCode_None {
+		return serverErr.Code
+	}
+	return fallback
+}
+
 func TCPJoinHostPort(addr net.TCPAddr) string {
 	return fmt.Sprintf("%v:%v", addr.IP, addr.Port)
 }
@@ -31,33 +53,179 @@ const (
 	Unknown
 )
 
+var serverStatusNames = map[ServerStatusCode]string{
+	Idle:       "idle",
+	Listening:  "listening",
+	Closing:    "closing",
+	ErrorState: "error",
+	Unknown:    "unknown",
+}
+
+func (code ServerStatusCode) String() string {
+	name, ok := serverStatusNames[code]
+	if !ok {
+		return "unknown"
+	}
+	return name
+}
+
 type ServerStatus struct {
 	Code  ServerStatusCode
 	Error error
 }
 
+// Identifies a connection for the lifetime of the server; unlike addresses, IDs are never reused
+type ConnID = uint64
+
 type ClientConn struct {
+	ID            ConnID
 	Connection    net.Conn
+	Codec         codec.Codec
 	ClientAddr    string
 	Username      string
+	Room          string
+	Authenticated bool
+	SessionToken  string
+	Operator      bool
 	ResponseQueue chan response.Response
+
+	// Owns the messages and reactions the client sends; see NewPrincipal
+	Principal string
+
+	// Set by /away: the client is only sent room messages that mention it, and Missed counts the rest
+	Away   bool
+	Missed int
+
+	// Set while a password for this connection is being hashed off the event loop, and to the username whose password was then accepted
+	LoginPending  bool
+	VerifiedLogin string
+
+	// Version and features agreed in the hello exchange
+	Protocol protocol.Hello
+
+	// When the connection was accepted
+	Connected time.Time
+
+	// Rate limits requests as they are received; nil when rate limiting is disabled
+	Flood   *FloodGuard
+	Metrics *ServerMetrics
+
+	// How long Send may block on a single write, and the responses dropped because the queue was full
+	WriteTimeout time.Duration
+	Dropped      int
+	Overflowed   bool
+
+	// Closed when the Send goroutine exits
+	Finished chan struct{}
 }
 
+// Connections, rooms, sessions and mutes are owned by the EventLoop goroutine
+// Other goroutines reach them only through the loop's channels, so none of them need locking
 type Server struct {
 	ServerAddr  net.TCPAddr
 	Listener    net.Listener
-	Connections []ClientConn
+	Connections map[ConnID]*ClientConn
+	Rooms       map[string]*Room
+	History     HistoryStore
+	Accounts    *account.Store
+	Mailbox     *mailbox.Store
+	TLSConfig   *tls.Config
 	Reqs        chan request.Request
 	Status      chan ServerStatus
 	Done        chan ServerStatus
-	ClientDone  chan string
+	ClientDone  chan ConnID
 	Log         *log.Logger
+
+	// Connections that completed the hello exchange, and functions run on the event loop by Do
+	Accepted chan *ClientConn
+	Actions  chan func()
+	nextID   ConnID
+
+	// Hands out the IDs of broadcasts and whispers; defaults to keeping them in memory only
+	MessageIDs *MessageIDs
+
+	// Number of messages replayed to a user when they register
+	HistoryReplay int
+
+	// Maximum simultaneous connections and chat message length; 0 is unlimited
+	MaxClients       int
+	MaxMessageLength int
+
+	// Maximum simultaneous connections from one address; 0 is unlimited
+	// Open connections are counted by host from when they are accepted
+	MaxConnectionsPerIP int
+	hosts               hostCounter
+
+	// Capacity of each client's outbound queue, what happens when it is full, and how long a write may take
+	QueueSize    int
+	Overflow     OverflowPolicy
+	WriteTimeout time.Duration
+
+	// Request rate limits and escalation thresholds, and the channel Receive goroutines report offenders on
+	Flood      FloodPolicy
+	Violations chan FloodViolation
+
+	// Message of the day, sent to each user when they register
+	MOTD string
+
+	// How long clients are given to receive the shutdown notice
+	ShutdownTimeout time.Duration
+
+	// Usernames held for dropped clients, and for how long
+	Reservations map[string]*Reservation
+	SessionGrace time.Duration
+
+	// Rules usernames must follow when registering
+	UsernamePolicy validation.Policy
+
+	// Address of the WebSocket gateway for browsers, empty to disable it, and other sites whose pages may connect
+	GatewayAddr     string
+	GatewayOrigins  []string
+	GatewayListener net.Listener
+
+	// Address of the admin API, empty to disable it, and the bearer token its requests must carry
+	AdminAddr     string
+	AdminToken    string
+	AdminListener net.Listener
+
+	// Load metrics, and the address they are served on for scraping, empty to disable it
+	Metrics         *ServerMetrics
+	MetricsAddr     string
+	MetricsListener net.Listener
+
+	// When the server began listening, and the last status reported to Monitor
+	Started time.Time
+	status  atomic.Pointer[ServerStatus]
+
+	// Optional protocol features offered to clients; Listen defaults it to every supported feature
+	Features protocol.Features
+
+	// Usernames granted the operator role when they log in with a password
+	Operators map[string]bool
+
+	// Persisted bans, and muted usernames with when the mute ends (zero for indefinitely)
+	Bans  *ban.List
+	Mutes map[string]time.Time
+
+	// Reactions to retained messages, by message ID; they are not persisted
+	Reactions map[uint64]MessageReactions
+
+	// Recent failed logins by username and by address, and the slots that bound how many passwords are hashed at once
+	failedLogins map[string]*loginFailures
+	hashSlots    chan struct{}
+
+	// Set once by Shutdown, and read by the HTTP handlers as well as the event loop; the server is closing once it holds a reason
+	shutdown    sync.Once
+	closeReason atomic.Pointer[string]
 }
 
 // Controls the server state machine
 func (server *Server) Monitor() {
 	for {
-		switch statusVal := <-server.Status; statusVal.Code {
+		statusVal := <-server.Status
+		server.status.Store(&statusVal)
+
+		switch statusVal.Code {
 		case Idle:
 			server.Log.Println("Server created, idling")
 		case Listening:
@@ -78,6 +246,15 @@ func (server *Server) Monitor() {
 	}
 }
 
+// The status last reported to Monitor; safe to call from any goroutine
+func (server *Server) CurrentStatus() ServerStatus {
+	status := server.status.Load()
+	if status == nil {
+		return ServerStatus{Code: Unknown}
+	}
+	return *status
+}
+
 // Cleans up and re-initializes server resources; used on boot or if the address is changed
 func (server *Server) Reset() error {
 	for _, client := range server.Connections {
@@ -88,7 +265,15 @@ func (server *Server) Reset() error {
 			return err
 		}
 	}
-	server.Connections = make([]ClientConn, 0)
+	server.Connections = make(map[ConnID]*ClientConn)
+
+	server.Rooms = make(map[string]*Room)
+	server.Rooms[DefaultRoom] = NewRoom(DefaultRoom)
+
+	server.Reservations = make(map[string]*Reservation)
+	server.Mutes = make(map[string]time.Time)
+	server.Reactions = make(map[uint64]MessageReactions)
+	server.failedLogins = make(map[string]*loginFailures)
 
 	if server.Reqs != nil {
 		close(server.Reqs)
@@ -102,23 +287,50 @@ func (server *Server) Reset() error {
 func (server *Server) Close() error {
 	server.Log.Println("Closing server")
 
-	close(server.Status)
-	close(server.Done)
-	close(server.Reqs)
-
-	for _, client := range server.Connections {
-		close(client.ResponseQueue)
+	// Channels are left open, since client goroutines may still be blocked sending on them
+	for _, client := range server.Clients() {
 		err := client.Connection.Close()
 		if err != nil {
 			return err
 		}
 	}
 
-	err := server.Listener.Close()
+	err := server.History.Close()
 	if err != nil {
 		return err
 	}
 
+	err = server.Mailbox.Close()
+	if err != nil {
+		return err
+	}
+
+	err = server.Listener.Close()
+	if err != nil {
+		return err
+	}
+
+	if server.GatewayListener != nil {
+		err = server.GatewayListener.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if server.AdminListener != nil {
+		err = server.AdminListener.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if server.MetricsListener != nil {
+		err = server.MetricsListener.Close()
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -140,8 +352,69 @@ func (server *Server) Listen(addr net.TCPAddr) {
 	// Tells the server to close a client connection
 	if server.ClientDone == nil {
 		server.Log.Println("Creating client close channel")
-		server.ClientDone = make(chan string)
-		go server.RemoveClient()
+		server.ClientDone = make(chan ConnID)
+	}
+
+	// Feed new connections and queued work to the event loop
+	if server.Accepted == nil {
+		server.Accepted = make(chan *ClientConn)
+	}
+	if server.Actions == nil {
+		server.Actions = make(chan func())
+	}
+	if server.hashSlots == nil {
+		server.hashSlots = make(chan struct{}, MaxConcurrentHashes)
+	}
+
+	// Message history defaults to an in-memory store
+	if server.History == nil {
+		server.History = NewMemoryHistory(DefaultHistoryCapacity)
+	}
+	if server.HistoryReplay == 0 {
+		server.HistoryReplay = DefaultHistoryReplay
+	}
+
+	// IDs continue from the saved reservation or the history log, whichever is further along
+	if server.MessageIDs == nil {
+		server.MessageIDs = NewMessageIDs()
+	}
+	server.MessageIDs.Seed(server.History.LastMessageID())
+
+	// Receive goroutines report clients that exceed the rate limit
+	if server.Violations == nil {
+		server.Violations = make(chan FloodViolation)
+	}
+
+	// A policy that sets any field is used as given, so a zero MaxLength can still mean no limit
+	// The server binary always builds the policy from its configuration, whose defaults are DefaultPolicy's; this covers servers built in code
+	if server.UsernamePolicy.IsZero() {
+		server.UsernamePolicy = validation.DefaultPolicy
+	}
+
+	if server.Features == 0 {
+		server.Features = protocol.Supported
+	}
+
+	if server.QueueSize <= 0 {
+		server.QueueSize = DefaultQueueSize
+	}
+	if server.WriteTimeout <= 0 {
+		server.WriteTimeout = DefaultWriteTimeout
+	}
+
+	// Bans are kept in memory only unless a file-backed list is provided
+	if server.Bans == nil {
+		server.Bans = ban.NewList()
+	}
+
+	// Whispers to offline users are held in memory only unless a file-backed mailbox is provided
+	if server.Mailbox == nil {
+		server.Mailbox = mailbox.NewStore()
+	}
+
+	// Metrics are always collected, even when they are not served
+	if server.Metrics == nil {
+		server.Metrics = NewServerMetrics()
 	}
 
 	server.Status <- ServerStatus{Code: Idle}
@@ -161,20 +434,61 @@ func (server *Server) Listen(addr net.TCPAddr) {
 		server.Status <- ServerStatus{Code: ErrorState, Error: err}
 		return
 	}
+
+	// Connections are wrapped in TLS when configured; the handshake runs on each client's first read
+	if server.TLSConfig != nil {
+		listener = tls.NewListener(listener, server.TLSConfig)
+		server.Log.Println("TLS enabled")
+	}
 	server.Listener = listener
+	server.Started = time.Now()
 	server.Status <- ServerStatus{Code: Listening}
 
+	// Browsers join through the gateway, whose connections are handed to the same event loop
+	if server.GatewayAddr != "" {
+		err = server.StartGateway()
+		if err != nil {
+			server.Log.Fatalln("Gateway could not be started: ", err)
+			server.Status <- ServerStatus{Code: ErrorState, Error: err}
+			return
+		}
+	}
+
+	if server.MetricsAddr != "" {
+		err = server.StartMetrics()
+		if err != nil {
+			server.Log.Fatalln("Metrics endpoint could not be started: ", err)
+			server.Status <- ServerStatus{Code: ErrorState, Error: err}
+			return
+		}
+	}
+
+	// The admin API only reaches server state through Do, which waits for the event loop started below
+	if server.AdminAddr != "" {
+		err = server.StartAdmin()
+		if err != nil {
+			server.Log.Fatalln("Admin API could not be started: ", err)
+			server.Status <- ServerStatus{Code: ErrorState, Error: err}
+			return
+		}
+	}
+
 	// Used to add new client connections to the server
 	go server.AcceptClients()
 
-	// Central goroutine to handle all requests
-	go server.HandleRequests()
+	// Central goroutine that owns all server state
+	go server.EventLoop()
 
 	result := <-server.Done
 	if result.Code == ErrorState {
 		server.Log.Fatalln("Closing server: ", result.Error)
 	}
 
+	// Tell clients why they are being disconnected before the connections are closed
+	if result.Code == Closing {
+		server.NotifyClients(server.CloseReason())
+	}
+
 	err = server.Close()
 	if err != nil {
 		server.Log.Fatalln("Server close failure: ", err)
@@ -184,25 +498,82 @@ func (server *Server) Listen(addr net.TCPAddr) {
 func (server *Server) AcceptClients() {
 	for {
 		connection, err := server.Listener.Accept()
+		if err != nil && server.IsClosing() {
+			return
+		}
 		if err != nil {
 			server.Log.Fatalln("Listener accept failure: ", err)
 			server.Status <- ServerStatus{Code: ErrorState, Error: err}
 			return
 		}
 
-		err = server.AddClient(connection)
+		// Banned and over-limit addresses are turned away before a handshake is started for them
+		addr := connection.RemoteAddr().String()
+		err = server.Admit(addr)
 		if err != nil {
-			server.Log.Fatalln("Add client failure: ", err)
-			server.Status <- ServerStatus{Code: ErrorState, Error: err}
-			return
+			go server.Refuse(connection, err.(*ServerError))
+			continue
 		}
+
+		go server.Greet(server.Track(connection, addr))
 	}
 }
 
+// The only goroutine that reads or changes connections, rooms and sessions
+func (server *Server) EventLoop() {
+	for {
+		select {
+		case client := <-server.Accepted:
+			err := server.AddClient(client)
+			if err != nil {
+				server.Log.Println("Add client failure: ", err)
+				client.Connection.Close()
+			}
+		case id := <-server.ClientDone:
+			server.RemoveClient(id)
+		case violation := <-server.Violations:
+			if !server.IsClosing() {
+				server.HandleFloodViolation(violation)
+			}
+		case req := <-server.Reqs:
+			server.HandleRequest(req)
+		case action := <-server.Actions:
+			action()
+		}
+	}
+}
+
+// Runs a function on the event loop and waits for it to finish; must not be called from the event loop itself
+func (server *Server) Do(action func()) {
+	finished := make(chan struct{})
+	server.Actions <- func() {
+		action()
+		close(finished)
+	}
+	<-finished
+}
+
+// Queues a function to run on the event loop without waiting for it; used by goroutines that finish work started on the loop
+func (server *Server) Post(action func()) {
+	server.Actions <- action
+}
+
+// Returns the current connections; safe to call from any goroutine other than the event loop
+func (server *Server) Clients() []*ClientConn {
+	var clients []*ClientConn
+	server.Do(func() {
+		for _, client := range server.Connections {
+			clients = append(clients, client)
+		}
+	})
+	return clients
+}
+
 func BuildMessageResponse(req request.Request) response.Response {
 	res := response.Response{}
 	res.SenderName = req.SenderName
 	res.Content = req.Content
+	res.ParentID = req.ParentID
 	return res
 }
 
@@ -222,8 +593,9 @@ func BuildCommandResponse(req request.Request) response.Response {
 		res.Content = "Pong!"
 		break
 	default:
-		res.ResType = response.ResponseType_ServerPriv
+		res.ResType = response.ResponseType_Error
 		res.ReceiverName = req.SenderName
+		res.Code = response.ErrorCode_UnknownCommand
 		res.Content = "Unknown command"
 		break
 	}
@@ -237,12 +609,13 @@ func BuildStatusResponse(req request.Request) response.Response {
 
 	switch req.StType {
 	case request.Status_Register:
-		res.ResType = response.ResponseType_ServerAll
+		res.ResType = response.ResponseType_ServerRoom
 		res.Content = fmt.Sprintf("%v has connected", req.SenderName)
 		break
 	default:
-		res.ResType = response.ResponseType_ServerPriv
+		res.ResType = response.ResponseType_Error
 		res.ReceiverName = req.SenderName
+		res.Code = response.ErrorCode_UnknownCommand
 		res.Content = "Unknown command"
 		break
 	}
@@ -265,8 +638,9 @@ func BuildResponse(req request.Request) response.Response {
 		res = BuildStatusResponse(req)
 		break
 	default:
-		res.ResType = response.ResponseType_ServerPriv
+		res.ResType = response.ResponseType_Error
 		res.ReceiverName = req.SenderName
+		res.Code = response.ErrorCode_MalformedRequest
 		res.Content = "Invalid request"
 		break
 	}
@@ -274,173 +648,449 @@ func BuildResponse(req request.Request) response.Response {
 	return res
 }
 
-func (server *Server) SendResponse(res response.Response, addr string) {
+// Assigns a broadcast or whisper the next message ID and the current time, unless it already has an ID; called on the event loop
+func (server *Server) Stamp(res *response.Response) {
+	if res.ID != 0 {
+		return
+	}
+	id, err := server.MessageIDs.Next()
+	if err != nil {
+		server.Log.Printf("Could not save message ID reservation (id = %v, error = %v)\n", id, err)
+	}
+	res.ID = id
+	res.Time = time.Now().UTC()
+}
+
+// Finds the connection with the given ID, or nil if it has disconnected
+func (server *Server) FindClient(id ConnID) *ClientConn {
+	return server.Connections[id]
+}
+
+// Sends a response to a single client regardless of its type
+func (server *Server) SendTo(id ConnID, res response.Response) {
+	client := server.FindClient(id)
+	if client != nil {
+		server.Deliver(client, res)
+	}
+}
+
+// Reports a failed request to a single client
+func (server *Server) SendError(id ConnID, code response.ErrorCode, content string) {
+	server.SendTo(id, response.Error(code, content))
+}
+
+func (server *Server) SendResponse(res response.Response, id ConnID) {
 	// Send only to the requesting user
-	if res.ResType == response.ResponseType_ServerPriv || res.ResType == response.ResponseType_TerminateConnection {
-		for _, client := range server.Connections {
-			if client.ClientAddr == addr {
-				client.ResponseQueue <- res
-			}
-		}
+	if res.ResType == response.ResponseType_ServerPriv || res.ResType == response.ResponseType_Error || res.ResType == response.ResponseType_TerminateConnection {
+		server.SendTo(id, res)
 		return
 	}
 
 	// Send only to the sending user, and to receiving user if valid
 	if res.ResType == response.ResponseType_Whisper {
-		var sender, receiver ClientConn
-		for i, client := range server.Connections {
-			if client.Username == res.SenderName {
-				sender = server.Connections[i]
-			}
-			if client.Username == res.ReceiverName {
-				receiver = server.Connections[i]
-			}
+		server.Stamp(&res)
+		sender := server.FindClient(id)
+		receiver := server.FindUser(res.ReceiverName)
+		if sender == nil {
+			return
 		}
 
-		if receiver.Username == "" {
-			res.ResType = response.ResponseType_ServerPriv
+		if receiver == nil || res.ReceiverName == "" {
+			// Registered users are sent whispers when they next log in
+			if res.ReceiverName != "" && server.HoldWhisper(sender, res) {
+				return
+			}
+
+			res.ResType = response.ResponseType_Error
+			res.Code = response.ErrorCode_NoSuchUser
 			res.Content = fmt.Sprintf("User %v does not exist", res.ReceiverName)
 
 			// Just send to sender since receiver is invalid
-			sender.ResponseQueue <- res
+			server.Deliver(sender, res)
 		} else {
-			sender.ResponseQueue <- res
-			receiver.ResponseQueue <- res
+			server.Deliver(sender, res)
+			server.Deliver(receiver, res)
 		}
 
 		return
 	}
 
-	// Otherwise send to all users (in the case of ResponseType_Message and ResponseType_ServerAll)
+	// Send to everyone in the sending user's room
+	if res.ResType == response.ResponseType_Message || res.ResType == response.ResponseType_ServerRoom {
+		sender := server.FindClient(id)
+		if sender != nil {
+			server.BroadcastRoom(sender.Room, res)
+		}
+		return
+	}
+
+	// Otherwise send to all users (in the case of ResponseType_ServerAll)
+	server.Stamp(&res)
 	for _, client := range server.Connections {
-		client.ResponseQueue <- res
+		server.Deliver(client, res)
 	}
 }
 
-func (server *Server) HandleRequests() {
-	for {
-		req := <-server.Reqs
-
-		server.Log.Printf("request: type %v from %v", req.ReqType, req.SenderName)
-
-		res := BuildResponse(req)
-
-		// If the user is registering, enforce username uniqueness, then find their connection and set the username field
-		if req.ReqType == request.RequestType_Status && req.StType == request.Status_Register {
-			usernameExists := false
-			for _, cc := range server.Connections {
-				if cc.Username == req.SenderName {
-					res.ResType = response.ResponseType_TerminateConnection
-					server.SendResponse(res, req.ClientAddr)
-					usernameExists = true
-					break
-				}
+// Handles a single request on the event loop
+func (server *Server) HandleRequest(req request.Request) {
+	// Requests are still drained while closing so that Receive goroutines never block
+	if server.IsClosing() {
+		return
+	}
+
+	// Requests the connection may not send are dropped before they are counted, so they cannot add metric labels
+	client := server.FindClient(req.ConnID)
+	if !MaySend(client, req) {
+		return
+	}
+
+	server.Log.Printf("request: type %v from %v", req.ReqType, req.SenderName)
+	defer server.Metrics.ObserveRequest(req, time.Now())
+
+	server.handleRequest(req)
+}
+
+// Apart from registration, requests are only accepted from registered users
+func MaySend(client *ClientConn, req request.Request) bool {
+	if client == nil {
+		return false
+	}
+	if req.ReqType == request.RequestType_Status {
+		return client.Username == ""
+	}
+	return client.Username != ""
+}
+
+// Registrations whose password was checked off the event loop are handled again from here, so they are only counted once
+func (server *Server) handleRequest(req request.Request) {
+	// Requests are handled under the name the client registered with
+	client := server.FindClient(req.ConnID)
+	if !MaySend(client, req) {
+		return
+	}
+	if req.ReqType != request.RequestType_Status {
+		req.SenderName = client.Username
+	}
+
+	if req.ReqType == request.RequestType_Status && req.StType == request.Status_Resume {
+		server.HandleResume(req)
+		return
+	}
+
+	// Custom clients can send any name, so the username policy is enforced here rather than trusted to the client
+	if req.ReqType == request.RequestType_Status && req.StType == request.Status_Register {
+		name, err := server.ValidateUsername(req.SenderName)
+		if err != nil {
+			server.Log.Printf("Rejected username (address = %v): %v\n", req.ClientAddr, err)
+			server.Metrics.RegistrationFailures.With(response.ErrorCode_InvalidUsername.String()).Inc()
+			server.SendTo(req.ConnID, response.Response{ResType: response.ResponseType_TerminateConnection, Code: response.ErrorCode_InvalidUsername, Content: err.Error()})
+			return
+		}
+		req.SenderName = name
+	}
+
+	// Room commands mutate membership, so they are handled by the room subsystem instead
+	if req.ReqType == request.RequestType_Command && IsRoomCommand(req.CmdType) {
+		server.HandleRoomCommand(req)
+		return
+	}
+
+	if req.ReqType == request.RequestType_Command && req.CmdType == request.Command_History {
+		server.HandleHistoryCommand(req)
+		return
+	}
+
+	if req.ReqType == request.RequestType_Command && req.CmdType == request.Command_Thread {
+		server.HandleThreadCommand(req)
+		return
+	}
+
+	if req.ReqType == request.RequestType_Command && req.CmdType == request.Command_Register {
+		server.HandleRegisterCommand(req)
+		return
+	}
+
+	if req.ReqType == request.RequestType_Command && IsModerationCommand(req.CmdType) {
+		server.HandleModerationCommand(req)
+		return
+	}
+
+	if req.ReqType == request.RequestType_Command && (req.CmdType == request.Command_Edit || req.CmdType == request.Command_Delete) {
+		server.HandleEditCommand(req)
+		return
+	}
+
+	if req.ReqType == request.RequestType_Command && (req.CmdType == request.Command_React || req.CmdType == request.Command_Unreact) {
+		server.HandleReactCommand(req)
+		return
+	}
+
+	if req.ReqType == request.RequestType_Command && (req.CmdType == request.Command_Away || req.CmdType == request.Command_Back) {
+		server.HandleAwayCommand(req)
+		return
+	}
+
+	// Replies are sent as messages that name the message they answer
+	if req.ReqType == request.RequestType_Command && req.CmdType == request.Command_Reply {
+		if req.ParentID == 0 || req.Content == "" {
+			server.SendError(req.ConnID, response.ErrorCode_InvalidArgument, "Usage: /reply <id> <text>")
+			return
+		}
+		req.ReqType = request.RequestType_Message
+		req.CmdType = 0
+	}
+	if req.ReqType == request.RequestType_Message && req.ParentID != 0 {
+		_, ok := server.FindInRoom(client, req.ParentID)
+		if !ok {
+			return
+		}
+	}
+
+	// Muted users can still use commands, but cannot talk
+	if req.ReqType == request.RequestType_Message || (req.ReqType == request.RequestType_Command && req.CmdType == request.Command_Whisper) {
+		if server.RefuseMuted(client) {
+			return
+		}
+	}
+
+	if server.MaxMessageLength > 0 && len(req.Content) > server.MaxMessageLength && (req.ReqType == request.RequestType_Message || (req.ReqType == request.RequestType_Command && req.CmdType == request.Command_Whisper)) {
+		server.SendError(req.ConnID, response.ErrorCode_MessageTooLong, fmt.Sprintf("Message is too long (maximum %v bytes)", server.MaxMessageLength))
+		return
+	}
+
+	res := BuildResponse(req)
+
+	// Mentions are read with the characters this server allows in usernames
+	if req.ReqType == request.RequestType_Message {
+		res.Mentions = ParseMentions(req.Content, server.UsernamePolicy)
+	}
+
+	// Messages are stamped before they are recorded, so replays carry the same ID and time
+	if res.ResType == response.ResponseType_Message || res.ResType == response.ResponseType_Whisper {
+		server.Stamp(&res)
+	}
+
+	// Messages are recorded in the history of the room they were sent to
+	if res.ResType == response.ResponseType_Message {
+		_, err := server.History.Append(client.Room, client.Principal, res)
+		if err != nil {
+			server.Log.Println("Could not record message in history: ", err)
+		}
+	}
+
+	// If the user is registering, enforce username uniqueness, then find their connection and set the username field
+	registered := false
+	if req.ReqType == request.RequestType_Status && req.StType == request.Status_Register {
+		usernameExists := false
+		for _, cc := range server.Connections {
+			if cc.Username == req.SenderName {
+				res.ResType = response.ResponseType_TerminateConnection
+				res.Code = response.ErrorCode_UsernameTaken
+				res.Content = fmt.Sprintf("Username %v is already in use", req.SenderName)
+				usernameExists = true
+				break
 			}
+		}
+
+		// Usernames of dropped clients are held for them until the grace period ends
+		if !usernameExists && server.FindReservation(req.SenderName) != nil {
+			res.ResType = response.ResponseType_TerminateConnection
+			res.Code = response.ErrorCode_UsernameTaken
+			res.Content = fmt.Sprintf("Username %v is reserved for a reconnecting user", req.SenderName)
+			usernameExists = true
+		}
 
-			if !usernameExists {
-				for i := range server.Connections {
-					if server.Connections[i].ClientAddr == req.ClientAddr {
-						server.Connections[i].Username = req.SenderName
-						server.Log.Printf("Registered user (username = %v, address = %v)\n", server.Connections[i].Username, server.Connections[i].ClientAddr)
-					}
-				}
+		if !usernameExists {
+			entry, banned := server.Bans.Find(ban.Kind_User, req.SenderName)
+			if banned {
+				res.ResType = response.ResponseType_TerminateConnection
+				res.Code = response.ErrorCode_Banned
+				res.Content = entry.Describe()
+				usernameExists = true
+				server.Log.Printf("Rejected banned user (username = %v, address = %v)\n", req.SenderName, req.ClientAddr)
 			}
 		}
 
-		server.SendResponse(res, req.ClientAddr)
+		// Registered usernames are reserved for connections that know the password
+		authenticated := false
+		if !usernameExists {
+			var pending bool
+			var err error
+			authenticated, pending, err = server.AuthenticateRegistration(client, req)
+			if pending {
+				return
+			}
+			if err != nil {
+				res.ResType = response.ResponseType_TerminateConnection
+				res.Code = response.ErrorCode_AuthenticationFailed
+				res.Content = err.Error()
+				usernameExists = true
+			}
+		}
+
+		// New users are placed in the default room, where their arrival is announced
+		if !usernameExists {
+			client.Username = req.SenderName
+			client.Authenticated = authenticated
+			client.Operator = server.IsConfiguredOperator(client)
+			principal, err := NewPrincipal(client.Username, client.Authenticated)
+			if err != nil {
+				server.Log.Println("Could not create principal: ", err)
+			}
+			client.Principal = principal
+			server.StartSession(client)
+			server.AddToRoom(client, DefaultRoom)
+			server.Log.Printf("Registered user (username = %v, address = %v, authenticated = %v, operator = %v)\n", client.Username, client.ClientAddr, client.Authenticated, client.Operator)
+			registered = true
+		} else {
+			server.Metrics.RegistrationFailures.With(res.Code.String()).Inc()
+		}
+	}
+
+	server.SendResponse(res, req.ConnID)
+
+	// Greet new users and catch them up on the conversation they joined
+	if registered {
+		if server.MOTD != "" {
+			server.SendTo(req.ConnID, response.Response{ResType: response.ResponseType_ServerPriv, Content: server.MOTD})
+		}
+		server.ReplayHistory(req.ConnID, DefaultRoom, server.HistoryReplay)
+		server.DeliverMail(client)
 	}
 }
 
-func (client *ClientConn) Send(done chan<- string) {
+func (client *ClientConn) Send(done chan<- ConnID) {
 	for {
 		res, ok := <-client.ResponseQueue
 		if !ok {
-			done <- client.ClientAddr
-			return
+			break
 		}
 
-		buf, err := response.Serialize(res)
+		// A stalled reader fails the write instead of holding the goroutine forever
+		client.Connection.SetWriteDeadline(time.Now().Add(client.WriteTimeout))
+		err := client.Codec.WriteResponse(res)
 		if err != nil {
-			done <- client.ClientAddr
-			return
+			break
 		}
 
-		_, err = client.Connection.Write(buf)
-		if err != nil {
-			done <- client.ClientAddr
-			return
+		// Nothing may follow a termination notice, so the connection is finished with
+		if res.ResType == response.ResponseType_TerminateConnection {
+			break
 		}
 	}
-}
 
-func (client *ClientConn) Receive(reqs chan<- request.Request, done chan<- string) {
-	buffer := make([]byte, 1024)
+	// Finished is closed before reporting, so the event loop stops delivering to this client while the report waits to be read
+	close(client.Finished)
+	done <- client.ID
+}
 
+func (client *ClientConn) Receive(reqs chan<- request.Request, violations chan<- FloodViolation, done chan<- ConnID) {
 	for {
-		_, err := client.Connection.Read(buffer)
+		req, err := client.Codec.ReadRequest()
 		if err != nil {
-			done <- client.ClientAddr
+			done <- client.ID
 			return
 		}
 
-		req, err := request.Deserialize(buffer)
-		if err != nil {
-			done <- client.ClientAddr
-			return
+		// Requests over the rate limit are dropped here, so a flood never reaches the event loop
+		if client.Flood != nil {
+			allowed, level := client.Flood.Check(time.Now())
+			if level != Flood_None {
+				violations <- FloodViolation{ID: client.ID, Level: level}
+			}
+			if !allowed {
+				client.Metrics.RateLimitedDropped.Inc()
+				continue
+			}
 		}
 
 		req.ClientAddr = client.ClientAddr
+		req.ConnID = client.ID
 
 		reqs <- req
 	}
 }
 
-func (server *Server) AddClient(conn net.Conn) error {
-	addr := conn.RemoteAddr()
+// Adds a connection that has completed the hello exchange
+func (server *Server) AddClient(client *ClientConn) error {
+	addr := client.Connection.RemoteAddr()
 	if addr.Network() != "tcp" {
 		return &ServerError{Message: "Client network must be TCP"}
 	}
 
-	client := ClientConn{Connection: conn, ClientAddr: addr.String(), ResponseQueue: make(chan response.Response)}
+	// Turn away connections over the limit or during shutdown with a reason instead of leaving them unanswered
+	// Rejections are written from their own goroutine, so a slow peer cannot hold up the event loop
+	if server.IsClosing() {
+		go server.RejectClient(client, response.ErrorCode_ShuttingDown, server.CloseReason())
+		return nil
+	}
+	// Banned addresses and the per-address limit were checked by Admit when the connection was accepted
+	if server.MaxClients > 0 && len(server.Connections) >= server.MaxClients {
+		server.Log.Println("Rejected client, server is full: ", addr.String())
+		go server.RejectClient(client, response.ErrorCode_ServerFull, "Server is full")
+		return nil
+	}
+
+	server.nextID++
+	client.ID = server.nextID
+	client.ResponseQueue = make(chan response.Response, server.QueueSize)
+	client.Finished = make(chan struct{})
+	client.Flood = NewFloodGuard(server.Flood)
+	client.WriteTimeout = server.WriteTimeout
+	client.Metrics = server.Metrics
 
-	server.Connections = append(server.Connections, client)
+	server.Connections[client.ID] = client
+	server.Metrics.Connections.Inc()
+	server.Metrics.ConnectedClients.Set(int64(len(server.Connections)))
 
-	server.Log.Println("Client connected: ", client.ClientAddr)
+	server.Log.Printf("Client connected (id = %v, address = %v)\n", client.ID, client.ClientAddr)
 
 	// Each client has a Send goroutine for sending responses to
-	go server.Connections[len(server.Connections)-1].Send(server.ClientDone)
+	go client.Send(server.ClientDone)
 
 	// Each client has a Receive goroutine for receiving requests from
-	go server.Connections[len(server.Connections)-1].Receive(server.Reqs, server.ClientDone)
+	go client.Receive(server.Reqs, server.Violations, server.ClientDone)
 
 	return nil
 }
 
-func (server *Server) RemoveClient() {
-	for {
-		addr := <-server.ClientDone
-		for i, cc := range server.Connections {
-			if cc.ClientAddr == addr {
-				// Use a simple replace-with-last policy
-				server.Connections[i] = server.Connections[len(server.Connections)-1]
-				server.Connections = server.Connections[:len(server.Connections)-1]
-
-				close(cc.ResponseQueue)
-				err := cc.Connection.Close()
-				if err != nil {
-					server.Log.Fatalln("Client connection could not be closed: ", err)
-					server.Status <- ServerStatus{Code: ErrorState, Error: err}
-					return
-				}
-
-				server.Log.Printf("Client (username = %v, address = %v) disconnected\n", cc.Username, cc.ClientAddr)
-
-				// Manually send a response to all users indicating that a user has disconnected
-				if cc.Username != "" {
-					disconnectResponse := response.Response{ResType: response.ResponseType_ServerAll, Content: fmt.Sprintf("%v has disconnected", cc.Username)}
-					server.SendResponse(disconnectResponse, cc.ClientAddr)
-				}
-			}
-		}
+// Sends a termination notice to a connection that was never added, then closes it
+func (server *Server) RejectClient(client *ClientConn, code response.ErrorCode, reason string) error {
+	server.Metrics.RejectedConnections.With(code.String()).Inc()
+	client.Connection.SetWriteDeadline(time.Now().Add(time.Second))
+	client.Codec.WriteResponse(response.Response{ResType: response.ResponseType_TerminateConnection, Code: code, Content: reason})
+	return client.Connection.Close()
+}
+
+// Both the Send and Receive goroutines report when they stop, so a connection is usually reported twice
+func (server *Server) RemoveClient(id ConnID) {
+	// Connections are closed all at once by Close during shutdown
+	if server.IsClosing() {
+		return
+	}
+
+	cc, ok := server.Connections[id]
+	if !ok {
+		return
+	}
+	delete(server.Connections, id)
+	server.Metrics.ConnectedClients.Set(int64(len(server.Connections)))
+
+	close(cc.ResponseQueue)
+	err := closeConnection(cc.Connection)
+	if err != nil {
+		server.Log.Fatalln("Client connection could not be closed: ", err)
+		server.Status <- ServerStatus{Code: ErrorState, Error: err}
+		return
+	}
+
+	server.Log.Printf("Client (username = %v, address = %v) disconnected\n", cc.Username, cc.ClientAddr)
+
+	// Hold the username in case the client reconnects, then tell the user's room that they have disconnected
+	if cc.Room != "" {
+		server.ReserveSession(cc)
+		server.RemoveFromRoom(cc.ID, cc.Room)
+		disconnectResponse := response.Response{ResType: response.ResponseType_ServerRoom, Content: fmt.Sprintf("%v has disconnected", cc.Username)}
+		server.BroadcastRoom(cc.Room, disconnectResponse)
 	}
 }
@@ -0,0 +1,378 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/account"
+	"github.com/edobrowo/gochatroom/pkg/ban"
+	"github.com/edobrowo/gochatroom/pkg/frame"
+	"github.com/edobrowo/gochatroom/pkg/protocol"
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+const testTimeout = 10 * time.Second
+
+// Starts a configured server on a free loopback port and stops it when the test ends
+func startTestServer(t *testing.T, server *Server) string {
+	t.Helper()
+
+	stopped := make(chan struct{})
+	go func() {
+		server.Listen(net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+		close(stopped)
+	}()
+
+	// Listener is set before Listening is reported
+	deadline := time.Now().Add(testTimeout)
+	for server.CurrentStatus().Code != Listening {
+		if time.Now().After(deadline) {
+			t.Fatal("Server did not start listening")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Cleanup(func() {
+		server.Shutdown("")
+		select {
+		case <-stopped:
+		case <-time.After(testTimeout):
+			t.Error("Server did not stop")
+		}
+	})
+
+	return server.Listener.Addr().String()
+}
+
+// A client speaking the binary codec; responses are read into a channel by their own goroutine
+type testClient struct {
+	name      string
+	conn      net.Conn
+	writer    *frame.Writer
+	responses chan response.Response
+}
+
+func dialTestClient(addr string, name string) (*testClient, error) {
+	return dialTestClientWith(addr, name, protocol.Supported)
+}
+
+// Offers only the given features in the hello
+func dialTestClientWith(addr string, name string, features protocol.Features) (*testClient, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &testClient{name: name, conn: conn, writer: frame.NewWriter(conn), responses: make(chan response.Response, 256)}
+	reader := frame.NewReader(conn)
+
+	err = protocol.Write(client.writer, protocol.NewHello(features))
+	if err == nil {
+		_, err = protocol.Read(reader)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go func() {
+		defer close(client.responses)
+		for {
+			res, err := response.Read(reader)
+			if err != nil {
+				return
+			}
+			client.responses <- res
+		}
+	}()
+
+	return client, nil
+}
+
+func (client *testClient) send(t *testing.T, req request.Request) {
+	t.Helper()
+
+	req.SenderName = client.name
+	err := request.Write(client.writer, req)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func (client *testClient) register(t *testing.T) {
+	t.Helper()
+	client.send(t, request.Request{ReqType: request.RequestType_Status, StType: request.Status_Register})
+}
+
+// Reads responses until one matches, failing the test if none does in time
+func (client *testClient) await(t *testing.T, matches func(response.Response) bool) {
+	t.Helper()
+
+	timer := time.NewTimer(testTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case res, ok := <-client.responses:
+			if !ok {
+				t.Errorf("%v: connection closed while waiting", client.name)
+				return
+			}
+			if matches(res) {
+				return
+			}
+		case <-timer.C:
+			t.Errorf("%v: timed out waiting for a response", client.name)
+			return
+		}
+	}
+}
+
+// Reads responses until every one of the events has been seen
+func (client *testClient) awaitAll(t *testing.T, events map[string]bool) {
+	t.Helper()
+
+	timer := time.NewTimer(testTimeout)
+	defer timer.Stop()
+
+	for len(events) > 0 {
+		select {
+		case res, ok := <-client.responses:
+			if !ok {
+				t.Errorf("%v: connection closed while waiting", client.name)
+				return
+			}
+			switch res.ResType {
+			case response.ResponseType_ServerRoom:
+				delete(events, res.Content)
+			case response.ResponseType_Message:
+				delete(events, res.SenderName+": "+res.Content)
+			}
+		case <-timer.C:
+			for event := range events {
+				t.Errorf("%v did not see %q", client.name, event)
+			}
+			return
+		}
+	}
+}
+
+func isRoomNotice(content string) func(response.Response) bool {
+	return func(res response.Response) bool {
+		return res.ResType == response.ResponseType_ServerRoom && res.Content == content
+	}
+}
+
+func isMessage(sender string, content string) func(response.Response) bool {
+	return func(res response.Response) bool {
+		return res.ResType == response.ResponseType_Message && res.SenderName == sender && res.Content == content
+	}
+}
+
+// Polls the event loop until the condition holds
+func waitForServer(t *testing.T, server *Server, condition func() bool) bool {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for {
+		var met bool
+		server.Do(func() {
+			met = condition()
+		})
+		if met {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Meant to be run with -race: clients connect, broadcast and disconnect all at once while observers watch
+func TestConcurrentClients(t *testing.T) {
+	const observerCount = 3
+	const churnerCount = 25
+
+	server := &Server{Log: log.New(io.Discard, "", 0)}
+	addr := startTestServer(t, server)
+
+	observers := make([]*testClient, observerCount)
+	for i := range observers {
+		observer, err := dialTestClient(addr, fmt.Sprintf("observer%v", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		observer.register(t)
+		observer.await(t, isRoomNotice(fmt.Sprintf("%v has connected", observer.name)))
+		observers[i] = observer
+	}
+
+	var churning sync.WaitGroup
+	for i := 0; i < churnerCount; i++ {
+		churning.Add(1)
+		go func(name string) {
+			defer churning.Done()
+
+			churner, err := dialTestClient(addr, name)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer churner.conn.Close()
+
+			churner.register(t)
+			churner.await(t, isRoomNotice(fmt.Sprintf("%v has connected", name)))
+
+			// Wait for the echo so the message is handled before the connection goes away
+			churner.send(t, request.Request{ReqType: request.RequestType_Message, Content: "hello"})
+			churner.await(t, isMessage(name, "hello"))
+		}(fmt.Sprintf("churner%v", i))
+	}
+	churning.Wait()
+
+	// Every observer stays in the room for the whole run, so it must see each churner come, speak and go
+	for _, observer := range observers {
+		events := make(map[string]bool)
+		for i := 0; i < churnerCount; i++ {
+			name := fmt.Sprintf("churner%v", i)
+			events[fmt.Sprintf("%v has connected", name)] = true
+			events[name+": hello"] = true
+			events[fmt.Sprintf("%v has disconnected", name)] = true
+		}
+		observer.awaitAll(t, events)
+	}
+
+	for _, observer := range observers {
+		observer.conn.Close()
+	}
+
+	if !waitForServer(t, server, func() bool { return len(server.Connections) == 0 }) {
+		t.Error("Client map is not empty after every client disconnected")
+	}
+}
+
+// Logins are checked off the event loop, and refused outright once a name has failed too often
+func TestFailedLoginLockout(t *testing.T) {
+	accounts, err := account.OpenStore(filepath.Join(t.TempDir(), "accounts.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = accounts.Register("alice", "hunter22")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{Log: log.New(io.Discard, "", 0), Accounts: accounts}
+	addr := startTestServer(t, server)
+
+	login := func(password string) response.Response {
+		client, err := dialTestClient(addr, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.conn.Close()
+
+		client.send(t, request.Request{ReqType: request.RequestType_Status, StType: request.Status_Register, Content: password})
+		for res := range client.responses {
+			if res.ResType == response.ResponseType_TerminateConnection || res.ResType == response.ResponseType_ServerRoom {
+				return res
+			}
+		}
+		t.Fatal("Connection closed without a login result")
+		return response.Response{}
+	}
+
+	for i := 0; i < MaxFailedLogins; i++ {
+		res := login("wrong")
+		if res.Code != response.ErrorCode_AuthenticationFailed || res.Content != "Incorrect password" {
+			t.Fatalf("Attempt %v: expected an incorrect password, got %+v", i+1, res)
+		}
+	}
+
+	// Even the right password is refused until the window has passed
+	res := login("hunter22")
+	if res.Code != response.ErrorCode_AuthenticationFailed || !strings.HasPrefix(res.Content, "Too many failed logins") {
+		t.Fatalf("Expected a lockout, got %+v", res)
+	}
+
+	server.Do(func() {
+		server.failedLogins = make(map[string]*loginFailures)
+	})
+	res = login("hunter22")
+	if res.ResType != response.ResponseType_ServerRoom || res.Content != "alice has connected" {
+		t.Fatalf("Expected a successful login, got %+v", res)
+	}
+}
+
+// Opens a connection that has not sent its hello yet
+func dialSilent(t *testing.T, addr string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Sends a hello and returns the termination notice the server answers with in its place
+func expectRefusal(t *testing.T, conn net.Conn, code response.ErrorCode) {
+	t.Helper()
+
+	conn.SetDeadline(time.Now().Add(testTimeout))
+	protocol.Write(frame.NewWriter(conn), protocol.NewHello(protocol.Supported))
+
+	res, err := response.Read(frame.NewReader(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ResType != response.ResponseType_TerminateConnection || res.Code != code {
+		t.Fatalf("Expected to be refused with %v, got %+v", code, res)
+	}
+}
+
+// Connections still in their handshake count against the per-address limit, and banned addresses are refused before it
+func TestAdmission(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0), MaxConnectionsPerIP: 2}
+	addr := startTestServer(t, server)
+
+	first := dialSilent(t, addr)
+	dialSilent(t, addr)
+	expectRefusal(t, dialSilent(t, addr), response.ErrorCode_TooManyConnections)
+
+	// Closing a connection frees its slot once the server notices
+	first.Close()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		client, err := dialTestClient(addr, "alice")
+		if err == nil {
+			client.conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Slot of a closed connection was not freed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err := server.Bans.Add(ban.Ban{Kind: ban.Kind_IP, Target: "127.0.0.1", Reason: "testing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRefusal(t, dialSilent(t, addr), response.ErrorCode_Banned)
+}
+
+func formatID(id uint64) string {
+	return strconv.FormatUint(id, 10)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/protocol"
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+const (
+	// How long a username stays reserved for its owner after their connection drops
+	DefaultSessionGrace = 60 * time.Second
+
+	SessionTokenSize = 16
+)
+
+// Holds a disconnected user's place until they resume or the grace period ends
+type Reservation struct {
+	Username      string
+	Token         string
+	Room          string
+	Authenticated bool
+	Operator      bool
+	Away          bool
+	Principal     string
+
+	// Messages after this sequence number were missed while disconnected
+	LastSeq uint64
+	Expires time.Time
+}
+
+func NewSessionToken() (string, error) {
+	buf := make([]byte, SessionTokenSize)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Names who sent a message in a way that a later user of the same name cannot claim
+// Logged-in users are their account; guests get an identity made for this registration, kept only if the session is resumed
+func NewPrincipal(username string, authenticated bool) (string, error) {
+	if authenticated {
+		return "account:" + username, nil
+	}
+
+	token, err := NewSessionToken()
+	if err != nil {
+		return "", err
+	}
+	return "guest:" + token, nil
+}
+
+// Finds an unexpired reservation for a username, discarding it if it has expired
+func (server *Server) FindReservation(username string) *Reservation {
+	reservation, ok := server.Reservations[username]
+	if !ok {
+		return nil
+	}
+
+	if time.Now().After(reservation.Expires) {
+		delete(server.Reservations, username)
+		return nil
+	}
+
+	return reservation
+}
+
+// Issues a session token to a newly registered client; sent before anything else so the client can read it first
+func (server *Server) StartSession(client *ClientConn) {
+	if !client.Protocol.Features.Has(protocol.Feature_Sessions) {
+		return
+	}
+
+	token, err := NewSessionToken()
+	if err != nil {
+		server.Log.Println("Could not create session token: ", err)
+		return
+	}
+
+	client.SessionToken = token
+	server.SendTo(client.ID, response.Response{ResType: response.ResponseType_Session, ReceiverName: client.Username, Content: token})
+}
+
+// Keeps a dropped client's username and room for the grace period
+func (server *Server) ReserveSession(client *ClientConn) {
+	if client.SessionToken == "" {
+		return
+	}
+
+	grace := server.SessionGrace
+	if grace <= 0 {
+		grace = DefaultSessionGrace
+	}
+
+	server.Reservations[client.Username] = &Reservation{
+		Username:      client.Username,
+		Token:         client.SessionToken,
+		Room:          client.Room,
+		Authenticated: client.Authenticated,
+		Operator:      client.Operator,
+		Away:          client.Away,
+		Principal:     client.Principal,
+		LastSeq:       server.History.LastSeq(),
+		Expires:       time.Now().Add(grace),
+	}
+}
+
+// Handles Status_Resume; on failure the connection stays unregistered so the client can fall back to Status_Register
+func (server *Server) HandleResume(req request.Request) {
+	client := server.FindClient(req.ConnID)
+	if client == nil {
+		return
+	}
+
+	if !client.Protocol.Features.Has(protocol.Feature_Sessions) {
+		server.SendError(req.ConnID, response.ErrorCode_Unavailable, "Sessions were not negotiated on this connection")
+		return
+	}
+
+	reservation := server.FindReservation(req.SenderName)
+	if reservation == nil || subtle.ConstantTimeCompare([]byte(reservation.Token), []byte(req.Content)) != 1 {
+		server.SendError(req.ConnID, response.ErrorCode_SessionExpired, "Session could not be resumed")
+		return
+	}
+	delete(server.Reservations, req.SenderName)
+
+	client.Username = reservation.Username
+	client.Authenticated = reservation.Authenticated
+	client.Operator = reservation.Operator
+	client.Away = reservation.Away
+	client.Principal = reservation.Principal
+	client.SessionToken = reservation.Token
+	server.Log.Printf("Resumed session (username = %v, address = %v)\n", client.Username, client.ClientAddr)
+
+	server.SendTo(req.ConnID, response.Response{ResType: response.ResponseType_Session, ReceiverName: client.Username, Content: client.SessionToken})
+
+	server.AddToRoom(client, reservation.Room)
+	server.BroadcastRoom(client.Room, response.Response{ResType: response.ResponseType_ServerRoom, Content: fmt.Sprintf("%v has reconnected", client.Username)})
+
+	// Whispers sent while the user was away are held for them, since they had no connection to receive them on
+	server.DeliverMail(client)
+
+	// Replay whatever was said in the room while the client was away
+	entries, err := server.History.Since(client.Room, reservation.LastSeq)
+	if err != nil {
+		server.Log.Println("Could not read history: ", err)
+		return
+	}
+	if len(entries) > 0 {
+		server.SendTo(req.ConnID, response.Response{ResType: response.ResponseType_ServerPriv, Content: fmt.Sprintf("%v messages in #%v while you were away:", len(entries), client.Room)})
+		for _, entry := range entries {
+			server.SendTo(req.ConnID, entry.Response)
+			server.SendReactions(req.ConnID, entry.Response.ID)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+// Registers a client and returns the session token it is issued
+func (client *testClient) registerSession(t *testing.T) string {
+	t.Helper()
+
+	var token string
+	client.register(t)
+	client.await(t, func(res response.Response) bool {
+		token = res.Content
+		return res.ResType == response.ResponseType_Session
+	})
+	return token
+}
+
+func (client *testClient) resume(t *testing.T, token string) {
+	t.Helper()
+	client.send(t, request.Request{ReqType: request.RequestType_Status, StType: request.Status_Resume, Content: token})
+}
+
+// Starts a server, connects bob, then connects alice and drops her connection, leaving her session reserved
+func startDroppedSession(t *testing.T, grace time.Duration) (*Server, string, *testClient, string) {
+	t.Helper()
+
+	server := &Server{Log: log.New(io.Discard, "", 0), SessionGrace: grace}
+	addr := startTestServer(t, server)
+
+	bob, err := dialTestClient(addr, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bob.conn.Close() })
+	bob.registerSession(t)
+
+	alice, err := dialTestClient(addr, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := alice.registerSession(t)
+	bob.await(t, isRoomNotice("alice has connected"))
+
+	alice.conn.Close()
+	bob.await(t, isRoomNotice("alice has disconnected"))
+	return server, addr, bob, token
+}
+
+// Resuming within the grace period restores the username and replays what was said in the room since the drop
+func TestResume(t *testing.T) {
+	server, addr, bob, token := startDroppedSession(t, time.Minute)
+
+	// The name is still held, so nobody else can take it
+	other, err := dialTestClient(addr, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.conn.Close()
+	other.register(t)
+	other.await(t, isTermination(response.ErrorCode_UsernameTaken))
+
+	bob.send(t, request.Request{ReqType: request.RequestType_Message, Content: "are you there?"})
+	bob.await(t, isMessage("bob", "are you there?"))
+	bob.send(t, request.Request{ReqType: request.RequestType_Message, Content: "hello?"})
+	bob.await(t, isMessage("bob", "hello?"))
+
+	alice, err := dialTestClient(addr, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.conn.Close()
+
+	// A wrong token is refused without closing the connection
+	alice.resume(t, "0123")
+	alice.await(t, isError(response.ErrorCode_SessionExpired))
+
+	alice.resume(t, token)
+	alice.await(t, func(res response.Response) bool {
+		return res.ResType == response.ResponseType_Session && res.Content == token
+	})
+	bob.await(t, isRoomNotice("alice has reconnected"))
+
+	alice.await(t, isServerNotice("2 messages in #lobby while you were away:"))
+	alice.await(t, isMessage("bob", "are you there?"))
+	alice.await(t, isMessage("bob", "hello?"))
+
+	if !waitForServer(t, server, func() bool { return len(server.Reservations) == 0 }) {
+		t.Error("Reservation was kept after the session was resumed")
+	}
+}
+
+// Once the grace period ends the token no longer works, but the client can still register on the same connection
+func TestResumeExpired(t *testing.T) {
+	server, addr, bob, token := startDroppedSession(t, 50*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+
+	alice, err := dialTestClient(addr, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.conn.Close()
+
+	alice.resume(t, token)
+	alice.await(t, isError(response.ErrorCode_SessionExpired))
+	if !waitForServer(t, server, func() bool { return len(server.Reservations) == 0 }) {
+		t.Error("Expired reservation was kept")
+	}
+
+	newToken := alice.registerSession(t)
+	if newToken == token {
+		t.Error("A new registration was issued the expired token")
+	}
+	bob.await(t, isRoomNotice("alice has connected"))
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"time"
+
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+const (
+	// How long clients are given to receive the shutdown notice before their connections are closed
+	DefaultShutdownTimeout = 5 * time.Second
+
+	DefaultShutdownReason = "Server is shutting down"
+)
+
+// Begins a graceful shutdown; safe to call from any goroutine, such as a signal handler
+func (server *Server) Shutdown(reason string) {
+	server.shutdown.Do(func() {
+		if reason == "" {
+			reason = DefaultShutdownReason
+		}
+
+		server.closeReason.Store(&reason)
+
+		server.Log.Println("Shutdown requested: ", reason)
+		server.Status <- ServerStatus{Code: Closing}
+	})
+}
+
+func (server *Server) IsClosing() bool {
+	return server.closeReason.Load() != nil
+}
+
+// The reason given to Shutdown, or empty if the server is not closing
+func (server *Server) CloseReason() string {
+	reason := server.closeReason.Load()
+	if reason == nil {
+		return ""
+	}
+	return *reason
+}
+
+// Sends a termination notice to every client and waits, up to the shutdown timeout, for each to be written
+func (server *Server) NotifyClients(reason string) {
+	timeout := server.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	res := response.Response{ResType: response.ResponseType_TerminateConnection, Code: response.ErrorCode_ShuttingDown, Content: reason}
+
+	// Only the event loop sends on response queues, so the notices are queued there; Deliver makes room for them in full queues
+	var clients []*ClientConn
+	server.Do(func() {
+		for _, client := range server.Connections {
+			// Stalled readers must not hold up the shutdown past the deadline
+			client.Connection.SetWriteDeadline(deadline)
+			server.Deliver(client, res)
+			clients = append(clients, client)
+		}
+	})
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	// Send exits once the termination notice has been written
+	for _, client := range clients {
+		select {
+		case <-client.Finished:
+		case <-timer.C:
+			server.Log.Printf("Notified %v clients of shutdown before the deadline\n", len(clients))
+			return
+		}
+	}
+
+	server.Log.Printf("Notified %v clients of shutdown\n", len(clients))
+}
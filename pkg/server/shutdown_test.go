@@ -0,0 +1,36 @@
+package server
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+// Every client is told why it is being disconnected before its connection is closed
+func TestShutdownNotice(t *testing.T) {
+	server := &Server{Log: log.New(io.Discard, "", 0)}
+	addr := startTestServer(t, server)
+
+	clients := make([]*testClient, 3)
+	for i, name := range []string{"alice", "bob", "carol"} {
+		client, err := dialTestClient(addr, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.conn.Close()
+
+		client.register(t)
+		client.await(t, isRoomNotice(name+" has connected"))
+		clients[i] = client
+	}
+
+	server.Shutdown("Going down for maintenance")
+
+	for _, client := range clients {
+		client.await(t, func(res response.Response) bool {
+			return res.ResType == response.ResponseType_TerminateConnection && res.Code == response.ErrorCode_ShuttingDown && res.Content == "Going down for maintenance"
+		})
+	}
+}
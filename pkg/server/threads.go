@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+// Finds a retained message in the client's own room, which is all that replies and reactions may refer to; tells the client if there is none
+func (server *Server) FindInRoom(client *ClientConn, id uint64) (HistoryEntry, bool) {
+	entry, ok := server.History.Find(id)
+	if !ok || entry.Room != client.Room {
+		server.SendError(client.ID, response.ErrorCode_NoSuchMessage, fmt.Sprintf("Message %v does not exist in #%v", id, client.Room))
+		return HistoryEntry{}, false
+	}
+	return entry, true
+}
+
+// Handles /thread <id>, replaying the whole thread a message belongs to
+func (server *Server) HandleThreadCommand(req request.Request) {
+	client := server.FindClient(req.ConnID)
+	if client == nil || client.Room == "" {
+		return
+	}
+
+	id, err := strconv.ParseUint(req.Content, 10, 64)
+	if err != nil || id == 0 {
+		server.SendError(req.ConnID, response.ErrorCode_InvalidArgument, "Usage: /thread <id>")
+		return
+	}
+
+	entries, err := server.History.Thread(id)
+	if err == nil && (len(entries) == 0 || entries[0].Room != client.Room) {
+		err = &ServerError{Code: response.ErrorCode_NoSuchMessage}
+	}
+	if err != nil {
+		code := CodeOf(err, response.ErrorCode_Internal)
+		if code == response.ErrorCode_NoSuchMessage {
+			server.SendError(req.ConnID, code, fmt.Sprintf("Message %v does not exist in #%v", id, client.Room))
+		} else {
+			server.Log.Println("Could not read history: ", err)
+			server.SendError(req.ConnID, code, "History is unavailable")
+		}
+		return
+	}
+
+	server.SendTo(req.ConnID, response.Response{ResType: response.ResponseType_ServerPriv, Content: fmt.Sprintf("Thread of message %v in #%v (%v messages):", id, client.Room, len(entries))})
+	for _, entry := range entries {
+		server.SendTo(req.ConnID, entry.Response)
+		server.SendReactions(req.ConnID, entry.Response.ID)
+	}
+}
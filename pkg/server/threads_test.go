@@ -0,0 +1,140 @@
+package server
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"github.com/edobrowo/gochatroom/pkg/request"
+	"github.com/edobrowo/gochatroom/pkg/response"
+)
+
+// Starts a server with the given history and registers a client for each name, each seeing the others connect
+func startThreadServer(t *testing.T, history HistoryStore, names ...string) []*testClient {
+	t.Helper()
+
+	server := &Server{Log: log.New(io.Discard, "", 0), History: history}
+	addr := startTestServer(t, server)
+
+	clients := make([]*testClient, len(names))
+	for i, name := range names {
+		client, err := dialTestClient(addr, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.conn.Close() })
+		client.register(t)
+		clients[i] = client
+		for _, other := range clients[:i+1] {
+			other.await(t, isRoomNotice(name+" has connected"))
+		}
+	}
+	return clients
+}
+
+// Sends a message and returns the ID it was broadcast with
+func (client *testClient) say(t *testing.T, req request.Request) uint64 {
+	t.Helper()
+
+	var id uint64
+	client.send(t, req)
+	client.await(t, func(res response.Response) bool {
+		id = res.ID
+		return isMessage(client.name, req.Content)(res)
+	})
+	return id
+}
+
+// A reply reaches everyone in the room carrying the ID of the message it answers
+func TestReply(t *testing.T) {
+	clients := startThreadServer(t, nil, "alice", "bob")
+	alice, bob := clients[0], clients[1]
+
+	root := alice.say(t, request.Request{ReqType: request.RequestType_Message, Content: "lunch?"})
+
+	bob.send(t, request.Parse("/reply "+formatID(root)+" sure"))
+	for _, client := range clients {
+		client.await(t, func(res response.Response) bool {
+			if !isMessage("bob", "sure")(res) {
+				return false
+			}
+			if res.ParentID != root {
+				t.Errorf("%v was sent the reply with parent %v, expected %v", client.name, res.ParentID, root)
+			}
+			return true
+		})
+	}
+
+	// A message request naming a parent is a reply too
+	alice.send(t, request.Request{ReqType: request.RequestType_Message, Content: "great", ParentID: root})
+	bob.await(t, func(res response.Response) bool {
+		return isMessage("alice", "great")(res) && res.ParentID == root
+	})
+
+	bob.send(t, request.Parse("/reply "+formatID(root)))
+	bob.await(t, isError(response.ErrorCode_InvalidArgument))
+}
+
+// Replies may only answer messages that are still retained in the sender's room
+func TestReplyToMissing(t *testing.T) {
+	clients := startThreadServer(t, NewMemoryHistory(2), "alice", "bob")
+	alice, bob := clients[0], clients[1]
+
+	first := alice.say(t, request.Request{ReqType: request.RequestType_Message, Content: "one"})
+	alice.say(t, request.Request{ReqType: request.RequestType_Message, Content: "two"})
+	alice.say(t, request.Request{ReqType: request.RequestType_Message, Content: "three"})
+
+	for _, id := range []uint64{first, 999} {
+		bob.send(t, request.Parse("/reply "+formatID(id)+" what?"))
+		bob.await(t, isError(response.ErrorCode_NoSuchMessage))
+
+		bob.send(t, request.Request{ReqType: request.RequestType_Message, Content: "what?", ParentID: id})
+		bob.await(t, isError(response.ErrorCode_NoSuchMessage))
+	}
+
+	// Messages in another room cannot be answered either
+	latest := alice.say(t, request.Request{ReqType: request.RequestType_Message, Content: "four"})
+	bob.send(t, request.Parse("/join dev"))
+	bob.await(t, isRoomNotice("bob has joined #dev"))
+	bob.send(t, request.Parse("/reply "+formatID(latest)+" what?"))
+	bob.await(t, isError(response.ErrorCode_NoSuchMessage))
+
+	// Nothing was broadcast for the refused replies
+	alice.send(t, request.Request{ReqType: request.RequestType_Message, Content: "done"})
+	alice.await(t, func(res response.Response) bool {
+		if res.ResType == response.ResponseType_Message && res.Content == "what?" {
+			t.Error("A refused reply was broadcast")
+		}
+		return isMessage("alice", "done")(res)
+	})
+}
+
+// /thread lists the whole thread a message belongs to, oldest first, leaving out unrelated messages
+func TestThreadCommand(t *testing.T) {
+	clients := startThreadServer(t, nil, "alice", "bob")
+	alice, bob := clients[0], clients[1]
+
+	root := alice.say(t, request.Request{ReqType: request.RequestType_Message, Content: "lunch?"})
+	reply := bob.say(t, request.Request{ReqType: request.RequestType_Message, Content: "sure", ParentID: root})
+	alice.say(t, request.Request{ReqType: request.RequestType_Message, Content: "unrelated"})
+	bob.say(t, request.Request{ReqType: request.RequestType_Message, Content: "where?", ParentID: reply})
+
+	alice.send(t, request.Parse("/thread "+formatID(reply)))
+	alice.await(t, isServerNotice("Thread of message "+formatID(reply)+" in #lobby (3 messages):"))
+	for _, expected := range []string{"lunch?", "sure", "where?"} {
+		alice.await(t, func(res response.Response) bool {
+			if res.ResType != response.ResponseType_Message {
+				return false
+			}
+			if res.Content != expected {
+				t.Errorf("Thread listed %q, expected %q", res.Content, expected)
+			}
+			return true
+		})
+	}
+
+	alice.send(t, request.Parse("/thread 999"))
+	alice.await(t, isError(response.ErrorCode_NoSuchMessage))
+	alice.send(t, request.Parse("/thread"))
+	alice.await(t, isError(response.ErrorCode_InvalidArgument))
+}
@@ -0,0 +1,133 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Serves the handshake of a freshly generated self-signed certificate and returns its address and fingerprint
+func startTestListener(t *testing.T) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	fingerprint, err := GenerateSelfSigned([]string{"127.0.0.1"}, time.Hour, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadServerConfig(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	return listener.Addr().String(), fingerprint
+}
+
+func TestPinnedFingerprint(t *testing.T) {
+	addr, fingerprint := startTestListener(t)
+
+	// Fingerprints are accepted without colons and in lower case
+	for _, pinned := range []string{fingerprint, strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))} {
+		config, err := LoadClientConfig("", pinned, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := tls.Dial("tcp", addr, config)
+		if err != nil {
+			t.Errorf("Handshake with a matching fingerprint %v failed: %v", pinned, err)
+			continue
+		}
+		conn.Close()
+	}
+}
+
+func TestPinnedFingerprintMismatch(t *testing.T) {
+	addr, _ := startTestListener(t)
+
+	// Another certificate's fingerprint must not match
+	other, err := GenerateSelfSigned([]string{"127.0.0.1"}, time.Hour, filepath.Join(t.TempDir(), "cert.pem"), filepath.Join(t.TempDir(), "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadClientConfig("", other, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := tls.Dial("tcp", addr, config)
+	if err == nil {
+		conn.Close()
+		t.Fatal("Handshake succeeded with a mismatched fingerprint")
+	}
+	if !strings.Contains(err.Error(), "does not match pinned fingerprint") {
+		t.Errorf("Unexpected handshake error: %v", err)
+	}
+}
+
+func TestCAFile(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	fingerprint, err := GenerateSelfSigned([]string{"127.0.0.1"}, time.Hour, certFile, filepath.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadClientConfig(certFile, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.RootCAs == nil || config.InsecureSkipVerify {
+		t.Error("CA bundle is not used to verify the chain")
+	}
+
+	// Pinning would skip the chain the CA bundle is meant to verify
+	_, err = LoadClientConfig(certFile, fingerprint, "")
+	if err == nil {
+		t.Error("A CA bundle was accepted together with a pinned fingerprint")
+	}
+}
+
+func TestParseFingerprint(t *testing.T) {
+	tests := []struct {
+		fingerprint string
+		valid       bool
+	}{
+		{strings.Repeat("AB:", 31) + "AB", true},
+		{strings.Repeat("ab", 32), true},
+		{strings.Repeat("ab", 31), false},
+		{strings.Repeat("zz", 32), false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		_, err := ParseFingerprint(test.fingerprint)
+		if (err == nil) != test.valid {
+			t.Errorf("ParseFingerprint(%q) returned %v", test.fingerprint, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+//go:build ignore
+
+// Generates tables.go from the Unicode normalization data in golang.org/x/text, which is not a dependency of this module
+// Run it from a module that requires golang.org/x/text: go run gen_tables.go > tables.go
+package main
+
+import (
+	"fmt"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+type span struct {
+	lo, hi rune
+}
+
+func main() {
+	var spans []span
+	for r := rune(0); r <= unicode.MaxRune; r++ {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			continue
+		}
+
+		// Normalize folds full-width forms itself
+		if r >= 0xFF01 && r <= 0xFF5E {
+			continue
+		}
+
+		// A rune is unstable if NFKC changes it on its own, or if it can combine with the rune before it
+		s := string(r)
+		if norm.NFKC.IsNormalString(s) && norm.NFKC.PropertiesString(s).BoundaryBefore() {
+			continue
+		}
+
+		if len(spans) > 0 && spans[len(spans)-1].hi == r-1 {
+			spans[len(spans)-1].hi = r
+		} else {
+			spans = append(spans, span{r, r})
+		}
+	}
+
+	fmt.Println("// Code generated by gen_tables.go; DO NOT EDIT.")
+	fmt.Println()
+	fmt.Println("package validation")
+	fmt.Println()
+	fmt.Println(`import "unicode"`)
+	fmt.Println()
+	fmt.Printf("// Letters and digits that NFKC normalization (Unicode %v) changes, or that can combine with the character before them\n", unicode.Version)
+	fmt.Println("var unstable = &unicode.RangeTable{")
+
+	latinOffset := 0
+	fmt.Println("\tR16: []unicode.Range16{")
+	for _, s := range spans {
+		if s.hi <= 0xFFFF {
+			fmt.Printf("\t\t{0x%04x, 0x%04x, 1},\n", s.lo, s.hi)
+			if s.hi <= unicode.MaxLatin1 {
+				latinOffset++
+			}
+		}
+	}
+	fmt.Println("\t},")
+	fmt.Println("\tR32: []unicode.Range32{")
+	for _, s := range spans {
+		if s.lo > 0xFFFF {
+			fmt.Printf("\t\t{0x%x, 0x%x, 1},\n", s.lo, s.hi)
+		}
+	}
+	fmt.Println("\t},")
+	fmt.Printf("\tLatinOffset: %v,\n", latinOffset)
+	fmt.Println("}")
+}
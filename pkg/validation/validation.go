@@ -0,0 +1,198 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Reason int
+
+const (
+	Reason_Empty Reason = iota + 1
+	Reason_TooShort
+	Reason_TooLong
+	Reason_InvalidCharacter
+	Reason_MixedScripts
+	Reason_Reserved
+	Reason_Confusable
+)
+
+// Explains why a username was refused; Reason lets callers react without parsing the message
+type ValidationError struct {
+	Reason  Reason
+	Message string
+}
+
+func (err *ValidationError) Error() string {
+	return "Invalid username: " + err.Message
+}
+
+type Policy struct {
+	// Length limits in characters, not bytes
+	MinLength int
+	MaxLength int
+
+	// Punctuation allowed besides letters and digits; spaces are never allowed since they split commands
+	AllowedPunctuation string
+
+	// Restricts names to ASCII letters and digits
+	ASCIIOnly bool
+
+	// Names that may not be used, or imitated with look-alike characters
+	Reserved []string
+}
+
+var DefaultReserved = []string{"server", "admin", "administrator", "root", "system", "operator", "moderator", "mod", "everyone", "here"}
+
+var DefaultPolicy = Policy{
+	MinLength:          1,
+	MaxLength:          16,
+	AllowedPunctuation: "_-.",
+	Reserved:           DefaultReserved,
+}
+
+// Reports whether no field of the policy has been set; Policy holds a slice, so it cannot be compared with ==
+func (policy Policy) IsZero() bool {
+	return policy.MinLength == 0 && policy.MaxLength == 0 && policy.AllowedPunctuation == "" && !policy.ASCIIOnly && policy.Reserved == nil
+}
+
+// Trims a name and folds full-width forms to ASCII
+// Names that pass Validate are then in NFKC form, since it refuses every other character that NFKC would change, such as ligatures and conjoining jamo
+func Normalize(name string) string {
+	name = strings.TrimSpace(name)
+
+	return strings.Map(func(r rune) rune {
+		if r >= 0xFF01 && r <= 0xFF5E {
+			return r - 0xFEE0
+		}
+		return r
+	}, name)
+}
+
+// Checks a username against the policy, returning it in normalized form
+func (policy Policy) Validate(name string) (string, error) {
+	name = Normalize(name)
+
+	if name == "" {
+		return "", &ValidationError{Reason: Reason_Empty, Message: "username cannot be empty"}
+	}
+	if !utf8.ValidString(name) {
+		return "", &ValidationError{Reason: Reason_InvalidCharacter, Message: "username is not valid UTF-8"}
+	}
+
+	length := utf8.RuneCountInString(name)
+	if policy.MaxLength > 0 && length > policy.MaxLength {
+		return "", &ValidationError{Reason: Reason_TooLong, Message: fmt.Sprintf("username must be %v characters or less", policy.MaxLength)}
+	}
+	if length < policy.MinLength {
+		return "", &ValidationError{Reason: Reason_TooShort, Message: fmt.Sprintf("username must be at least %v characters", policy.MinLength)}
+	}
+
+	for _, r := range name {
+		if !policy.Allows(r) {
+			return "", &ValidationError{Reason: Reason_InvalidCharacter, Message: fmt.Sprintf("username cannot contain %q", r)}
+		}
+	}
+
+	if MixedScripts(name) {
+		return "", &ValidationError{Reason: Reason_MixedScripts, Message: "username cannot mix letters from different alphabets"}
+	}
+
+	skeleton := Skeleton(name)
+	for _, reserved := range policy.Reserved {
+		if skeleton == Skeleton(reserved) {
+			return "", &ValidationError{Reason: Reason_Reserved, Message: fmt.Sprintf("%v is a reserved name", name)}
+		}
+	}
+
+	return name, nil
+}
+
+// Reports whether a username may contain the character
+// Letters and digits with another normalized spelling are refused, so every name has exactly one spelling
+func (policy Policy) Allows(r rune) bool {
+	if strings.ContainsRune(policy.AllowedPunctuation, r) {
+		return true
+	}
+	if policy.ASCIIOnly && r > unicode.MaxASCII {
+		return false
+	}
+	if unicode.Is(unstable, r) {
+		return false
+	}
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Scripts whose letters may not be mixed in one name; Japanese mixes its three scripts, so they count as one
+var scripts = []struct {
+	name   string
+	tables []*unicode.RangeTable
+}{
+	{"latin", []*unicode.RangeTable{unicode.Latin}},
+	{"cyrillic", []*unicode.RangeTable{unicode.Cyrillic}},
+	{"greek", []*unicode.RangeTable{unicode.Greek}},
+	{"armenian", []*unicode.RangeTable{unicode.Armenian}},
+	{"hebrew", []*unicode.RangeTable{unicode.Hebrew}},
+	{"arabic", []*unicode.RangeTable{unicode.Arabic}},
+	{"cjk", []*unicode.RangeTable{unicode.Han, unicode.Hiragana, unicode.Katakana}},
+	{"hangul", []*unicode.RangeTable{unicode.Hangul}},
+}
+
+// Reports whether a name combines letters from more than one script, the usual way of imitating another name
+func MixedScripts(name string) bool {
+	found := ""
+	for _, r := range name {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+
+		script := "other"
+		for _, s := range scripts {
+			if unicode.IsOneOf(s.tables, r) {
+				script = s.name
+				break
+			}
+		}
+
+		if found == "" {
+			found = script
+		} else if found != script {
+			return true
+		}
+	}
+	return false
+}
+
+// Letters and digits commonly used to imitate Latin letters
+var confusables = map[rune]rune{
+	'0': 'o', '1': 'l', '3': 'e', '5': 's', '|': 'l',
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'і': 'i', 'ј': 'j', 'к': 'k', 'м': 'm', 'н': 'h',
+	'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'һ': 'h', 'ԁ': 'd', 'ӏ': 'l',
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'τ': 't', 'υ': 'u', 'χ': 'x',
+}
+
+// Reduces a name to a form shared by names that look alike, ignoring case and punctuation
+// Two names with the same skeleton are too easily mistaken for one another
+func Skeleton(name string) string {
+	var builder strings.Builder
+	for _, r := range Normalize(name) {
+		// Capital I is indistinguishable from lowercase l in many fonts
+		if r == 'I' {
+			r = 'l'
+		}
+		r = unicode.ToLower(r)
+
+		if mapped, ok := confusables[r]; ok {
+			r = mapped
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			continue
+		}
+		builder.WriteRune(r)
+	}
+
+	return strings.ReplaceAll(builder.String(), "rn", "m")
+}
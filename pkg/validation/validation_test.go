@@ -0,0 +1,109 @@
+package validation
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		normalized string
+		reason     Reason
+	}{
+		{"alice", "alice", 0},
+		{"  bob_smith-2.0 ", "bob_smith-2.0", 0},
+		{"ｆｕｌｌ", "full", 0},
+		{"Ελένη", "Ελένη", 0},
+		{"\ud55c\uad6d", "\ud55c\uad6d", 0},
+		{"", "", Reason_Empty},
+		{"   ", "", Reason_Empty},
+		{"a-very-long-username", "", Reason_TooLong},
+		{"bob smith", "", Reason_InvalidCharacter},
+		{"bob!", "", Reason_InvalidCharacter},
+		{"é", "", Reason_InvalidCharacter},
+		{"\xff", "", Reason_InvalidCharacter},
+		{"\ufb01sh", "", Reason_InvalidCharacter},
+		{"\u1112\u1161\u11ab", "", Reason_InvalidCharacter},
+		{"\u00b5", "", Reason_InvalidCharacter},
+		{"\u210cello", "", Reason_InvalidCharacter},
+		{"pаypal", "", Reason_MixedScripts},
+		{"admin", "", Reason_Reserved},
+		{"AdMin", "", Reason_Reserved},
+		{"m0d", "", Reason_Reserved},
+		{"r00t", "", Reason_Reserved},
+	}
+
+	for _, test := range tests {
+		normalized, err := DefaultPolicy.Validate(test.name)
+		if test.reason == 0 {
+			if err != nil {
+				t.Errorf("Validate(%q) failed: %v", test.name, err)
+			} else if normalized != test.normalized {
+				t.Errorf("Validate(%q) = %q, expected %q", test.name, normalized, test.normalized)
+			}
+			continue
+		}
+
+		validationErr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("Validate(%q) = %q, %v, expected reason %v", test.name, normalized, err, test.reason)
+			continue
+		}
+		if validationErr.Reason != test.reason {
+			t.Errorf("Validate(%q) failed with reason %v, expected %v", test.name, validationErr.Reason, test.reason)
+		}
+	}
+}
+
+func TestPolicyOptions(t *testing.T) {
+	policy := Policy{MinLength: 3, ASCIIOnly: true}
+
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"ab", false},
+		{"abc", true},
+		{"averyveryverylongusernameindeed", true},
+		{"élan", false},
+		{"a_b", false},
+	}
+
+	for _, test := range tests {
+		_, err := policy.Validate(test.name)
+		if (err == nil) != test.valid {
+			t.Errorf("Validate(%q) returned %v, expected valid = %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestIsZero(t *testing.T) {
+	if !(Policy{}).IsZero() {
+		t.Error("The zero policy is not reported as zero")
+	}
+	if DefaultPolicy.IsZero() {
+		t.Error("The default policy is reported as zero")
+	}
+	if (Policy{ASCIIOnly: true}).IsZero() {
+		t.Error("A policy with only ASCIIOnly set is reported as zero")
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	alike := [][2]string{
+		{"alice", "Alice"},
+		{"Iris", "lris"},
+		{"modern", "modem"},
+		{"bob_1", "bobl"},
+		{"раураl", "paypal"},
+	}
+	for _, pair := range alike {
+		if Skeleton(pair[0]) != Skeleton(pair[1]) {
+			t.Errorf("%q and %q have different skeletons %q and %q", pair[0], pair[1], Skeleton(pair[0]), Skeleton(pair[1]))
+		}
+	}
+
+	if Skeleton("alice") == Skeleton("alicia") {
+		t.Error("Distinct names share a skeleton")
+	}
+}
@@ -0,0 +1,352 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/edobrowo/gochatroom/pkg/frame"
+)
+
+const (
+	// Appended to the client's key to prove the server understood the handshake (RFC 6455 section 1.3)
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+
+	// Status codes sent in close frames
+	CloseNormal      = 1000
+	CloseProtocol    = 1002
+	CloseTooLarge    = 1009
+	closeGracePeriod = time.Second
+
+	// Messages are held to the same limit as binary frames
+	MaxMessageSize = frame.MaxFrameSize
+)
+
+type WebSocketError struct {
+	Message string
+}
+
+func (err *WebSocketError) Error() string {
+	return err.Message
+}
+
+// A server-side WebSocket connection, presented as a byte stream so it can stand in for a TCP connection
+// Text messages are read back with a trailing newline, so a peer sending one JSON object per message speaks JSON lines
+// Each Write is sent as one message: text if it is valid UTF-8, binary otherwise
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// The unread remainder of the current message; only touched by the reading goroutine
+	pending []byte
+
+	// Guards writes, which come from both the writing goroutine and pongs sent while reading
+	mutex  sync.Mutex
+	closed bool
+}
+
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Completes the opening handshake and takes over the HTTP connection; on failure an HTTP error has been written
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "WebSocket upgrades must use GET", http.StatusMethodNotAllowed)
+		return nil, &WebSocketError{Message: "Upgrade request is not a GET"}
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, &WebSocketError{Message: "Request is not a WebSocket upgrade"}
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, &WebSocketError{Message: "Unsupported WebSocket version"}
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, &WebSocketError{Message: "Invalid Sec-WebSocket-Key"}
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Connection cannot be upgraded", http.StatusInternalServerError)
+		return nil, &WebSocketError{Message: "Response does not support hijacking"}
+	}
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	_, err = fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %v\r\n\r\n", accept)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// The HTTP server may already have buffered the client's first frames
+	return &Conn{conn: conn, reader: buffered.Reader}, nil
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		message, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = message
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Reads frames until a whole data message has arrived, answering control frames on the way
+func (c *Conn) readMessage() ([]byte, error) {
+	var message []byte
+	opcode := -1
+
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case opPing:
+			err = c.writeFrame(opPong, payload)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			// Echo the status code back, as the closing handshake requires
+			if len(payload) >= 2 {
+				payload = payload[:2]
+			}
+			c.sendClose(payload)
+			return nil, io.EOF
+		case opText, opBinary:
+			if opcode != -1 {
+				return nil, c.fail(CloseProtocol, "New message started before the previous one finished")
+			}
+			opcode = op
+			break
+		case opContinuation:
+			if opcode == -1 {
+				return nil, c.fail(CloseProtocol, "Continuation frame without a message")
+			}
+			break
+		default:
+			return nil, c.fail(CloseProtocol, fmt.Sprintf("Unknown opcode %v", op))
+		}
+
+		if len(message)+len(payload) > MaxMessageSize {
+			return nil, c.fail(CloseTooLarge, fmt.Sprintf("Message exceeds maximum of %v bytes", MaxMessageSize))
+		}
+		message = append(message, payload...)
+
+		if fin {
+			if opcode == opText {
+				if !utf8.Valid(message) {
+					return nil, c.fail(CloseProtocol, "Text message is not valid UTF-8")
+				}
+				message = append(message, '\n')
+			}
+			return message, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	op := int(header[0] & 0x0F)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocol, "Reserved bits set without a negotiated extension")
+	}
+
+	// Browsers must mask every frame they send
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(CloseProtocol, "Client frames must be masked")
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		_, err = io.ReadFull(c.reader, extended[:])
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+		break
+	case 127:
+		var extended [8]byte
+		_, err = io.ReadFull(c.reader, extended[:])
+		length = binary.BigEndian.Uint64(extended[:])
+		break
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	if op >= opClose && (length > 125 || !fin) {
+		return false, 0, nil, c.fail(CloseProtocol, "Control frames must be short and unfragmented")
+	}
+	if length > MaxMessageSize {
+		return false, 0, nil, c.fail(CloseTooLarge, fmt.Sprintf("Frame exceeds maximum of %v bytes", MaxMessageSize))
+	}
+
+	var mask [4]byte
+	_, err = io.ReadFull(c.reader, mask[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, op, payload, nil
+}
+
+// Server frames are never masked or fragmented
+func encodeFrame(op int, payload []byte) []byte {
+	header := make([]byte, 0, 10+len(payload))
+	header = append(header, 0x80|byte(op))
+
+	length := len(payload)
+	switch {
+	case length <= 125:
+		header = append(header, byte(length))
+		break
+	case length <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+		break
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+		break
+	}
+
+	return append(header, payload...)
+}
+
+func (c *Conn) writeFrame(op int, payload []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	_, err := c.conn.Write(encodeFrame(op, payload))
+	return err
+}
+
+// Sends a close frame, unless a write is already in progress, then closes the connection
+// Closing must not wait behind a write stalled on a slow reader, so the frame is skipped rather than queued
+func (c *Conn) sendClose(payload []byte) error {
+	if c.mutex.TryLock() {
+		if !c.closed {
+			c.closed = true
+			c.conn.SetWriteDeadline(time.Now().Add(closeGracePeriod))
+			c.conn.Write(encodeFrame(opClose, payload))
+		}
+		c.mutex.Unlock()
+	}
+	return c.conn.Close()
+}
+
+// Sends a close frame with a status code and reason, then closes the connection
+func (c *Conn) fail(code int, reason string) error {
+	c.closeWith(code, reason)
+	return &WebSocketError{Message: reason}
+}
+
+func (c *Conn) closeWith(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > 125 {
+		payload = payload[:125]
+	}
+	return c.sendClose(payload)
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	op := opBinary
+	if utf8.Valid(p) {
+		op = opText
+	}
+
+	err := c.writeFrame(op, p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *Conn) Close() error {
+	return c.closeWith(CloseNormal, "")
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,240 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The example handshake from RFC 6455 section 1.3
+const (
+	sampleKey    = "dGhlIHNhbXBsZSBub25jZQ=="
+	sampleAccept = "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+)
+
+// Starts an HTTP server that upgrades each request and echoes every line it reads back as one message
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(strings.TrimSuffix(line, "\n")))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server.Listener.Addr().String()
+}
+
+// Opens a raw connection and performs the client side of the handshake
+func dialEcho(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Key: "+sampleKey+"\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Handshake answered with %v", res.Status)
+	}
+	if accept := res.Header.Get("Sec-WebSocket-Accept"); accept != sampleAccept {
+		t.Fatalf("Sec-WebSocket-Accept is %q, expected %q", accept, sampleAccept)
+	}
+
+	return conn, reader
+}
+
+// Builds a masked client frame
+func clientFrame(fin bool, op int, payload []byte) []byte {
+	mask := [4]byte{0x37, 0xFA, 0x21, 0x3D}
+
+	first := byte(op)
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, 0x80|byte(len(payload)))
+		break
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+		break
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+		break
+	}
+
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// Reads a server frame, which must be unmasked
+func readServerFrame(t *testing.T, reader *bufio.Reader) (int, []byte) {
+	t.Helper()
+
+	var header [2]byte
+	_, err := io.ReadFull(reader, header[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header[0]&0x80 == 0 {
+		t.Fatal("Server frame is fragmented")
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("Server frame is masked")
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		io.ReadFull(reader, extended[:])
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+		break
+	case 127:
+		var extended [8]byte
+		io.ReadFull(reader, extended[:])
+		length = binary.BigEndian.Uint64(extended[:])
+		break
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(reader, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(header[0] & 0x0F), payload
+}
+
+func TestEcho(t *testing.T) {
+	conn, reader := dialEcho(t, startEchoServer(t))
+
+	// A fragmented text message with a ping between its frames, which must be answered first
+	conn.Write(clientFrame(false, opText, []byte("hello ")))
+	conn.Write(clientFrame(true, opPing, []byte("are you there")))
+	conn.Write(clientFrame(true, opContinuation, []byte("world")))
+
+	op, payload := readServerFrame(t, reader)
+	if op != opPong || string(payload) != "are you there" {
+		t.Errorf("Expected a pong echoing the ping, got opcode %v with %q", op, payload)
+	}
+	op, payload = readServerFrame(t, reader)
+	if op != opText || string(payload) != "hello world" {
+		t.Errorf("Expected the text echoed back, got opcode %v with %q", op, payload)
+	}
+
+	// Lengths past 125 and 65535 use the extended length fields
+	for _, size := range []int{126, 70000} {
+		message := strings.Repeat("x", size)
+		conn.Write(clientFrame(true, opText, []byte(message)))
+
+		op, payload = readServerFrame(t, reader)
+		if op != opText || string(payload) != message {
+			t.Errorf("Message of %v bytes echoed as opcode %v with %v bytes", size, op, len(payload))
+		}
+	}
+
+	// The close handshake echoes the status code
+	conn.Write(clientFrame(true, opClose, binary.BigEndian.AppendUint16(nil, CloseNormal)))
+	op, payload = readServerFrame(t, reader)
+	if op != opClose || binary.BigEndian.Uint16(payload) != CloseNormal {
+		t.Errorf("Expected a normal close, got opcode %v with %v", op, payload)
+	}
+}
+
+func TestUnmaskedFrame(t *testing.T) {
+	conn, reader := dialEcho(t, startEchoServer(t))
+
+	frame := clientFrame(true, opText, []byte("hi"))
+	frame[1] &^= 0x80
+	frame = append(frame[:2], frame[6:]...)
+	conn.Write(frame)
+
+	op, payload := readServerFrame(t, reader)
+	if op != opClose || binary.BigEndian.Uint16(payload) != CloseProtocol {
+		t.Errorf("Expected a protocol error close, got opcode %v with %v", op, payload)
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	conn, reader := dialEcho(t, startEchoServer(t))
+
+	conn.Write(clientFrame(true, opText, []byte{0xFF, 0xFE}))
+
+	op, payload := readServerFrame(t, reader)
+	if op != opClose || binary.BigEndian.Uint16(payload) != CloseProtocol {
+		t.Errorf("Expected a protocol error close, got opcode %v with %v", op, payload)
+	}
+}
+
+func TestUpgradeRefused(t *testing.T) {
+	addr := startEchoServer(t)
+
+	tests := []struct {
+		method   string
+		header   map[string]string
+		expected int
+	}{
+		{http.MethodPost, map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": sampleKey}, http.StatusMethodNotAllowed},
+		{http.MethodGet, map[string]string{"Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": sampleKey}, http.StatusBadRequest},
+		{http.MethodGet, map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": sampleKey}, http.StatusUpgradeRequired},
+		{http.MethodGet, map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "short"}, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, "http://"+addr+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range test.header {
+			req.Header.Set(name, value)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != test.expected {
+			t.Errorf("%v with %v answered %v, expected %v", test.method, test.header, res.StatusCode, test.expected)
+		}
+	}
+}